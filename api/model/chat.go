@@ -1,12 +1,14 @@
 package model
 
+import "encoding/json"
+
 // request
 
 type ChatRequestBody struct {
 	Model            string             `json:"model"`
 	Messages         []Message          `json:"messages"`
 	Functions        []Function         `json:"functions,omitempty"`
-	FunctionCall     string             `json:"function_call,omitempty"`
+	FunctionCall     interface{}        `json:"function_call,omitempty"`     // "none", "auto" or {"name": "my_function"}
 	Temperature      float64            `json:"temperature,omitempty"`       // default 1
 	TopP             float64            `json:"top_p,omitempty"`             // default 1
 	N                int64              `json:"n,omitempty"`                 // default 1
@@ -20,17 +22,22 @@ type ChatRequestBody struct {
 }
 
 type Message struct {
-	Role         string `json:"role"`
-	Content      string `json:"content"`
-	Name         string `json:"name,omitempty"`
-	FunctionCall string `json:"function_call,omitempty"`
+	Role         string        `json:"role"`
+	Content      string        `json:"content"`
+	Name         string        `json:"name,omitempty"`
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
 }
 
 type Function struct {
-	Name         string `json:"name"`
-	Description  string `json:"description"`
-	Parameters   string `json:"parameters"`
-	FunctionCall string `json:"function_call"`
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"` // JSON Schema object
+}
+
+// FunctionCall is the function the model decided to call, with JSON encoded arguments.
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
 }
 
 // response
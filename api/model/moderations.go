@@ -0,0 +1,16 @@
+package model
+
+type ModerationsRequestBody struct {
+	Model string      `json:"model,omitempty"`
+	Input interface{} `json:"input"`
+}
+
+type ModerationsResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Results []struct {
+		Flagged        bool               `json:"flagged"`
+		Categories     map[string]bool    `json:"categories"`
+		CategoryScores map[string]float64 `json:"category_scores"`
+	} `json:"results"`
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	onErrorFail   = "fail"
+	onErrorIgnore = "ignore"
+	onErrorGoto   = "goto"
+
+	// errorKey is the memory key holding the message of the last routed error
+	errorKey = "_error"
+
+	httpTimeout     = 10 * time.Second
+	maxResponseSize = 1 << 20
+)
+
+var errUnknownFunction = errors.New("unknown function")
+
+// Actions are the steps of a before or after hook, executed in order. A hook
+// is written as a single call, "print({header})", or as a list of actions.
+type Actions []Action
+
+// Action is one step of a hook: exactly one of call, set, unset, increment
+// and http. A failing action fails the state unless on-error says otherwise.
+type Action struct {
+	Call      string      `yaml:"call" json:"call,omitempty" toml:"call,omitempty"`
+	Set       string      `yaml:"set" json:"set,omitempty" toml:"set,omitempty"`
+	Unset     string      `yaml:"unset" json:"unset,omitempty" toml:"unset,omitempty"`
+	Increment string      `yaml:"increment" json:"increment,omitempty" toml:"increment,omitempty"`
+	HTTP      *HTTPAction `yaml:"http" json:"http,omitempty" toml:"http,omitempty"`
+
+	// OnError is fail (the default), ignore or goto; goto jumps to Goto, which
+	// implies it when set
+	OnError string `yaml:"on-error" json:"on-error,omitempty" toml:"on-error,omitempty"`
+	Goto    *int64 `yaml:"goto" json:"goto,omitempty" toml:"goto,omitempty"`
+}
+
+// HTTPAction calls an HTTP endpoint. {var} placeholders in the URL, headers
+// and body are replaced with memory values, escaped in the URL.
+type HTTPAction struct {
+	Method  string            `yaml:"method" json:"method,omitempty" toml:"method,omitempty"`
+	URL     string            `yaml:"url" json:"url" toml:"url"`
+	Headers map[string]string `yaml:"headers" json:"headers,omitempty" toml:"headers,omitempty"`
+	Body    string            `yaml:"body" json:"body,omitempty" toml:"body,omitempty"`
+	Save    string            `yaml:"save" json:"save,omitempty" toml:"save,omitempty"` // memory key for the response body
+}
+
+// UnmarshalJSON accepts a single call as well as a list of actions.
+func (aa *Actions) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aa = Actions{{Call: single}}
+		return nil
+	}
+
+	var list []Action
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*aa = list
+
+	return nil
+}
+
+// MarshalJSON writes a hook that is a single call back as a string.
+func (aa Actions) MarshalJSON() ([]byte, error) {
+	if len(aa) == 1 && aa[0].isCall() {
+		return json.Marshal(aa[0].Call)
+	}
+
+	return json.Marshal([]Action(aa))
+}
+
+// UnmarshalJSON accepts a call written as a string in a list of actions.
+func (a *Action) UnmarshalJSON(data []byte) error {
+	var call string
+	if err := json.Unmarshal(data, &call); err == nil {
+		*a = Action{Call: call}
+		return nil
+	}
+
+	type plain Action
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+func (a Action) MarshalJSON() ([]byte, error) {
+	if a.isCall() {
+		return json.Marshal(a.Call)
+	}
+
+	type plain Action
+	return json.Marshal(plain(a))
+}
+
+func (a Action) isCall() bool {
+	return a.Call != "" && a == Action{Call: a.Call}
+}
+
+func (a Action) policy() string {
+	if a.OnError == "" && a.Goto != nil {
+		return onErrorGoto
+	}
+	if a.OnError == "" {
+		return onErrorFail
+	}
+
+	return a.OnError
+}
+
+// expr returns the call or assignment the action evaluates, if any.
+func (a Action) expr() string {
+	if a.Call != "" {
+		return a.Call
+	}
+
+	return a.Set
+}
+
+func (a Action) String() string {
+	switch {
+	case a.Call != "":
+		return a.Call
+	case a.Set != "":
+		return "set " + a.Set
+	case a.Unset != "":
+		return "unset " + a.Unset
+	case a.Increment != "":
+		return "increment " + a.Increment
+	case a.HTTP != nil:
+		return "http " + a.HTTP.method() + " " + a.HTTP.URL
+	}
+
+	return "nothing"
+}
+
+func (h *HTTPAction) method() string {
+	if h.Method == "" {
+		return http.MethodGet
+	}
+
+	return strings.ToUpper(h.Method)
+}
+
+// setActions turns a state's set assignments into actions.
+func setActions(set assignments) Actions {
+	actions := make(Actions, 0, len(set))
+	for _, assignment := range set {
+		actions = append(actions, Action{Set: assignment})
+	}
+
+	return actions
+}
+
+// actions executes a hook's actions in order and reports whether one of them
+// failed with a goto policy and moved the session to another state.
+func (e *Engine) actions(s *Session, stateID int64, stage string, actions Actions) (bool, error) {
+	for _, a := range actions {
+		err := e.act(s, stateID, stage, a)
+		if err == nil {
+			continue
+		}
+
+		switch a.policy() {
+		case onErrorIgnore:
+			continue
+		case onErrorGoto:
+			if a.Goto != nil {
+				s.Memory[errorKey] = e.redact(s.Memory, err.Error())
+				e.jump(s, stateID, *a.Goto)
+				return true, nil
+			}
+		}
+
+		return false, fmt.Errorf("state %d: %s: %w", stateID, stage, err)
+	}
+
+	return false, nil
+}
+
+func (e *Engine) act(s *Session, stateID int64, stage string, a Action) error {
+	var arg string
+	var err error
+	skipped := false
+
+	if e.breakHook != nil {
+		e.breakHook(s, HookCall{StateID: stateID, Stage: stage, Hook: a.String()})
+	}
+
+	switch {
+	case a.expr() != "":
+		arg, err = e.call(s, a.expr())
+		if errors.Is(err, errUnknownFunction) {
+			err, skipped = nil, true
+		}
+	case a.Unset != "":
+		delete(s.Memory, a.Unset)
+	case a.Increment != "":
+		arg, err = increment(s.Memory, a.Increment)
+	case a.HTTP != nil:
+		arg, err = e.request(s, a.HTTP)
+	}
+
+	h := HookCall{StateID: stateID, Stage: stage, Hook: a.String(), Arg: e.redact(s.Memory, arg), Skipped: skipped}
+	if err != nil {
+		h.Err = e.redactError(s.Memory, err)
+	}
+	e.notify(func(o Observer) { o.HookExecuted(s, h) })
+
+	return err
+}
+
+func increment(m memory, key string) (string, error) {
+	var n int64
+	if v := strings.TrimSpace(m[key]); v != "" {
+		var err error
+		if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return v, fmt.Errorf("%s is %q, not a counter", key, v)
+		}
+	}
+
+	m[key] = strconv.FormatInt(n+1, 10)
+
+	return m[key], nil
+}
+
+// request calls the endpoint and saves the response body. In dry runs nothing
+// is sent.
+func (e *Engine) request(s *Session, h *HTTPAction) (string, error) {
+	method, target := h.method(), renderURL(h.URL, s.Memory)
+	call := method + " " + target
+	if e.dryRun {
+		return call, nil
+	}
+
+	req, err := http.NewRequest(method, target, strings.NewReader(render(h.Body, s.Memory)))
+	if err != nil {
+		return call, err
+	}
+	for k, v := range h.Headers {
+		req.Header.Set(k, render(v, s.Memory))
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return call, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return call, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return call, fmt.Errorf("%s: %s", call, resp.Status)
+	}
+
+	if h.Save != "" {
+		s.Memory[h.Save] = strings.TrimSpace(string(data))
+	}
+
+	return call, nil
+}
+
+// renderURL replaces the {var} placeholders of the URL with their memory
+// values, escaped for the path or the query they are in, so values can't
+// change the rest of the URL.
+func renderURL(template string, m memory) string {
+	query := strings.IndexAny(template, "?#")
+
+	var b strings.Builder
+	last := 0
+	for _, match := range placeholderRe.FindAllStringSubmatchIndex(template, -1) {
+		value := m[template[match[2]:match[3]]]
+		if query >= 0 && match[0] > query {
+			value = url.QueryEscape(value)
+		} else {
+			value = url.PathEscape(value)
+		}
+
+		b.WriteString(template[last:match[0]])
+		b.WriteString(value)
+		last = match[1]
+	}
+	b.WriteString(template[last:])
+
+	return b.String()
+}
+
+// jump moves the session to another state because of an error.
+func (e *Engine) jump(s *Session, from, to int64) {
+	e.follow(s, Transition{From: from, To: to, Edge: edgeError})
+}
+
+// recover routes an error raised in a state to the flow's error state, with
+// the message in {_error}. Errors of flows without an error state, or raised
+// in the error state itself, are returned.
+func (e *Engine) recover(s *Session, stateID int64, err error) error {
+	if e.states.OnError == nil || *e.states.OnError == stateID {
+		return err
+	}
+
+	e.reportError(s, err)
+	s.Memory[errorKey] = e.redact(s.Memory, err.Error())
+	e.jump(s, stateID, *e.states.OnError)
+
+	return nil
+}
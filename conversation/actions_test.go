@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActions_UnmarshalAndMarshal(t *testing.T) {
+	states, err := parseStates([]byte(`
+states:
+  - id: 0
+    before: "print({header})"
+    after:
+      - "log({name})"
+      - set: greeting = 'hi'
+      - unset: draft
+        on-error: ignore
+      - increment: visits
+        goto: 1
+  - id: 1
+`), ".yml")
+	assert.NoError(t, err)
+
+	state := states.GetState(0)
+	before, _ := json.Marshal(state.Before)
+	after, _ := json.Marshal(state.After)
+
+	// Assertions
+	assert.Equal(t, Actions{{Call: "print({header})"}}, state.Before)
+	assert.Len(t, state.After, 4)
+	assert.Equal(t, "ignore", state.After[2].policy())
+	assert.Equal(t, "goto", state.After[3].policy())
+	assert.Equal(t, `"print({header})"`, string(before))
+	assert.JSONEq(t, `["log({name})", {"set": "greeting = 'hi'"}, {"unset": "draft", "on-error": "ignore"}, {"increment": "visits", "goto": 1}]`, string(after))
+}
+
+func TestParseStates_InvalidAction(t *testing.T) {
+	_, err := parseStates([]byte(`
+states:
+  - id: 0
+    before:
+      - drop: name
+      - unset: name
+        on-error: retry
+`), ".yml")
+
+	// Assertions
+	assert.EqualError(t, err, `invalid conversation:
+  states[0].before[0].drop: unknown property
+  states[0].before[1].on-error: must be one of [fail ignore goto]`)
+}
+
+func TestEngine_ChainedActions(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    before:
+      - set: status = 'open'
+      - increment: visits
+      - set: label = upper({status})
+      - unset: draft
+    text: "{label} {visits} {draft}"
+`)
+	session := NewSession()
+	session.Memory["draft"] = "x"
+
+	texts, err := engine.Start(session)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"OPEN 1 "}, texts)
+	assert.Equal(t, memory{"status": "open", "visits": "1", "label": "OPEN"}, session.Memory)
+}
+
+const errorPolicyFlowYAML = `
+on-error: 90
+states:
+  - id: 0
+    before:
+      - call: fail('ignored')
+        on-error: ignore
+      - set: step = 'one'
+    text: "Order number?"
+    input: order
+    after:
+      - call: fail('lookup')
+        goto: 80
+      - set: step = 'two'
+    next:
+      right: 1
+  - id: 1
+    before: fail('unexpected')
+    text: "Found it"
+  - id: 80
+    text: "Lookup failed: {_error}"
+    next:
+      right: 1
+  - id: 90
+    text: "Sorry, something went wrong: {_error}"
+`
+
+func TestEngine_ErrorPolicies(t *testing.T) {
+	engine := newTestEngine(t, errorPolicyFlowYAML)
+	engine.functions = functions{"fail": func(what string) error { return errors.New(what + " failed") }}
+
+	recorder := &TraceRecorder{}
+	engine.Observe(recorder)
+
+	session := NewSession()
+	texts, err := engine.Start(session)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Order number?"}, texts)
+	assert.Equal(t, "one", session.Memory["step"])
+
+	texts, err = engine.Answer(session, "42")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"Lookup failed: lookup failed",
+		"Sorry, something went wrong: state 1: before: unexpected failed",
+	}, texts)
+	assert.Equal(t, "one", session.Memory["step"])
+	assert.True(t, session.Done)
+	assert.Contains(t, recorder.Events, TraceEvent{Type: eventTransition, StateID: 0, To: 80, Edge: edgeError})
+	assert.Contains(t, recorder.Events, TraceEvent{Type: eventError, StateID: 1, Err: "state 1: before: unexpected failed"})
+}
+
+func TestEngine_ErrorWithoutErrorState(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    before: "total = div(1, 0)"
+`)
+
+	_, err := engine.Start(NewSession())
+
+	// Assertions
+	assert.EqualError(t, err, "state 0: before: division by zero")
+}
+
+func TestEngine_HTTPAction(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, body = r, nil
+		body, _ = io.ReadAll(r.Body)
+		if r.URL.Path == "/orders/404" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("shipped\n"))
+	}))
+	defer server.Close()
+
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    text: "Order number?"
+    input: order
+    after:
+      - http:
+          method: post
+          url: "`+server.URL+`/orders/{order}"
+          headers:
+            X-Order: "{order}"
+          body: '{"order": "{order}"}'
+          save: status
+        goto: 2
+    next:
+      right: 1
+  - id: 1
+    text: "Your order is {status}"
+  - id: 2
+    text: "No such order ({_error})"
+`)
+
+	session := NewSession()
+	_, _ = engine.Start(session)
+	texts, err := engine.Answer(session, "42")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Your order is shipped"}, texts)
+	assert.Equal(t, http.MethodPost, got.Method)
+	assert.Equal(t, "42", got.Header.Get("X-Order"))
+	assert.Equal(t, `{"order": "42"}`, string(body))
+
+	session = NewSession()
+	_, _ = engine.Start(session)
+	texts, err = engine.Answer(session, "404")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"No such order (POST " + server.URL + "/orders/404: 404 Not Found)"}, texts)
+}
+
+func TestEngine_HTTPActionEscapesValues(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer server.Close()
+
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    input: name
+    after:
+      - http:
+          url: "`+server.URL+`/users/{name}?q={name}&page=1"
+    next:
+      right: 1
+  - id: 1
+`)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	_, err := engine.Answer(session, "a/b?c&x=1#d")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, "/users/a/b?c&x=1#d", got.URL.Path)
+	assert.Equal(t, "/users/a%2Fb%3Fc&x=1%23d", got.URL.EscapedPath())
+	assert.Equal(t, url.Values{"q": {"a/b?c&x=1#d"}, "page": {"1"}}, got.URL.Query())
+}
+
+func TestRenderURL(t *testing.T) {
+	m := memory{"id": "4 2", "q": "tea&x=1"}
+
+	// Assertions
+	assert.Equal(t, "https://api.example.com/orders/4%202?q=tea%26x%3D1", renderURL("https://api.example.com/orders/{id}?q={q}", m))
+	assert.Equal(t, "/search#tea%26x%3D1", renderURL("/search#{q}", m))
+	assert.Equal(t, "/plain", renderURL("/plain", m))
+}
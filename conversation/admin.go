@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// defaultAdminTranscript is how many transcript entries a session view shows
+// unless ?last= says otherwise.
+const defaultAdminTranscript = 20
+
+// adminSession is a live session as seen by the admin API. Secret values are
+// masked.
+type adminSession struct {
+	ID         string            `json:"id"`
+	Flow       string            `json:"flow"`
+	StateID    int64             `json:"state"`
+	Done       bool              `json:"done"`
+	Handoff    *handoffStatus    `json:"handoff,omitempty"`
+	Updated    time.Time         `json:"updated"` // time of the last transcript entry
+	Seq        int               `json:"seq"`
+	Memory     memory            `json:"memory,omitempty"`
+	Transcript []transcriptEntry `json:"transcript,omitempty"`
+}
+
+type adminRequest struct {
+	Flow    string             `json:"flow"` // limits a broadcast to the sessions of the flow
+	Text    string             `json:"text"`
+	StateID *int64             `json:"state"`
+	Memory  map[string]*string `json:"memory"` // null removes the key
+	Turns   int                `json:"turns"`
+	Seq     *int               `json:"seq"` // when set, the session must not have changed since
+}
+
+// adminRoutes registers the admin API, which is only served with a token.
+func (srv *server) adminRoutes(e *echo.Echo, token string) {
+	if token == "" {
+		return
+	}
+
+	g := e.Group("/admin", tokenAuth("admin", token))
+	g.GET("/sessions", srv.handleAdminList)
+	g.GET("/sessions/:id", srv.handleAdminView)
+	g.GET("/sessions/:id/events", srv.handleAdminEvents)
+	g.POST("/sessions/:id/undo", srv.handleAdminUndo)
+	g.PATCH("/sessions/:id/memory", srv.handleAdminMemory)
+	g.POST("/sessions/:id/jump", srv.handleAdminJump)
+	g.DELETE("/sessions/:id", srv.handleAdminTerminate)
+	g.POST("/broadcast", srv.handleAdminBroadcast)
+}
+
+// tokenAuth accepts requests with the token of the API as bearer token.
+func tokenAuth(api, token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			got := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid "+api+" token")
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func (srv *server) viewAdmin(ls *liveSession, last int) adminSession {
+	return srv.viewSession(ls, ls.session, last)
+}
+
+// viewSession shows s, the live session or one of its past versions, with its
+// memory and last transcript entries when last is positive.
+func (srv *server) viewSession(ls *liveSession, s *Session, last int) adminSession {
+	view := adminSession{
+		ID:      ls.id,
+		Flow:    ls.flowName(),
+		StateID: s.StateID,
+		Done:    s.Done,
+		Handoff: s.Handoff,
+		Seq:     s.Seq(),
+	}
+	if n := len(s.Transcript); n > 0 {
+		view.Updated = s.Transcript[n-1].Time
+	}
+
+	if last > 0 {
+		view.Memory = ls.engine().redactMemory(s.Memory)
+		transcript := s.Transcript
+		if len(transcript) > last {
+			transcript = transcript[len(transcript)-last:]
+		}
+		view.Transcript = append([]transcriptEntry{}, transcript...)
+	}
+
+	return view
+}
+
+// handleAdminList lists the sessions that are not over, most recently active
+// first. ?all=true includes finished ones, ?flow= filters by flow.
+func (srv *server) handleAdminList(c echo.Context) error {
+	all, flow := c.QueryParam("all") == "true", c.QueryParam("flow")
+
+	sessions := make([]adminSession, 0)
+	srv.sessions.each(func(ls *liveSession) {
+		if (all || !ls.session.Done) && (flow == "" || ls.flowName() == flow) {
+			sessions = append(sessions, srv.viewAdmin(ls, 0))
+		}
+	})
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Updated.After(sessions[j].Updated) })
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// handleAdminView shows a session with its memory and the last ?last=n
+// transcript entries. With ?at=seq it shows the session as it was right after
+// that event.
+func (srv *server) handleAdminView(c echo.Context) error {
+	last, err := intParam(c, "last", defaultAdminTranscript, 1)
+	if err != nil {
+		return err
+	}
+	at, err := intParam(c, "at", -1, 0)
+	if err != nil {
+		return err
+	}
+
+	return srv.withSession(c, func(ls *liveSession) error {
+		if at < 0 {
+			return c.JSON(http.StatusOK, srv.viewAdmin(ls, last))
+		}
+
+		past, err := ls.session.At(at)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		return c.JSON(http.StatusOK, srv.viewSession(ls, past, last))
+	})
+}
+
+// handleAdminEvents returns the session's history after ?after=seq, with
+// secret values masked.
+func (srv *server) handleAdminEvents(c echo.Context) error {
+	after, err := intParam(c, "after", 0, 0)
+	if err != nil {
+		return err
+	}
+
+	return srv.withSession(c, func(ls *liveSession) error {
+		secrets := ls.engine().states.secrets()
+
+		events := make([]SessionEvent, 0)
+		for _, ev := range ls.session.Events {
+			if ev.Seq <= after {
+				continue
+			}
+			if ev.Type == sessionMemory && ev.Value != nil && secrets[ev.Key] {
+				mask := secretMask
+				ev.Value = &mask
+			}
+			events = append(events, ev)
+		}
+
+		return c.JSON(http.StatusOK, events)
+	})
+}
+
+// handleAdminUndo takes back the session's last turns, one by default.
+func (srv *server) handleAdminUndo(c echo.Context) error {
+	var req adminRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Turns == 0 {
+		req.Turns = 1
+	}
+
+	return srv.withSession(c, func(ls *liveSession) error {
+		if err := checkSeq(ls.session, req.Seq); err != nil {
+			return err
+		}
+		if err := ls.engine().Undo(ls.session, req.Turns); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		srv.schedule(ls)
+
+		return c.JSON(http.StatusOK, srv.viewAdmin(ls, defaultAdminTranscript))
+	})
+}
+
+// intParam parses the query parameter, which must be at least min.
+func intParam(c echo.Context, name string, fallback, min int) (int, error) {
+	p := c.QueryParam(name)
+	if p == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(p)
+	if err != nil || n < min {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be an integer of at least %d", name, min))
+	}
+
+	return n, nil
+}
+
+func (srv *server) handleAdminMemory(c echo.Context) error {
+	var req adminRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(req.Memory) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "required parameters are not set (required: memory)")
+	}
+
+	return srv.withSession(c, func(ls *liveSession) error {
+		if err := checkSeq(ls.session, req.Seq); err != nil {
+			return err
+		}
+
+		for k, v := range req.Memory {
+			if v == nil {
+				delete(ls.session.Memory, k)
+				continue
+			}
+			ls.session.Memory[k] = *v
+		}
+		ls.session.sync()
+
+		return c.JSON(http.StatusOK, srv.viewAdmin(ls, defaultAdminTranscript))
+	})
+}
+
+// handleAdminJump moves the session to a state, dropping whatever it was
+// waiting for, and returns the texts the state shows.
+func (srv *server) handleAdminJump(c echo.Context) error {
+	var req adminRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.StateID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "required parameters are not set (required: state)")
+	}
+
+	return srv.withSession(c, func(ls *liveSession) error {
+		if err := checkSeq(ls.session, req.Seq); err != nil {
+			return err
+		}
+
+		texts, err := ls.engine().Goto(ls.session, *req.StateID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		srv.schedule(ls)
+
+		return c.JSON(http.StatusOK, srv.reply(ls, texts))
+	})
+}
+
+// handleAdminTerminate ends the session and forgets it.
+func (srv *server) handleAdminTerminate(c echo.Context) error {
+	return srv.withSession(c, func(ls *liveSession) error {
+		ls.session.Done = true
+		ls.session.sync()
+		srv.sessions.remove(ls.id)
+		srv.timers.cancel(ls.id)
+
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+// handleAdminBroadcast records a message in every session that is not over,
+// or only in those of the given flow. Clients pick it up when polling the
+// history.
+func (srv *server) handleAdminBroadcast(c echo.Context) error {
+	var req adminRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Text == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "required parameters are not set (required: text)")
+	}
+
+	sent := 0
+	srv.sessions.each(func(ls *liveSession) {
+		if !ls.session.Done && (req.Flow == "" || ls.flowName() == req.Flow) {
+			ls.session.record(roleAdmin, req.Text)
+			sent++
+		}
+	})
+
+	return c.JSON(http.StatusOK, map[string]int{"sessions": sent})
+}
+
+// withSession runs fn with the locked session of the request.
+func (srv *server) withSession(c echo.Context, fn func(ls *liveSession) error) error {
+	ls := srv.sessions.get(c.Param("id"))
+	if ls == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "session not found")
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	return fn(ls)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAdminToken = "s3cret"
+
+func newTestAdminServer(t *testing.T, flow string) *echo.Echo {
+	srv, e := newTestServer(t, flow)
+	srv.adminRoutes(e, testAdminToken)
+
+	return e
+}
+
+// doAdmin is do with the admin token.
+func doAdmin(t *testing.T, e *echo.Echo, method, path, body string, out interface{}) *httptest.ResponseRecorder {
+	return doAuth(t, e, testAdminToken, method, path, body, out)
+}
+
+// doAuth is do with the token as bearer token.
+func doAuth(t *testing.T, e *echo.Echo, token, method, path, body string, out interface{}) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if out != nil {
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), out))
+	}
+
+	return rec
+}
+
+func TestAdminAPI_Auth(t *testing.T) {
+	e := newTestAdminServer(t, handoffFlowYAML)
+
+	rec := do(t, e, http.MethodGet, "/admin/sessions", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doAdmin(t, e, http.MethodGet, "/admin/sessions", "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// without a token the admin API isn't served
+	_, e = newTestServer(t, handoffFlowYAML)
+	rec = doAdmin(t, e, http.MethodGet, "/admin/sessions", "", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminAPI_Sessions(t *testing.T) {
+	e := newTestAdminServer(t, handoffFlowYAML)
+
+	var started sessionReply
+	do(t, e, http.MethodPost, "/sessions", "", &started)
+	do(t, e, http.MethodPost, "/sessions", "", nil)
+
+	var sessions []adminSession
+	rec := doAdmin(t, e, http.MethodGet, "/admin/sessions", "", &sessions)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, sessions, 2)
+
+	path := "/admin/sessions/" + started.ID
+
+	var view adminSession
+	rec = doAdmin(t, e, http.MethodPatch, path+"/memory", `{"memory": {"problem": "stuck", "other": null}}`, &view)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, memory{"problem": "stuck"}, view.Memory)
+
+	var reply sessionReply
+	rec = doAdmin(t, e, http.MethodPost, path+"/jump", `{"state": 2}`, &reply)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"Back to the bot. Anything else?"}, reply.Messages)
+
+	rec = doAdmin(t, e, http.MethodPost, path+"/jump", `{"state": 42}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doAdmin(t, e, http.MethodGet, path+"?last=1", "", &view)
+
+	// Assertions
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(2), view.StateID)
+	assert.Equal(t, []transcriptEntry{{Role: roleBot, Text: "Back to the bot. Anything else?", Time: view.Updated}}, view.Transcript)
+
+	rec = doAdmin(t, e, http.MethodDelete, path, "", nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = doAdmin(t, e, http.MethodGet, path, "", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do(t, e, http.MethodPost, "/sessions/"+started.ID+"/messages", `{"text": "hi"}`, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminAPI_Broadcast(t *testing.T) {
+	e := newTestAdminServer(t, handoffFlowYAML)
+
+	var first, second sessionReply
+	do(t, e, http.MethodPost, "/sessions", "", &first)
+	do(t, e, http.MethodPost, "/sessions", "", &second)
+	do(t, e, http.MethodPost, "/sessions/"+second.ID+"/messages", `{"text": "late"}`, nil)
+	doAdmin(t, e, http.MethodPost, "/admin/sessions/"+second.ID+"/jump", `{"state": 3}`, nil)
+
+	var sent map[string]int
+	rec := doAdmin(t, e, http.MethodPost, "/admin/broadcast", `{"text": "Maintenance at 10pm"}`, &sent)
+
+	var history struct {
+		Messages []transcriptEntry `json:"messages"`
+	}
+	do(t, e, http.MethodGet, "/sessions/"+first.ID+"/messages?after=1", "", &history)
+
+	// Assertions
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, map[string]int{"sessions": 1}, sent)
+	assert.Len(t, history.Messages, 1)
+	assert.Equal(t, roleAdmin, history.Messages[0].Role)
+	assert.Equal(t, "Maintenance at 10pm", history.Messages[0].Text)
+
+	rec = doAdmin(t, e, http.MethodPost, "/admin/broadcast", `{}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAdminAPI_History(t *testing.T) {
+	e := newTestAdminServer(t, secretsFlowYAML)
+
+	var started, reply sessionReply
+	do(t, e, http.MethodPost, "/sessions", "", &started)
+	path := "/admin/sessions/" + started.ID
+	do(t, e, http.MethodPost, "/sessions/"+started.ID+"/messages", `{"text": "1234"}`, &reply)
+
+	// a writer that missed the last answer is turned away
+	rec := do(t, e, http.MethodPost, "/sessions/"+started.ID+"/messages", `{"text": "hi", "seq": 1}`, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	var events []SessionEvent
+	rec = doAdmin(t, e, http.MethodGet, path+"/events", "", &events)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, events, reply.Seq)
+	for _, ev := range events {
+		assert.NotContains(t, ev.Text, "1234")
+		if ev.Value != nil {
+			assert.NotEqual(t, "1234", *ev.Value)
+		}
+	}
+
+	var past adminSession
+	rec = doAdmin(t, e, http.MethodGet, path+"?at="+strconv.Itoa(started.Seq), "", &past)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(0), past.StateID)
+	assert.Empty(t, past.Memory)
+
+	var undone adminSession
+	rec = doAdmin(t, e, http.MethodPost, path+"/undo", `{"seq": `+strconv.Itoa(reply.Seq)+`}`, &undone)
+
+	// Assertions
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(0), undone.StateID)
+	assert.Equal(t, reply.Seq+1, undone.Seq)
+
+	rec = doAdmin(t, e, http.MethodPost, path+"/undo", `{}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doAdmin(t, e, http.MethodGet, path+"?at=999", "", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -1,17 +1,39 @@
 package main
 
 import (
-	"bufio"
+	"encoding/json"
 	"fmt"
-	"gopkg.in/yaml.v3"
-	"io"
 	"os"
-	"regexp"
+	"path/filepath"
 	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
 )
 
 type States struct {
-	States []State `json:"states"`
+	// Version and Description tell flows apart in the flow registry
+	Version     string `yaml:"version" json:"version,omitempty" toml:"version,omitempty"`
+	Description string `yaml:"description" json:"description,omitempty" toml:"description,omitempty"`
+
+	// Start is the state conversations start at, 0 by default
+	Start int64 `yaml:"start" json:"start,omitempty" toml:"start,omitempty"`
+
+	// Expects lists the memory keys flows transferring to this one must carry
+	Expects []string `yaml:"expects" json:"expects,omitempty" toml:"expects,omitempty"`
+
+	// Timezone is the IANA timezone relative dates are read in, UTC by default
+	Timezone string `yaml:"timezone" json:"timezone,omitempty" toml:"timezone,omitempty"`
+
+	States []State `yaml:"states" json:"states" toml:"states"`
+
+	// OnError is the state errors raised in other states are routed to
+	OnError *int64 `yaml:"on-error" json:"on-error,omitempty" toml:"on-error,omitempty"`
+
+	Fallback  *Fallback  `yaml:"fallback" json:"fallback,omitempty" toml:"fallback,omitempty"`
+	Summarize *Summarize `yaml:"summarize" json:"summarize,omitempty" toml:"summarize,omitempty"`
+	Typing    *Typing    `yaml:"typing" json:"typing,omitempty" toml:"typing,omitempty"`
+	Sentiment *Sentiment `yaml:"sentiment" json:"sentiment,omitempty" toml:"sentiment,omitempty"`
 }
 
 func (s *States) GetState(id int64) *State {
@@ -25,153 +47,140 @@ func (s *States) GetState(id int64) *State {
 }
 
 type State struct {
-	ID     int64  `yaml:"id"`
-	Before string `yaml:"before"`
-	Text   string `yaml:"text"`
-	Input  string `yaml:"input"`
-	After  string `yaml:"after"`
-	Next   *Next  `yaml:"next"`
+	ID     int64   `yaml:"id" json:"id" toml:"id"`
+	Before Actions `yaml:"before" json:"before,omitempty" toml:"before,omitempty"`
+	Text   string  `yaml:"text" json:"text,omitempty" toml:"text,omitempty"`
+	Input  string  `yaml:"input" json:"input,omitempty" toml:"input,omitempty"`
+	// Replies are quick replies offered as answers, e.g. as buttons in messengers
+	Replies []string `yaml:"replies" json:"replies,omitempty" toml:"replies,omitempty"`
+	// Capture stores the named groups of a regular expression matching the
+	// answer, e.g. `(?P<city>\w+) (?P<zip>\d{5})`, to memory keys of their names
+	Capture string `yaml:"capture" json:"capture,omitempty" toml:"capture,omitempty"`
+	// Date stores the date and time the answer mentions, like "tomorrow at
+	// 3", as an ISO timestamp to this memory key
+	Date string `yaml:"date" json:"date,omitempty" toml:"date,omitempty"`
+	// Secret masks the input in transcripts, traces, the operator API and prompts
+	Secret bool    `yaml:"secret" json:"secret,omitempty" toml:"secret,omitempty"`
+	After  Actions `yaml:"after" json:"after,omitempty" toml:"after,omitempty"`
+	Next   *Next   `yaml:"next" json:"next,omitempty" toml:"next,omitempty"`
+
+	// Set assigns function results to memory after before and ahead of the
+	// text, e.g. "total = sum({a}, {b})"
+	Set assignments `yaml:"set" json:"set,omitempty" toml:"set,omitempty"`
+
+	Extract  []Field   `yaml:"extract" json:"extract,omitempty" toml:"extract,omitempty"`
+	Answer   *Answer   `yaml:"answer" json:"answer,omitempty" toml:"answer,omitempty"`
+	Handoff  *Handoff  `yaml:"handoff" json:"handoff,omitempty" toml:"handoff,omitempty"`
+	Transfer *Transfer `yaml:"transfer" json:"transfer,omitempty" toml:"transfer,omitempty"`
+	Timeout  *Timeout  `yaml:"timeout" json:"timeout,omitempty" toml:"timeout,omitempty"`
+	LLM      *LLM      `yaml:"llm" json:"llm,omitempty" toml:"llm,omitempty"`
+}
+
+// WaitsForInput reports whether the state stops the flow to read the user's answer.
+func (s *State) WaitsForInput() bool {
+	return s.Input != "" || s.Capture != "" || s.Date != "" || len(s.Extract) > 0 || s.Answer != nil || (s.Next != nil && len(s.Next.Cases) > 0)
+}
+
+// assignments accepts both a single "set: x = f()" and a list of them.
+type assignments []string
+
+func (a *assignments) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = assignments{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+
+	return nil
 }
 
 type Next struct {
-	RightId int64  `yaml:"right"`
-	RightIf string `yaml:"right-if"`
-	LeftId  int64  `yaml:"left"`
+	RightId int64  `yaml:"right" json:"right" toml:"right"`
+	RightIf string `yaml:"right-if" json:"right-if,omitempty" toml:"right-if,omitempty"`
+	LeftId  int64  `yaml:"left" json:"left,omitempty" toml:"left,omitempty"`
+
+	// Cases route the answer by the intent it matches; right is taken when
+	// none does
+	Cases   []Case   `yaml:"cases" json:"cases,omitempty" toml:"cases,omitempty"`
+	Clarify *Clarify `yaml:"clarify" json:"clarify,omitempty" toml:"clarify,omitempty"`
 }
 
 func (t *Next) IsSimple() bool {
-	return t.RightIf == "" && t.LeftId == 0
+	return t.RightIf == "" && t.LeftId == 0 && len(t.Cases) == 0
 }
 
 type memory map[string]string
-type functions map[string]func(string) error
-type filters map[string]func(string) bool
-
-var (
-	ff = functions{
-		"print": func(s string) error {
-			if s == "" {
-				fmt.Println(s)
-			}
-
-			return nil
-		},
-	}
-	fl = filters{
-		"isEmpty": func(s string) bool {
-			return s == ""
-		},
-	}
-	m = make(memory)
-)
-
-func main() {
-	// reade conversation.yml file and parse it to States struct
-	var states States
 
-	f, err := os.Open("./conversation.yml")
-	yamlFile, err := io.ReadAll(f)
+// loadStates reads a conversation flow written in YAML, JSON or TOML, expands
+// its defaults and templates, validates it against conversation.schema.json
+// and parses it to States.
+func loadStates(path string) (*States, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	err = yaml.Unmarshal(yamlFile, &states)
+	states, err := parseStates(data, filepath.Ext(path))
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, err
 	}
+	resolveDocs(states, filepath.Dir(path))
 
-	var i, j int64
-	for {
-		if i == 999 {
-			break
-		}
-
-		state := states.GetState(i)
-		if state == nil {
-			fmt.Println("*** NO STATE ***", i)
-			break
-		}
-
-		if state.Before != "" {
-			// get variable name from the string state.Before, using pattern {(var_name)}
-			key := extractVariableName(state.Before)
-			functionName := extractFunctionName(state.Before)
-			function := ff[functionName]
-			if function != nil {
-				err := function(m[key])
-				if err != nil {
-					fmt.Println("ERROR")
-					fmt.Println(err)
-					os.Exit(1)
-				}
-			}
-		}
-		key := extractVariableName(state.Text)
-		if key != "" {
-			state.Text = strings.ReplaceAll(state.Text, "{"+key+"}", m[key])
-		}
-		fmt.Println(state.Text)
-		if state.Next.IsSimple() {
-			j = state.Next.RightId
-			fmt.Println("simple, next id", j)
-		} else {
-			key := state.Input
-			value := readInputFromUser()
-			fmt.Println("k ey", key, "value", value)
-			m[key] = value
-
-			fmt.Println("input", state.Input)
-			fmt.Println("right id", state.Next.RightId)
-			fmt.Println("left id", state.Next.LeftId)
-			j = state.Next.RightId
-		}
-		if state.After != "" {
-			fmt.Println(state.After)
-			f := extractFunctionName(state.After)
-			v := extractVariableName(state.After)
-			fmt.Println("AFTER function ", f, "variable", v)
-		}
-		i = j
-	}
-
-	fmt.Println("end")
+	return states, nil
 }
 
-func extractVariableName(input string) string {
-	// Define the regular expression pattern to match "{(var_name)}"
-	re := regexp.MustCompile(`\{(.*?)\}`)
-
-	// Find the first match in the input string
-	match := re.FindStringSubmatch(input)
+func parseStates(data []byte, ext string) (*States, error) {
+	var doc interface{}
+
+	var err error
+	switch strings.ToLower(ext) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("unsupported conversation format %q (supported: .yml, .yaml, .json, .toml)", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	// Extract and return the variable name from the match
-	if len(match) > 1 {
-		return match[1]
+	if err := expandTemplates(doc); err != nil {
+		return nil, err
 	}
 
-	return "" // Return an empty string if no variable name found
-}
+	s, err := loadSchema(conversationSchema)
+	if err != nil {
+		return nil, err
+	}
 
-func extractFunctionName(input string) string {
-	// Define the regular expression pattern to match "(.*)\("
-	re := regexp.MustCompile(`(.*)\(`)
+	if err := s.validate(doc); err != nil {
+		return nil, err
+	}
 
-	// Find the first match in the input string
-	match := re.FindStringSubmatch(input)
+	// the document is valid, so it can be safely converted to the typed structure;
+	// JSON is used as the common representation of all supported formats
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
 
-	// Extract and return the variable name from the match
-	if len(match) > 1 {
-		return match[1]
+	var states States
+	if err := json.Unmarshal(normalized, &states); err != nil {
+		return nil, err
 	}
 
-	return "" // Return an empty string if no variable name found
-}
+	if errs := append(states.checkInputs(), states.checkTimers()...); len(errs) > 0 {
+		return nil, errs
+	}
 
-func readInputFromUser() string {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Println("Enter a string and press Enter:")
-	input, _ := reader.ReadString('\n')
-	return strings.TrimSpace(input)
+	return &states, nil
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFlowYAML = `
+states:
+  - id: 0
+    before: "print({header})"
+    text: "Hello, I'm a bot."
+    next:
+      right: 1
+  - id: 1
+    text: "What is your name?"
+    input: "name"
+    next:
+      right: 2
+      right-if: "isEmpty({name})"
+      left: 1
+  - id: 2
+    text: "Bye, {name}!"
+`
+
+const testFlowJSON = `{
+  "states": [
+    {"id": 0, "before": "print({header})", "text": "Hello, I'm a bot.", "next": {"right": 1}},
+    {"id": 1, "text": "What is your name?", "input": "name", "next": {"right": 2, "right-if": "isEmpty({name})", "left": 1}},
+    {"id": 2, "text": "Bye, {name}!"}
+  ]
+}`
+
+const testFlowTOML = `
+[[states]]
+id = 0
+before = "print({header})"
+text = "Hello, I'm a bot."
+next = { right = 1 }
+
+[[states]]
+id = 1
+text = "What is your name?"
+input = "name"
+next = { right = 2, right-if = "isEmpty({name})", left = 1 }
+
+[[states]]
+id = 2
+text = "Bye, {name}!"
+`
+
+func TestParseStates_AllFormatsAreEquivalent(t *testing.T) {
+	fromYAML, err := parseStates([]byte(testFlowYAML), ".yml")
+	assert.NoError(t, err)
+
+	fromJSON, err := parseStates([]byte(testFlowJSON), ".json")
+	assert.NoError(t, err)
+
+	fromTOML, err := parseStates([]byte(testFlowTOML), ".toml")
+	assert.NoError(t, err)
+
+	// Assertions
+	assert.Len(t, fromYAML.States, 3)
+	assert.Equal(t, "isEmpty({name})", fromYAML.GetState(1).Next.RightIf)
+	assert.Equal(t, int64(1), fromYAML.GetState(1).Next.LeftId)
+	assert.Nil(t, fromYAML.GetState(2).Next)
+	assert.Equal(t, fromYAML, fromJSON)
+	assert.Equal(t, fromYAML, fromTOML)
+}
+
+func TestParseStates_UnsupportedFormat(t *testing.T) {
+	states, err := parseStates([]byte(testFlowYAML), ".xml")
+
+	// Assertions
+	assert.Nil(t, states)
+	assert.EqualError(t, err, `unsupported conversation format ".xml" (supported: .yml, .yaml, .json, .toml)`)
+}
+
+func TestParseStates_SchemaViolation(t *testing.T) {
+	flow := `{"states": [{"id": 0, "next": {"right": "one"}}]}`
+
+	states, err := parseStates([]byte(flow), ".json")
+
+	// Assertions
+	assert.Nil(t, states)
+	assert.EqualError(t, err, "invalid conversation:\n  states[0].next.right: expected integer, got string")
+}
+
+func TestLoadStates_ConversationFile(t *testing.T) {
+	// the flow shipped with the repository must always pass validation
+	states, err := loadStates(filepath.Join("..", "conversation.yml"))
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.NotNil(t, states.GetState(0))
+}
+
+func TestLoadStates_FileNotFound(t *testing.T) {
+	states, err := loadStates(filepath.Join(t.TempDir(), "missing.yml"))
+
+	// Assertions
+	assert.Nil(t, states)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestParseStates_DefaultsAndTemplates(t *testing.T) {
+	states, err := parseStates([]byte(`
+defaults:
+  after: "log({step})"
+  llm:
+    model: gpt-4
+    temperature: 0.2
+  next:
+    right: 99
+templates:
+  question:
+    before: "count = increment({count})"
+    next:
+      right-if: "isEmpty({answer})"
+      left: 0
+states:
+  - id: 0
+    template: question
+    text: "Anything else?"
+    input: answer
+    llm:
+      temperature: 0.7
+  - id: 1
+    text: "Plain"
+    after: null
+  - id: 99
+    text: "Bye"
+    next: null
+`), ".yml")
+
+	// Assertions
+	assert.NoError(t, err)
+
+	question := states.GetState(0)
+	assert.Equal(t, Actions{{Call: "count = increment({count})"}}, question.Before)
+	assert.Equal(t, Actions{{Call: "log({step})"}}, question.After)
+	assert.Equal(t, &Next{RightId: 99, RightIf: "isEmpty({answer})", LeftId: 0}, question.Next)
+	assert.Equal(t, "gpt-4", question.LLM.Model)
+	assert.Equal(t, 0.7, question.LLM.Temperature)
+
+	plain := states.GetState(1)
+	assert.Nil(t, plain.Before)
+	assert.Nil(t, plain.After)
+	assert.Equal(t, &Next{RightId: 99}, plain.Next)
+
+	assert.Nil(t, states.GetState(99).Next)
+}
+
+func TestParseStates_UnknownTemplate(t *testing.T) {
+	_, err := parseStates([]byte(`
+templates:
+  question:
+    input: answer
+    retries: 3
+states:
+  - id: 0
+    template: missing
+`), ".yml")
+
+	// Assertions
+	assert.EqualError(t, err, "invalid conversation:\n  states[0].template: unknown template \"missing\"")
+
+	_, err = parseStates([]byte(`
+templates:
+  question:
+    input: answer
+    retries: 3
+states:
+  - id: 0
+    template: question
+`), ".yml")
+
+	assert.EqualError(t, err, "invalid conversation:\n  states[0].retries: unknown property")
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// formats flows convert between
+const (
+	formatFlow       = "flow" // conversation.yml, .json or .toml
+	formatScreenplay = "screenplay"
+	formatMermaid    = "mermaid"
+	formatGraph      = "graph"
+)
+
+// flowCodec reads and writes flows in another format.
+type flowCodec struct {
+	encode func(states *States) ([]byte, error)
+	decode func(data []byte) (*States, error)
+}
+
+var flowCodecs = map[string]flowCodec{
+	formatScreenplay: {encodeScreenplay, decodeScreenplay},
+	formatMermaid:    {encodeMermaid, decodeMermaid},
+	formatGraph:      {encodeGraph, decodeGraph},
+}
+
+// formatOf tells the format of a file by its name: .md is a screenplay, .mmd
+// and .mermaid a Mermaid diagram, .graph.json a graph, and the other flow
+// extensions flows.
+func formatOf(path string) (string, error) {
+	name := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(name, ".graph.json"):
+		return formatGraph, nil
+	case strings.HasSuffix(name, ".md"):
+		return formatScreenplay, nil
+	case strings.HasSuffix(name, ".mmd"), strings.HasSuffix(name, ".mermaid"):
+		return formatMermaid, nil
+	case isFlowFile(name):
+		return formatFlow, nil
+	}
+
+	return "", fmt.Errorf("unknown format of %s, set it with -from or -to", path)
+}
+
+// importFlow reads a flow in the format, validating it like flow files.
+func importFlow(data []byte, format, ext string) (*States, error) {
+	if format == formatFlow {
+		return parseStates(data, ext)
+	}
+
+	codec, ok := flowCodecs[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	states, err := codec.decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, err := json.Marshal(states)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseStates(normalized, ".json")
+}
+
+// exportFlow writes the flow in the format; ext picks the flow file format.
+func exportFlow(states *States, format, ext string) ([]byte, error) {
+	if format == formatFlow {
+		return marshalStates(states, ext)
+	}
+
+	codec, ok := flowCodecs[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	return codec.encode(states)
+}
+
+// marshalStates writes the flow as YAML, JSON or TOML, leaving out what is
+// not set.
+func marshalStates(states *States, ext string) ([]byte, error) {
+	data, err := json.Marshal(states)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(ext) {
+	case ".json":
+		var out bytes.Buffer
+		if err := json.Indent(&out, data, "", "  "); err != nil {
+			return nil, err
+		}
+		out.WriteByte('\n')
+		return out.Bytes(), nil
+	case ".toml":
+		var doc map[string]interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		return toml.Marshal(integers(doc))
+	case ".yml", ".yaml":
+		// YAML reads JSON, keeping the order of the fields
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		blockStyle(&doc)
+
+		var out bytes.Buffer
+		enc := yaml.NewEncoder(&out)
+		enc.SetIndent(2)
+		if err := enc.Encode(&doc); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported conversation format %q (supported: .yml, .yaml, .json, .toml)", ext)
+}
+
+// integers turns the whole numbers JSON decodes as floats back to integers,
+// so TOML doesn't write ids like 1.0.
+func integers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = integers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = integers(item)
+		}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	}
+
+	return v
+}
+
+// blockStyle drops the JSON styles of the nodes, so they are written as
+// YAML blocks with quotes only where needed.
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// checkSimple fails for flows using more than the start, and the id, text,
+// input, hooks of single calls, set assignments and right, right-if and left
+// transitions of their states, which is all the format can hold.
+func checkSimple(states *States, format string) error {
+	if !reflect.DeepEqual(*states, States{Start: states.Start, States: states.States}) {
+		return fmt.Errorf("%s holds only the start and the states of a flow", format)
+	}
+
+	for _, state := range states.States {
+		simple := State{ID: state.ID, Text: state.Text, Input: state.Input, Before: state.Before, Set: state.Set, After: state.After}
+		if state.Next != nil {
+			simple.Next = &Next{RightId: state.Next.RightId, RightIf: state.Next.RightIf, LeftId: state.Next.LeftId}
+		}
+		if !reflect.DeepEqual(simple, state) {
+			return fmt.Errorf("state %d: %s holds only the id, text, input, hooks, set and right, right-if and left transitions of states", state.ID, format)
+		}
+
+		for _, a := range append(append(Actions{}, state.Before...), state.After...) {
+			if !a.isCall() {
+				return fmt.Errorf("state %d: %s holds only hooks of calls, like %q", state.ID, format, "print({name})")
+			}
+		}
+		before, after := hookNotes(state)
+		for _, note := range append(before, after...) {
+			if strings.Contains(note, "\n") {
+				return fmt.Errorf("state %d: %s holds only hooks on a single line", state.ID, format)
+			}
+		}
+	}
+
+	return nil
+}
+
+// hookNotes are the notes the text formats write the hooks of a state as,
+// like "before print({header})": before hooks and set assignments run ahead
+// of the text, after hooks once the state is left.
+func hookNotes(state State) (before, after []string) {
+	for _, a := range state.Before {
+		before = append(before, "before "+a.Call)
+	}
+	for _, assignment := range state.Set {
+		before = append(before, "set "+assignment)
+	}
+	for _, a := range state.After {
+		after = append(after, "after "+a.Call)
+	}
+
+	return before, after
+}
+
+// addHookNote adds the hook a note written by hookNotes stands for.
+func addHookNote(state *State, note string) error {
+	stage, hook, _ := strings.Cut(note, " ")
+	hook = strings.TrimSpace(hook)
+	if hook == "" {
+		return fmt.Errorf("note %q must be a hook, like %q", note, "before print({name})")
+	}
+
+	switch stage {
+	case "before":
+		state.Before = append(state.Before, Action{Call: hook})
+	case "set":
+		state.Set = append(state.Set, hook)
+	case "after":
+		state.After = append(state.After, Action{Call: hook})
+	default:
+		return fmt.Errorf("note %q must start with before, set or after", note)
+	}
+
+	return nil
+}
+
+func convertCommand(args []string) {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := flags.String("from", "", "format of the input: flow, screenplay, mermaid or graph; by default told by the file name")
+	to := flags.String("to", "", "format of the output: flow, screenplay, mermaid or graph; by default told by the file name")
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 1 || flags.NArg() > 2 || (flags.NArg() == 1 && *to == "") {
+		fmt.Println("usage: conversation convert [-from format] [-to format] <input> [output]")
+		os.Exit(2)
+	}
+	in, out := flags.Arg(0), flags.Arg(1)
+
+	err := func() error {
+		var err error
+		if *from == "" {
+			if *from, err = formatOf(in); err != nil {
+				return err
+			}
+		}
+		if *to == "" {
+			if *to, err = formatOf(out); err != nil {
+				return err
+			}
+		}
+
+		data, err := os.ReadFile(in)
+		if err != nil {
+			return err
+		}
+		states, err := importFlow(data, *from, filepath.Ext(in))
+		if err != nil {
+			return err
+		}
+
+		ext := filepath.Ext(out)
+		if out == "" || !isFlowFile(out) {
+			ext = ".yml"
+		}
+		converted, err := exportFlow(states, *to, ext)
+		if err != nil {
+			return err
+		}
+
+		if out == "" {
+			_, err = os.Stdout.Write(converted)
+			return err
+		}
+		return os.WriteFile(out, converted, 0o644)
+	}()
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const simpleFlowYAML = `
+start: 1
+states:
+  - id: 1
+    text: "Hello!\n\n  What is your name?"
+    input: name
+    next:
+      right: 2
+      right-if: "isEmpty({name})"
+      left: 3
+  - id: 2
+    text: "Names : can't be empty."
+    next:
+      right: 1
+  - id: 3
+    text: "Nice to meet you, {name}."
+    next:
+      right: 4
+      left: 1
+  - id: 4
+`
+
+const screenplayFlow = `Start: 1
+
+## 1
+BOT: Hello!
+BOT:
+BOT:   What is your name?
+USER: {name}
+-> 2 if isEmpty({name})
+-> 3 otherwise
+
+## 2
+BOT: Names : can't be empty.
+-> 1
+
+## 3
+BOT: Nice to meet you, {name}.
+-> 4
+-> 1 otherwise
+
+## 4
+`
+
+const mermaidFlow = `stateDiagram-v2
+    [*] --> s1
+    s1 : Hello!
+    s1 :
+    s1 :   What is your name?
+    note right of s1 : input {name}
+    s1 --> s2 : isEmpty({name})
+    s1 --> s3 : otherwise
+    s2 : Names : can't be empty.
+    s2 --> s1
+    s3 : Nice to meet you, {name}.
+    s3 --> s4
+    s3 --> s1 : otherwise
+    s4 --> [*]
+`
+
+// fullFlowYAML uses settings only the flow files and graphs hold.
+const fullFlowYAML = `
+version: "2"
+description: Orders
+timezone: Europe/Berlin
+on-error: 3
+typing:
+  speed: 20
+states:
+  - id: 0
+    before: print({header})
+    text: "How can I help?"
+    replies: ["Track", "Cancel"]
+    timeout:
+      after: 1m
+      text: "Still there?"
+      next: 3
+    next:
+      right: 3
+      cases:
+        - intent: track order
+          examples: ["where is my order"]
+          to: 1
+        - intent: cancel order
+          to: 2
+      clarify:
+        threshold: 0.4
+        question: "Sorry, {_options}?"
+  - id: 1
+    text: "Your order number?"
+    capture: '(?P<order>\d+)'
+    secret: true
+    set: "n = sum({order}, 1)"
+    after:
+      - print({order})
+    next:
+      right: 3
+      right-if: "isNumber({order})"
+      left: 1
+  - id: 2
+    text: "When should we call?"
+    date: when
+    next:
+      right: 3
+  - id: 3
+    text: "Bye."
+`
+
+func TestConvert_Screenplay(t *testing.T) {
+	states, err := parseStates([]byte(simpleFlowYAML), ".yml")
+	assert.NoError(t, err)
+
+	data, err := exportFlow(states, formatScreenplay, "")
+	imported, ierr := importFlow(data, formatScreenplay, ".md")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, screenplayFlow, string(data))
+	assert.NoError(t, ierr)
+	assert.Equal(t, states, imported)
+}
+
+func TestConvert_Mermaid(t *testing.T) {
+	states, err := parseStates([]byte(simpleFlowYAML), ".yml")
+	assert.NoError(t, err)
+
+	data, err := exportFlow(states, formatMermaid, "")
+	imported, ierr := importFlow(data, formatMermaid, ".mmd")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, mermaidFlow, string(data))
+	assert.NoError(t, ierr)
+	assert.Equal(t, states, imported)
+}
+
+func TestConvert_RoundTrips(t *testing.T) {
+	simple, err := parseStates([]byte(simpleFlowYAML), ".yml")
+	assert.NoError(t, err)
+	full, err := parseStates([]byte(fullFlowYAML), ".yml")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		format, ext string
+		states      *States
+	}{
+		{formatScreenplay, ".md", simple},
+		{formatMermaid, ".mmd", simple},
+		{formatGraph, ".json", simple},
+		{formatGraph, ".json", full},
+		{formatFlow, ".yml", full},
+		{formatFlow, ".json", full},
+		{formatFlow, ".toml", full},
+	}
+	for _, tt := range tests {
+		data, err := exportFlow(tt.states, tt.format, tt.ext)
+		assert.NoError(t, err, tt.format+tt.ext)
+
+		imported, err := importFlow(data, tt.format, tt.ext)
+
+		// Assertions
+		assert.NoError(t, err, tt.format+tt.ext)
+		assert.Equal(t, tt.states, imported, tt.format+tt.ext)
+
+		again, err := exportFlow(imported, tt.format, tt.ext)
+		assert.NoError(t, err, tt.format+tt.ext)
+		assert.Equal(t, string(data), string(again), tt.format+tt.ext)
+	}
+}
+
+func TestConvert_SampleFlow(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "conversation.yml"))
+	assert.NoError(t, err)
+	states, err := parseStates(data, ".yml")
+	assert.NoError(t, err)
+	states.States[1].Set = assignments{"greeted = 'yes'"}
+
+	screenplay, serr := exportFlow(states, formatScreenplay, "")
+	mermaid, merr := exportFlow(states, formatMermaid, "")
+
+	// Assertions
+	assert.NoError(t, serr)
+	assert.Contains(t, string(screenplay), "## 0\nNOTE: before print({header})\nBOT: Hello, I'm a bot.\n")
+	assert.Contains(t, string(screenplay), "## 1\nNOTE: set greeted = 'yes'\nBOT: What is your name?\n")
+	assert.Contains(t, string(screenplay), "USER: {prompt}\nNOTE: after printPrompt({prompt})\n-> 999 if contains({prompt}, 'bye')\n")
+	assert.NoError(t, merr)
+	assert.Contains(t, string(mermaid), "    note right of s0 : before print({header})\n    s0 : Hello, I'm a bot.\n")
+	assert.Contains(t, string(mermaid), "    note right of s1 : set greeted = 'yes'\n")
+	assert.Contains(t, string(mermaid), "    note right of s2 : input {prompt}\n    note right of s2 : after printPrompt({prompt})\n")
+
+	for format, data := range map[string][]byte{formatScreenplay: screenplay, formatMermaid: mermaid} {
+		imported, err := importFlow(data, format, "")
+		assert.NoError(t, err, format)
+		assert.Equal(t, states, imported, format)
+	}
+}
+
+func TestConvert_Unsupported(t *testing.T) {
+	full, err := parseStates([]byte(fullFlowYAML), ".yml")
+	assert.NoError(t, err)
+	simple, err := parseStates([]byte(simpleFlowYAML), ".yml")
+	assert.NoError(t, err)
+	simple.States[2].Replies = []string{"Hi"}
+
+	_, fullErr := exportFlow(full, formatScreenplay, "")
+	_, stateErr := exportFlow(simple, formatMermaid, "")
+	_, arrowErr := exportFlow(&States{States: []State{{ID: 0, Text: "a --> b"}}}, formatMermaid, "")
+	_, hookErr := exportFlow(&States{States: []State{{ID: 0, Before: Actions{{Unset: "name"}}}}}, formatScreenplay, "")
+	_, lineErr := exportFlow(&States{States: []State{{ID: 0, Set: assignments{"a = 'x\ny'"}}}}, formatMermaid, "")
+
+	// Assertions
+	assert.EqualError(t, fullErr, "screenplay holds only the start and the states of a flow")
+	assert.EqualError(t, stateErr, "state 3: mermaid holds only the id, text, input, hooks, set and right, right-if and left transitions of states")
+	assert.EqualError(t, arrowErr, `state 0: mermaid can't hold text with "-->"`)
+	assert.EqualError(t, hookErr, `state 0: screenplay holds only hooks of calls, like "print({name})"`)
+	assert.EqualError(t, lineErr, "state 0: mermaid holds only hooks on a single line")
+}
+
+func TestConvert_InvalidImports(t *testing.T) {
+	tests := []struct {
+		format, data, err string
+	}{
+		{formatScreenplay, "BOT: Hi\n", `line 1: "BOT: Hi" is outside a state, start states with "## <id>"`},
+		{formatScreenplay, "## 0\nUSER: name\n", `line 2: user input "name" must be a memory key in braces, like {name}`},
+		{formatScreenplay, "## 0\n-> 1\n-> 2\n", "line 3: state 0 has more than one transition"},
+		{formatScreenplay, "## 0\n-> 1 otherwise\n", "state 0: otherwise without a transition before it"},
+		{formatScreenplay, "## 0\nNOTE: during print({name})\n", `line 2: note "during print({name})" must start with before, set or after`},
+		{formatMermaid, "graph TD\n", "line 1: diagrams must start with stateDiagram-v2"},
+		{formatMermaid, "stateDiagram-v2\n    idle --> s1\n", `line 2: state "idle" must be named s<id>`},
+		{formatMermaid, "stateDiagram-v2\n    note right of s1 : after\n", `line 2: note "after" must be a hook, like "before print({name})"`},
+		{formatGraph, `{"nodes": [{"id": "0", "type": "state"}], "edges": [{"source": "1", "target": "0", "kind": "right"}]}`, `edges[0]: source "1" is not a node`},
+		{formatGraph, `{"nodes": [{"id": "0", "type": "state"}], "edges": [{"source": "0", "target": "0", "kind": "jump"}]}`, `edges[0]: kind "jump" must be right, left or case`},
+		{formatGraph, `{"nodes": [{"id": "0", "type": "state", "data": {"text": "Hi", "capture": "("}}], "edges": []}`, "invalid conversation:\n  states[0].capture: error parsing regexp: missing closing ): `(`"},
+	}
+	for _, tt := range tests {
+		_, err := importFlow([]byte(tt.data), tt.format, "")
+
+		// Assertions
+		assert.EqualError(t, err, tt.err, tt.data)
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	tests := map[string]string{
+		"flows/order.yml":               formatFlow,
+		"order.toml":                    formatFlow,
+		"order.graph.json":              formatGraph,
+		"order.json":                    formatFlow,
+		"docs/order.md":                 formatScreenplay,
+		"order.mmd":                     formatMermaid,
+		filepath.Join("a", "b.MERMAID"): formatMermaid,
+	}
+	for path, format := range tests {
+		got, err := formatOf(path)
+
+		// Assertions
+		assert.NoError(t, err, path)
+		assert.Equal(t, format, got, path)
+	}
+
+	_, err := formatOf("order.txt")
+	assert.EqualError(t, err, "unknown format of order.txt, set it with -from or -to")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+// hookRef identifies a hook action of a state.
+type hookRef struct {
+	StateID int64
+	Stage   string // before, set or after
+	Hook    string
+}
+
+func (h hookRef) String() string {
+	return fmt.Sprintf("state %d %s: %s", h.StateID, h.Stage, h.Hook)
+}
+
+// coverage records which states, transitions and hooks of a flow the
+// conversations observed went through.
+type coverage struct {
+	BaseObserver
+
+	flow   string
+	states *States
+
+	entered map[int64]int
+	taken   map[edge]int
+	hooks   map[hookRef]int
+}
+
+func newCoverage(flow string, states *States) *coverage {
+	return &coverage{
+		flow:    flow,
+		states:  states,
+		entered: make(map[int64]int),
+		taken:   make(map[edge]int),
+		hooks:   make(map[hookRef]int),
+	}
+}
+
+func (c *coverage) StateEntered(s *Session, stateID int64) {
+	c.entered[stateID]++
+}
+
+func (c *coverage) TransitionChosen(s *Session, t Transition) {
+	c.taken[edge{From: t.From, To: t.To, Kind: t.Edge}]++
+}
+
+func (c *coverage) HookExecuted(s *Session, h HookCall) {
+	c.hooks[hookRef{StateID: h.StateID, Stage: h.Stage, Hook: h.Hook}]++
+}
+
+// coverageItem is a part of the flow and how often it was exercised.
+type coverageItem struct {
+	Name  string
+	Count int
+}
+
+// coverageReport lists the states, edges and hooks of a flow with how often
+// each was exercised.
+type coverageReport struct {
+	Flow   string
+	States []coverageItem
+	Edges  []coverageItem
+	Hooks  []coverageItem
+}
+
+func (c *coverage) report() *coverageReport {
+	r := &coverageReport{Flow: c.flow}
+	for _, state := range c.states.States {
+		r.States = append(r.States, coverageItem{Name: fmt.Sprintf("state %d", state.ID), Count: c.entered[state.ID]})
+
+		for _, e := range transitions(&state) {
+			r.Edges = append(r.Edges, coverageItem{Name: e.String(), Count: c.taken[e]})
+		}
+
+		stages := []struct {
+			name    string
+			actions Actions
+		}{{"before", state.Before}, {"set", setActions(state.Set)}, {"after", state.After}}
+		for _, stage := range stages {
+			for _, a := range stage.actions {
+				h := hookRef{StateID: state.ID, Stage: stage.name, Hook: a.String()}
+				r.Hooks = append(r.Hooks, coverageItem{Name: h.String(), Count: c.hooks[h]})
+			}
+		}
+	}
+
+	return r
+}
+
+func covered(items []coverageItem) int {
+	n := 0
+	for _, item := range items {
+		if item.Count > 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Percent is the share of the flow's states, edges and hooks exercised.
+func (r *coverageReport) Percent() float64 {
+	total := len(r.States) + len(r.Edges) + len(r.Hooks)
+	if total == 0 {
+		return 100
+	}
+
+	return 100 * float64(covered(r.States)+covered(r.Edges)+covered(r.Hooks)) / float64(total)
+}
+
+type coverageSection struct {
+	Title string
+	Items []coverageItem
+}
+
+// Sections lists the states, edges and hooks, leaving out empty ones.
+func (r *coverageReport) Sections() []coverageSection {
+	var sections []coverageSection
+	for _, section := range []coverageSection{{"states", r.States}, {"edges", r.Edges}, {"hooks", r.Hooks}} {
+		if len(section.Items) > 0 {
+			sections = append(sections, section)
+		}
+	}
+
+	return sections
+}
+
+func (r *coverageReport) print(w io.Writer) {
+	fmt.Fprintf(w, "coverage of %s: %.1f%%\n", r.Flow, r.Percent())
+
+	for _, section := range r.Sections() {
+		fmt.Fprintf(w, "  %s: %d/%d\n", section.Title, covered(section.Items), len(section.Items))
+		for _, item := range section.Items {
+			if item.Count == 0 {
+				fmt.Fprintf(w, "    never: %s\n", item.Name)
+			}
+		}
+	}
+}
+
+var coverageHTML = template.Must(template.New("coverage").Funcs(template.FuncMap{"covered": covered}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Flow coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { padding: 2px 12px; text-align: left; }
+.hit { background: #dfd; }
+.miss { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>Flow coverage</h1>
+{{range .}}
+<h2>{{.Flow}}: {{printf "%.1f" .Percent}}%</h2>
+{{range .Sections}}<table>
+<tr><th>{{.Title}} {{covered .Items}}/{{len .Items}}</th><th>times</th></tr>
+{{range .Items}}<tr class="{{if .Count}}hit{{else}}miss{{end}}"><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// writeCoverageHTML writes the reports as an HTML page, exercised parts of
+// the flows in green and the others in red.
+func writeCoverageHTML(w io.Writer, reports []*coverageReport) error {
+	return coverageHTML.Execute(w, reports)
+}
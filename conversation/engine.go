@@ -0,0 +1,604 @@
+package main
+
+import (
+	"OpenAI-api/api/request"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	startStateID = 0
+
+	// maxAutoSteps limits the transitions taken without user input, so a cycle
+	// of states without input can't hang the bot
+	maxAutoSteps = 100
+
+	edgeRight = "right"
+	edgeLeft  = "left"
+	edgeError = "error" // a jump to the state handling an error
+)
+
+var (
+	errConversationOver = errors.New("conversation is over")
+	errPaused           = errors.New("conversation is paused at a breakpoint")
+	placeholderRe       = regexp.MustCompile(`\{([\w.]+)\}`)
+)
+
+// Engine drives a conversation flow one user answer at a time, so the same flow
+// can be run in the terminal, simulated or served.
+type Engine struct {
+	// name is the name of the flow in a flow registry, and lookup finds the
+	// engines of the other flows there
+	name   string
+	lookup func(name string) *Engine
+
+	states    *States
+	functions functions
+	filters   filters
+	llm       chatClient
+	embedder  embedder
+	moderator moderator
+
+	httpClient request.HttpClient
+	// output is where the print function writes, standard output unless the
+	// flow runs somewhere else, like in the REPL
+	output io.Writer
+
+	observers []Observer
+	// breakAt, when set, pauses the flow before entering the states it reports
+	breakAt func(stateID int64) bool
+	// breakHook, when set, is called before each hook and set assignment runs
+	// with the call about to be made, so debuggers can pause in between
+	breakHook func(s *Session, h HookCall)
+
+	// dryRun disables side effects outside the session, like saving document
+	// indexes; the simulator uses it
+	dryRun bool
+
+	mu      sync.Mutex
+	indexes map[string]*docIndex
+}
+
+// Session is the state of a single conversation with a user.
+type Session struct {
+	StateID int64
+	Memory  memory
+	Done    bool
+
+	// Pending lists the fields to extract that the user still has to provide
+	// in the current state
+	Pending []string
+
+	// Candidates lists the intents a clarification question asked the user to
+	// choose from
+	Candidates []string
+
+	// Paused is set when the flow stopped at a breakpoint before entering StateID
+	Paused bool
+
+	// Handoff is set while an operator has taken over the conversation
+	Handoff    *handoffStatus
+	Transcript []transcriptEntry
+
+	// Flow is set when the session was transferred to another flow
+	Flow string
+
+	// Summarized is the number of transcript entries folded into {_recap}
+	Summarized int
+
+	// Reminded is the number of reminders sent since the user last answered
+	// or the conversation moved on
+	Reminded int
+
+	// Events is the session's history, see Replay
+	Events []SessionEvent
+	seen   *folded
+
+	// transfers counts the transfers running in the current turn
+	transfers int
+}
+
+const (
+	roleUser     = "user"
+	roleBot      = "bot"
+	roleOperator = "operator"
+	roleAdmin    = "admin"
+)
+
+type transcriptEntry struct {
+	Role string    `json:"role"` // user, bot, operator or admin
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+func (s *Session) record(role string, texts ...string) {
+	kind := sessionOutput
+	if role == roleUser {
+		kind = sessionInput
+	}
+
+	for _, text := range texts {
+		ev := SessionEvent{Type: kind, Role: role, Text: text, Time: time.Now()}
+		s.log(ev)
+		s.apply(ev)
+	}
+}
+
+func NewEngine(states *States) *Engine {
+	e := &Engine{
+		states:     states,
+		functions:  make(functions, len(ff)),
+		filters:    fl,
+		httpClient: &http.Client{Timeout: httpTimeout},
+		output:     os.Stdout,
+	}
+
+	for name, function := range ff {
+		e.functions[name] = function
+	}
+	e.functions["print"] = func(values ...string) error {
+		return printTo(e.output)(values...)
+	}
+
+	return e
+}
+
+func NewSession() *Session {
+	return &Session{
+		StateID: startStateID,
+		Memory:  make(memory),
+	}
+}
+
+// NewSession creates a session at the flow's start state.
+func (e *Engine) NewSession() *Session {
+	s := NewSession()
+	s.StateID = e.states.Start
+	s.sync()
+
+	return s
+}
+
+// Start enters the session's current state and runs the flow until a state
+// waits for user input or the conversation ends. It returns the texts to show.
+func (e *Engine) Start(s *Session) ([]string, error) {
+	if other := e.of(s); other != e {
+		return other.Start(s)
+	}
+
+	texts, err := e.run(s)
+
+	return e.finish(s, texts, err)
+}
+
+// Answer stores the user's input under the current state's input key, runs its
+// after hook and moves on until the next state waiting for input. While the
+// session is handed off, the input is left for the operator.
+func (e *Engine) Answer(s *Session, input string) ([]string, error) {
+	if other := e.of(s); other != e {
+		return other.Answer(s, input)
+	}
+	if s.Done {
+		return nil, errConversationOver
+	}
+	if s.Paused {
+		return nil, errPaused
+	}
+
+	logged := e.maskInput(s.Memory, e.states.GetState(s.StateID), input)
+
+	s.record(roleUser, logged)
+	s.Reminded = 0
+	e.notify(func(o Observer) { o.InputReceived(s, s.StateID, logged) })
+	if s.Handoff != nil {
+		return nil, nil
+	}
+
+	texts, err := e.handle(s, input)
+
+	return e.finish(s, texts, err)
+}
+
+// Continue resumes a flow paused at a breakpoint.
+func (e *Engine) Continue(s *Session) ([]string, error) {
+	if other := e.of(s); other != e {
+		return other.Continue(s)
+	}
+	if !s.Paused {
+		return nil, errors.New("conversation is not paused")
+	}
+
+	texts, err := e.run(s)
+
+	return e.finish(s, texts, err)
+}
+
+// Goto jumps to the given state, dropping whatever the session was waiting for.
+func (e *Engine) Goto(s *Session, stateID int64) ([]string, error) {
+	if other := e.of(s); other != e {
+		return other.Goto(s, stateID)
+	}
+	if e.states.GetState(stateID) == nil {
+		return nil, fmt.Errorf("no state with id %d", stateID)
+	}
+
+	s.StateID = stateID
+	s.Done, s.Paused, s.Pending, s.Candidates, s.Handoff = false, false, nil, nil, nil
+
+	texts, err := e.run(s)
+
+	return e.finish(s, texts, err)
+}
+
+// Replies returns the quick replies to offer while the session waits for an
+// answer: the intents of a clarification question, or the state's replies.
+func (e *Engine) Replies(s *Session) []string {
+	if other := e.of(s); other != e {
+		return other.Replies(s)
+	}
+	if s.Done || s.Paused || s.Handoff != nil {
+		return nil
+	}
+	if len(s.Candidates) > 0 {
+		return append([]string(nil), s.Candidates...)
+	}
+
+	state := e.states.GetState(s.StateID)
+	if state == nil || len(state.Replies) == 0 {
+		return nil
+	}
+
+	replies := make([]string, 0, len(state.Replies))
+	for _, reply := range state.Replies {
+		replies = append(replies, render(reply, s.Memory))
+	}
+
+	return replies
+}
+
+// Observe registers an observer notified of everything the engine does.
+func (e *Engine) Observe(o Observer) {
+	e.observers = append(e.observers, o)
+}
+
+func (e *Engine) notify(fn func(o Observer)) {
+	for _, o := range e.observers {
+		fn(o)
+	}
+}
+
+// finish records the bot's texts in the transcript, logs the session's
+// changes and reports the error to the observers, returning both unchanged.
+// Secret values are masked in the texts and the error.
+func (e *Engine) finish(s *Session, texts []string, err error) ([]string, error) {
+	for _, text := range texts {
+		s.record(roleBot, e.redact(s.Memory, text))
+	}
+	s.sync()
+	if err != nil {
+		e.reportError(s, err)
+	}
+
+	return texts, err
+}
+
+func (e *Engine) reportError(s *Session, err error) {
+	err = e.redactError(s.Memory, err)
+	e.notify(func(o Observer) { o.Error(s, err) })
+}
+
+func (e *Engine) handle(s *Session, input string) ([]string, error) {
+	state := e.states.GetState(s.StateID)
+	if state == nil {
+		return nil, fmt.Errorf("no state with id %d", s.StateID)
+	}
+
+	texts, wait, err := e.leave(s, state, input)
+	if err != nil {
+		if err := e.recover(s, state.ID, err); err != nil {
+			return texts, err
+		}
+	} else if wait {
+		return texts, nil
+	}
+
+	next, err := e.run(s)
+	return append(texts, next...), err
+}
+
+// leave handles the answer to the state and moves on. It reports whether the
+// state keeps waiting for the fields the answer was missing.
+func (e *Engine) leave(s *Session, state *State, input string) ([]string, bool, error) {
+	if len(s.Candidates) > 0 {
+		// the answer to "did you mean A or B?"
+		t, err := e.clarified(s, state, input)
+		if err == nil && e.states.Fallback != nil && !t.Result && t.To == state.ID {
+			return []string{e.fallback(s, state, input)}, false, nil
+		}
+		return nil, false, err
+	}
+
+	if state.Input != "" {
+		s.Memory[state.Input] = input
+	}
+	if state.Capture != "" {
+		e.capture(s, state, input)
+	}
+	if state.Date != "" {
+		e.date(s, state, input)
+	}
+	if e.states.Sentiment != nil {
+		e.classifyMessage(s, state, input)
+	}
+
+	if len(state.Extract) > 0 {
+		missing, err := e.extractPending(s, state, input)
+		if err != nil {
+			return nil, false, fmt.Errorf("state %d: extract: %w", state.ID, err)
+		}
+		if len(missing) > 0 {
+			return []string{followUp(missing, s.Memory)}, true, nil
+		}
+	}
+
+	var texts []string
+	if state.Answer != nil {
+		reply, err := e.answer(s, state, e.prompt(s.Memory, state.LLM, input))
+		if err != nil {
+			return nil, false, fmt.Errorf("state %d: answer: %w", state.ID, err)
+		}
+		texts = append(texts, reply)
+	}
+
+	jumped, err := e.actions(s, state.ID, "after", state.After)
+	if err != nil || jumped {
+		return texts, false, err
+	}
+
+	var t Transition
+	if len(state.Next.Cases) > 0 {
+		var question string
+		t, question, err = e.route(s, state, input)
+		if err != nil {
+			return texts, false, err
+		}
+		if question != "" {
+			return append(texts, question), true, nil
+		}
+	} else if t, err = e.move(s, state); err != nil {
+		return texts, false, err
+	}
+
+	// the input matched nothing and the state is about to ask again
+	if e.states.Fallback != nil && !t.Result && t.To == state.ID {
+		texts = append(texts, e.fallback(s, state, input))
+	}
+
+	return texts, false, nil
+}
+
+func (e *Engine) run(s *Session) ([]string, error) {
+	var texts []string
+	s.Reminded = 0
+
+	for steps := 0; ; steps++ {
+		if steps > maxAutoSteps {
+			return texts, fmt.Errorf("state %d: more than %d transitions without user input", s.StateID, maxAutoSteps)
+		}
+
+		state := e.states.GetState(s.StateID)
+		if state == nil {
+			return texts, fmt.Errorf("no state with id %d", s.StateID)
+		}
+
+		if s.Paused {
+			// continuing from the breakpoint at this state
+			s.Paused = false
+		} else if e.breakAt != nil && e.breakAt(state.ID) {
+			s.Paused = true
+			return texts, nil
+		}
+
+		e.notify(func(o Observer) { o.StateEntered(s, state.ID) })
+
+		stop, err := e.enter(s, state, &texts)
+		if err != nil {
+			if err := e.recover(s, state.ID, err); err != nil {
+				return texts, err
+			}
+			continue
+		}
+		if stop {
+			return texts, nil
+		}
+	}
+}
+
+// enter runs the state's hooks, adds its text and moves on unless the state
+// stops the flow. It reports whether the flow stopped in the state.
+func (e *Engine) enter(s *Session, state *State, texts *[]string) (bool, error) {
+	jumped, err := e.actions(s, state.ID, "before", state.Before)
+	if err == nil && !jumped {
+		jumped, err = e.actions(s, state.ID, "set", setActions(state.Set))
+	}
+	if err != nil || jumped {
+		return false, err
+	}
+
+	if state.Text != "" {
+		*texts = append(*texts, render(state.Text, s.Memory))
+	}
+
+	if state.Handoff != nil {
+		s.Handoff = &handoffStatus{Queue: state.Handoff.queue(), Since: time.Now()}
+		return true, nil
+	}
+
+	if state.Transfer != nil {
+		return true, e.transfer(s, state, texts)
+	}
+
+	if state.Next == nil {
+		s.Done = true
+		return true, nil
+	}
+
+	if state.WaitsForInput() {
+		return true, nil
+	}
+
+	_, err = e.move(s, state)
+	return false, err
+}
+
+// extractPending extracts the state's fields from the answer: all of them on
+// the first answer, only the missing ones on follow-ups.
+func (e *Engine) extractPending(s *Session, state *State, answer string) ([]Field, error) {
+	fields, question := state.Extract, render(state.Text, s.Memory)
+	if len(s.Pending) > 0 {
+		fields = nil
+		for _, f := range state.Extract {
+			for _, name := range s.Pending {
+				if f.Name == name {
+					fields = append(fields, f)
+				}
+			}
+		}
+		question = followUp(fields, s.Memory)
+	}
+
+	question, answer = e.prompt(s.Memory, state.LLM, question), e.prompt(s.Memory, state.LLM, answer)
+	missing, err := e.extract(state, fields, question, answer, s.Memory)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Pending = nil
+	for _, f := range missing {
+		s.Pending = append(s.Pending, f.Name)
+	}
+
+	return missing, nil
+}
+
+// move follows the state's transition: right when right-if holds (or is not
+// set), left otherwise.
+func (e *Engine) move(s *Session, state *State) (Transition, error) {
+	t := Transition{From: state.ID, To: state.Next.RightId, Edge: edgeRight, Result: true}
+	if !state.Next.IsSimple() {
+		holds, err := e.check(state.Next.RightIf, s.Memory)
+		if err != nil {
+			return t, fmt.Errorf("state %d: right-if: %w", state.ID, err)
+		}
+
+		t.Condition, t.Result = state.Next.RightIf, holds
+		if !t.Result {
+			t.To, t.Edge = state.Next.LeftId, edgeLeft
+		}
+	}
+
+	e.follow(s, t)
+
+	return t, nil
+}
+
+// follow moves the session along the transition.
+func (e *Engine) follow(s *Session, t Transition) {
+	e.notify(func(o Observer) {
+		o.StateExited(s, t.From)
+		o.TransitionChosen(s, t)
+	})
+
+	s.sync()
+	s.log(SessionEvent{Type: sessionTransition, From: t.From, To: t.To, Edge: t.Edge})
+	s.StateID = t.To
+}
+
+// call evaluates a call like "print({header})" or an assignment like
+// "total = sum({a}, {b})", storing the result of assignments in memory. It
+// returns the evaluated arguments; unknown functions are not called.
+func (e *Engine) call(s *Session, expr string) (string, error) {
+	c, err := parseCall(expr)
+	if err != nil {
+		return "", err
+	}
+
+	values := c.values(s.Memory)
+
+	var result interface{}
+	if c.Name == "" {
+		result = values[0]
+	} else {
+		function := e.functions[c.Name]
+		if function == nil {
+			return "", errUnknownFunction
+		}
+		if result, err = invoke(function, values); err != nil {
+			return formatArgs(values), err
+		}
+	}
+
+	if c.Assign != "" {
+		s.Memory[c.Assign] = formatResult(result)
+	}
+
+	return formatArgs(values), nil
+}
+
+// check evaluates a condition like "contains({prompt}, 'bye')". Unknown
+// filters never hold.
+func (e *Engine) check(condition string, m memory) (bool, error) {
+	if condition == "" {
+		return true, nil
+	}
+
+	c, err := parseCall(condition)
+	if err != nil {
+		return false, err
+	}
+
+	if c.Name == "" || c.Assign != "" {
+		return false, fmt.Errorf("%q is not a filter call", condition)
+	}
+
+	filter := e.filters[c.Name]
+	if filter == nil {
+		return false, nil
+	}
+
+	result, err := invoke(filter, c.values(m))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", c.Name, err)
+	}
+
+	holds, ok := result.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s: filter returned %T, not bool", c.Name, result)
+	}
+
+	return holds, nil
+}
+
+// formatArgs formats evaluated arguments for traces.
+func formatArgs(values []interface{}) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		parts = append(parts, formatResult(v))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// render replaces every {var} placeholder in the text with its memory value.
+func render(text string, m memory) string {
+	return placeholderRe.ReplaceAllStringFunc(text, func(placeholder string) string {
+		return m[placeholder[1:len(placeholder)-1]]
+	})
+}
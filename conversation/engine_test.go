@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEngine(t *testing.T, flow string) *Engine {
+	states, err := parseStates([]byte(flow), ".yml")
+	assert.NoError(t, err)
+
+	return NewEngine(states)
+}
+
+func TestEngine_Conversation(t *testing.T) {
+	engine := newTestEngine(t, testFlowYAML)
+	session := NewSession()
+
+	texts, err := engine.Start(session)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Hello, I'm a bot.", "What is your name?"}, texts)
+	assert.Equal(t, int64(1), session.StateID)
+
+	// isEmpty({name}) doesn't hold, so the flow goes left and asks again
+	texts, err = engine.Answer(session, "Anna")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"What is your name?"}, texts)
+	assert.Equal(t, "Anna", session.Memory["name"])
+
+	texts, err = engine.Answer(session, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Bye, !"}, texts)
+	assert.True(t, session.Done)
+
+	_, err = engine.Answer(session, "again")
+	assert.ErrorIs(t, err, errConversationOver)
+}
+
+func TestEngine_SampleFlow(t *testing.T) {
+	// the flow shipped with the repository must hold a whole conversation
+	states, err := loadStates(filepath.Join("..", "conversation.yml"))
+	assert.NoError(t, err)
+	engine := NewEngine(states)
+	session := NewSession()
+
+	texts, err := engine.Start(session)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Hello, I'm a bot.", "What is your name?"}, texts)
+
+	texts, err = engine.Answer(session, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"What is your name?"}, texts)
+
+	texts, err = engine.Answer(session, "Anna")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"How can I help you, Anna?"}, texts)
+
+	texts, err = engine.Answer(session, "What's the weather?")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"How can I help you, Anna?"}, texts)
+
+	texts, err = engine.Answer(session, "ok, bye")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Thank you, good bye!"}, texts)
+	assert.Equal(t, int64(999), session.StateID)
+	assert.True(t, session.Done)
+}
+
+func TestEngine_Observer(t *testing.T) {
+	engine := newTestEngine(t, testFlowYAML)
+	session := NewSession()
+
+	recorder := &TraceRecorder{}
+	engine.Observe(recorder)
+
+	_, _ = engine.Start(session)
+	_, _ = engine.Answer(session, "Anna")
+	_, _ = engine.Answer(session, "")
+
+	var transitions []TraceEvent
+	for _, event := range recorder.Events {
+		if event.Type == eventTransition {
+			transitions = append(transitions, event)
+		}
+	}
+
+	// Assertions
+	assert.Equal(t, []string{
+		eventStateEntered, eventHook, eventStateExited, eventTransition,
+		eventStateEntered,
+		eventInput, eventStateExited, eventTransition, eventStateEntered,
+		eventInput, eventStateExited, eventTransition, eventStateEntered,
+	}, recorder.Types())
+	assert.Equal(t, []TraceEvent{
+		{Type: eventTransition, StateID: 0, To: 1, Edge: edgeRight, Result: true},
+		{Type: eventTransition, StateID: 1, To: 1, Edge: edgeLeft, Condition: "isEmpty({name})"},
+		{Type: eventTransition, StateID: 1, To: 2, Edge: edgeRight, Condition: "isEmpty({name})", Result: true},
+	}, transitions)
+	assert.Equal(t, TraceEvent{Type: eventInput, StateID: 1, Value: "Anna"}, recorder.Events[5])
+}
+
+func TestEngine_ObserverError(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    text: "Hi"
+    next:
+      right: 7
+`)
+
+	recorder := &TraceRecorder{}
+	engine.Observe(recorder)
+
+	_, _ = engine.Start(NewSession())
+
+	// Assertions
+	assert.Equal(t, TraceEvent{Type: eventError, StateID: 7, Err: "no state with id 7"}, recorder.Events[len(recorder.Events)-1])
+}
+
+func TestEngine_HookError(t *testing.T) {
+	engine := newTestEngine(t, testFlowYAML)
+	engine.functions = functions{
+		"print": func(s string) error {
+			return errors.New("printer is on fire")
+		},
+	}
+
+	_, err := engine.Start(NewSession())
+
+	// Assertions
+	assert.EqualError(t, err, "state 0: before: printer is on fire")
+}
+
+func TestEngine_MissingState(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    text: "Hi"
+    next:
+      right: 7
+`)
+
+	texts, err := engine.Start(NewSession())
+
+	// Assertions
+	assert.Equal(t, []string{"Hi"}, texts)
+	assert.EqualError(t, err, "no state with id 7")
+}
+
+func TestEngine_LoopWithoutInput(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    next:
+      right: 0
+`)
+
+	_, err := engine.Start(NewSession())
+
+	// Assertions
+	assert.EqualError(t, err, "state 0: more than 100 transitions without user input")
+}
+
+func TestRender(t *testing.T) {
+	text := render("{greeting}, {name}! Bye, {name}.", memory{"greeting": "Hi", "name": "Anna"})
+
+	// Assertions
+	assert.Equal(t, "Hi, Anna! Bye, Anna.", text)
+}
+
+func TestEngine_BreakpointAndContinue(t *testing.T) {
+	engine := newTestEngine(t, testFlowYAML)
+	engine.breakAt = func(stateID int64) bool { return stateID == 1 }
+	session := NewSession()
+
+	texts, err := engine.Start(session)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Hello, I'm a bot."}, texts)
+	assert.True(t, session.Paused)
+
+	_, err = engine.Answer(session, "Anna")
+	assert.ErrorIs(t, err, errPaused)
+
+	texts, err = engine.Continue(session)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"What is your name?"}, texts)
+	assert.False(t, session.Paused)
+
+	_, err = engine.Continue(session)
+	assert.EqualError(t, err, "conversation is not paused")
+}
+
+func TestEngine_Goto(t *testing.T) {
+	engine := newTestEngine(t, testFlowYAML)
+	session := NewSession()
+	_, _ = engine.Start(session)
+	_, _ = engine.Answer(session, "")
+	assert.True(t, session.Done)
+
+	texts, err := engine.Goto(session, 1)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"What is your name?"}, texts)
+	assert.False(t, session.Done)
+
+	_, err = engine.Goto(session, 42)
+	assert.EqualError(t, err, "no state with id 42")
+}
+
+func TestEngine_ObserverHook(t *testing.T) {
+	engine := newTestEngine(t, testFlowYAML)
+	engine.functions = functions{"print": func(s string) error { return errors.New("out of paper") }}
+
+	recorder := &TraceRecorder{}
+	engine.Observe(recorder)
+
+	session := NewSession()
+	session.Memory["header"] = "Welcome"
+	_, _ = engine.Start(session)
+
+	// Assertions
+	assert.Contains(t, recorder.Events, TraceEvent{
+		Type:    eventHook,
+		StateID: 0,
+		Stage:   "before",
+		Hook:    "print({header})",
+		Value:   "Welcome",
+		Err:     "out of paper",
+	})
+}
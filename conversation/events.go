@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	sessionInput      = "input"
+	sessionOutput     = "output"
+	sessionTransition = "transition"
+	sessionMemory     = "memory"
+	sessionStatus     = "status"
+	sessionUndo       = "undo"
+
+	// edgeJump marks moves to a state that no transition chose, like gotos
+	// and operators resuming the conversation
+	edgeJump = "jump"
+)
+
+var errNothingToUndo = errors.New("nothing to undo")
+
+// SessionEvent is one entry of a session's append-only history. Folding the
+// events in order rebuilds the session.
+type SessionEvent struct {
+	Seq  int       `json:"seq"` // 1 for the first event, without gaps
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// input and output
+	Role string `json:"role,omitempty"`
+	Text string `json:"text,omitempty"`
+
+	// transition
+	From int64  `json:"from,omitempty"`
+	To   int64  `json:"to,omitempty"`
+	Edge string `json:"edge,omitempty"`
+
+	// memory: a nil value removes the key
+	Key   string  `json:"key,omitempty"`
+	Value *string `json:"value,omitempty"`
+
+	// status
+	Status *sessionState `json:"status,omitempty"`
+
+	// undo: the session is back to where it was after event Back
+	Back int `json:"back,omitempty"`
+}
+
+// sessionState is where a session stands apart from its state id, memory and
+// transcript.
+type sessionState struct {
+	Flow       string         `json:"flow,omitempty"`
+	Done       bool           `json:"done,omitempty"`
+	Paused     bool           `json:"paused,omitempty"`
+	Pending    []string       `json:"pending,omitempty"`
+	Candidates []string       `json:"candidates,omitempty"`
+	Handoff    *handoffStatus `json:"handoff,omitempty"`
+	Summarized int            `json:"summarized,omitempty"`
+	Reminded   int            `json:"reminded,omitempty"`
+}
+
+// folded is what the session's events add up to, so sync can log the changes
+// made since.
+type folded struct {
+	stateID int64
+	memory  memory
+	status  sessionState
+}
+
+// Seq is the sequence number of the session's last event. Writers holding an
+// older one know somebody else changed the session in the meantime.
+func (s *Session) Seq() int {
+	return len(s.Events)
+}
+
+func (s *Session) status() sessionState {
+	st := sessionState{
+		Flow:       s.Flow,
+		Done:       s.Done,
+		Paused:     s.Paused,
+		Pending:    append([]string(nil), s.Pending...),
+		Candidates: append([]string(nil), s.Candidates...),
+		Summarized: s.Summarized,
+		Reminded:   s.Reminded,
+	}
+	if s.Handoff != nil {
+		handoff := *s.Handoff
+		st.Handoff = &handoff
+	}
+
+	return st
+}
+
+// log appends the event to the history and folds it into what the history
+// adds up to. The session itself is left alone.
+func (s *Session) log(ev SessionEvent) {
+	ev.Seq = len(s.Events) + 1
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	s.Events = append(s.Events, ev)
+
+	if s.seen == nil {
+		s.seen = &folded{stateID: startStateID, memory: make(memory)}
+	}
+
+	switch ev.Type {
+	case sessionTransition:
+		s.seen.stateID = ev.To
+	case sessionMemory:
+		if ev.Value == nil {
+			delete(s.seen.memory, ev.Key)
+		} else {
+			s.seen.memory[ev.Key] = *ev.Value
+		}
+	case sessionStatus:
+		s.seen.status = *ev.Status
+	}
+}
+
+// apply changes the session as the event says.
+func (s *Session) apply(ev SessionEvent) {
+	switch ev.Type {
+	case sessionInput, sessionOutput:
+		s.Transcript = append(s.Transcript, transcriptEntry{Role: ev.Role, Text: ev.Text, Time: ev.Time})
+	case sessionTransition:
+		s.StateID = ev.To
+	case sessionMemory:
+		if ev.Value == nil {
+			delete(s.Memory, ev.Key)
+		} else {
+			s.Memory[ev.Key] = *ev.Value
+		}
+	case sessionStatus:
+		st := *ev.Status
+		s.Flow, s.Done, s.Paused, s.Handoff = st.Flow, st.Done, st.Paused, nil
+		if st.Handoff != nil {
+			// claims change the handoff in place, the event must stay as logged
+			handoff := *st.Handoff
+			s.Handoff = &handoff
+		}
+		s.Summarized, s.Reminded = st.Summarized, st.Reminded
+		s.Pending, s.Candidates = append([]string(nil), st.Pending...), append([]string(nil), st.Candidates...)
+		if len(s.Pending) == 0 {
+			s.Pending = nil
+		}
+		if len(s.Candidates) == 0 {
+			s.Candidates = nil
+		}
+	}
+}
+
+// sync logs the changes made to the session's state, memory and status since
+// its last event.
+func (s *Session) sync() {
+	if s.seen == nil {
+		s.seen = &folded{stateID: startStateID, memory: make(memory)}
+	}
+
+	if s.StateID != s.seen.stateID {
+		s.log(SessionEvent{Type: sessionTransition, From: s.seen.stateID, To: s.StateID, Edge: edgeJump})
+	}
+
+	keys := make([]string, 0, len(s.Memory)+len(s.seen.memory))
+	for k := range s.Memory {
+		keys = append(keys, k)
+	}
+	for k := range s.seen.memory {
+		if _, ok := s.Memory[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v, ok := s.Memory[k]
+		old, seen := s.seen.memory[k]
+		switch {
+		case !ok:
+			s.log(SessionEvent{Type: sessionMemory, Key: k})
+		case !seen || v != old:
+			s.log(SessionEvent{Type: sessionMemory, Key: k, Value: &v})
+		}
+	}
+
+	if st := s.status(); !reflect.DeepEqual(st, s.seen.status) {
+		s.log(SessionEvent{Type: sessionStatus, Status: &st})
+	}
+}
+
+// Replay rebuilds a session by folding its events.
+func Replay(events []SessionEvent) *Session {
+	s := NewSession()
+	for _, ev := range events {
+		if ev.Type == sessionUndo {
+			s = Replay(events[:ev.Back])
+			continue
+		}
+		s.apply(ev)
+	}
+
+	s.Events = append([]SessionEvent(nil), events...)
+	s.seen = &folded{stateID: s.StateID, memory: make(memory, len(s.Memory)), status: s.status()}
+	for k, v := range s.Memory {
+		s.seen.memory[k] = v
+	}
+
+	return s
+}
+
+// At rebuilds the session as it was right after the event with the given
+// sequence number.
+func (s *Session) At(seq int) (*Session, error) {
+	if seq < 0 || seq > len(s.Events) {
+		return nil, fmt.Errorf("no event %d, the session has %d", seq, len(s.Events))
+	}
+
+	return Replay(s.Events[:seq]), nil
+}
+
+// Undo takes back the last turns of the conversation: the session goes back
+// to where it was before the user gave the answer of the turn. The undone
+// events stay in the history.
+func (e *Engine) Undo(s *Session, turns int) error {
+	if turns < 1 {
+		return errors.New("turns must be positive")
+	}
+
+	// the inputs of the turns that led to the current session, in order
+	var inputs []int
+	for _, ev := range s.Events {
+		switch ev.Type {
+		case sessionInput:
+			inputs = append(inputs, ev.Seq)
+		case sessionUndo:
+			for len(inputs) > 0 && inputs[len(inputs)-1] > ev.Back {
+				inputs = inputs[:len(inputs)-1]
+			}
+		}
+	}
+
+	if len(inputs) < turns {
+		return errNothingToUndo
+	}
+
+	s.sync()
+	s.log(SessionEvent{Type: sessionUndo, Back: inputs[len(inputs)-turns] - 1})
+	*s = *Replay(s.Events)
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplay(t *testing.T) {
+	engine := newTestEngine(t, testFlowYAML)
+	session := NewSession()
+	_, _ = engine.Start(session)
+	_, _ = engine.Answer(session, "Anna")
+	_, _ = engine.Answer(session, "")
+
+	replayed := Replay(session.Events)
+
+	// Assertions
+	assert.Equal(t, session.StateID, replayed.StateID)
+	assert.Equal(t, session.Memory, replayed.Memory)
+	assert.Equal(t, session.Transcript, replayed.Transcript)
+	assert.Equal(t, session.status(), replayed.status())
+	assert.Equal(t, session.Seq(), replayed.Seq())
+	for i, ev := range session.Events {
+		assert.Equal(t, i+1, ev.Seq)
+	}
+}
+
+func TestSession_At(t *testing.T) {
+	engine := newTestEngine(t, testFlowYAML)
+	session := NewSession()
+	_, _ = engine.Start(session)
+	seq := session.Seq()
+	_, _ = engine.Answer(session, "Anna")
+
+	past, err := session.At(seq)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), past.StateID)
+	assert.Empty(t, past.Memory)
+	assert.Len(t, past.Transcript, 2)
+	assert.Equal(t, "Anna", session.Memory["name"])
+
+	_, err = session.At(session.Seq() + 1)
+	assert.Error(t, err)
+}
+
+func TestEngine_Undo(t *testing.T) {
+	engine := newTestEngine(t, testFlowYAML)
+	session := NewSession()
+	_, _ = engine.Start(session)
+	_, _ = engine.Answer(session, "Anna")
+	_, _ = engine.Answer(session, "")
+	assert.True(t, session.Done)
+	logged := session.Seq()
+
+	err := engine.Undo(session, 1)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.False(t, session.Done)
+	assert.Equal(t, int64(1), session.StateID)
+	assert.Equal(t, "Anna", session.Memory["name"])
+	assert.Len(t, session.Transcript, 4)
+	assert.Equal(t, logged+1, session.Seq())
+	assert.Equal(t, sessionUndo, session.Events[logged].Type)
+
+	texts, err := engine.Answer(session, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Bye, !"}, texts)
+
+	assert.NoError(t, engine.Undo(session, 2))
+	assert.Equal(t, int64(1), session.StateID)
+	assert.Empty(t, session.Memory)
+	assert.Len(t, session.Transcript, 2)
+
+	assert.ErrorIs(t, engine.Undo(session, 1), errNothingToUndo)
+}
+
+func TestSession_SyncLogsChanges(t *testing.T) {
+	session := NewSession()
+	session.Memory["a"] = "1"
+	session.Memory["b"] = "2"
+	session.sync()
+	delete(session.Memory, "a")
+	session.StateID = 3
+	session.sync()
+	session.sync()
+
+	types := make([]string, 0, len(session.Events))
+	for _, ev := range session.Events {
+		types = append(types, ev.Type)
+	}
+
+	// Assertions
+	assert.Equal(t, []string{sessionMemory, sessionMemory, sessionTransition, sessionMemory}, types)
+	assert.Nil(t, session.Events[3].Value)
+	assert.Equal(t, edgeJump, session.Events[2].Edge)
+}
+
+func TestEngine_NewSessionAtStart(t *testing.T) {
+	engine := newTestEngine(t, "start: 2\nstates: [{id: 2, text: Hi}]")
+
+	session := engine.NewSession()
+
+	// Assertions
+	assert.Equal(t, int64(2), session.StateID)
+	assert.Equal(t, int64(2), Replay(session.Events).StateID)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"OpenAI-api/api/model"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const saveFieldsFunction = "save_fields"
+
+// Field is a value extracted from the user's free-text answer into memory.
+type Field struct {
+	Name        string `yaml:"name" json:"name" toml:"name"`
+	Type        string `yaml:"type" json:"type,omitempty" toml:"type,omitempty"` // string (default), number, integer or boolean
+	Description string `yaml:"description" json:"description,omitempty" toml:"description,omitempty"`
+	Ask         string `yaml:"ask" json:"ask,omitempty" toml:"ask,omitempty"` // follow-up question when the field is missing
+	Optional    bool   `yaml:"optional" json:"optional,omitempty" toml:"optional,omitempty"`
+	Secret      bool   `yaml:"secret" json:"secret,omitempty" toml:"secret,omitempty"` // masked like secret inputs
+}
+
+func (f Field) jsonType() string {
+	if f.Type == "" {
+		return "string"
+	}
+
+	return f.Type
+}
+
+// question asks the user for the field when the answer didn't contain it.
+func (f Field) question(m memory) string {
+	if f.Ask != "" {
+		return render(f.Ask, m)
+	}
+
+	what := f.Description
+	if what == "" {
+		what = f.Name
+	}
+
+	return "Could you tell me your " + what + "?"
+}
+
+// extract asks the LLM to pull the fields out of the answer, stores the found
+// ones in memory and returns the required fields that are still missing.
+func (e *Engine) extract(state *State, fields []Field, question, answer string, m memory) ([]Field, error) {
+	if e.llm == nil {
+		return nil, errNoLLM
+	}
+
+	properties := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		properties[f.Name] = map[string]string{
+			"type":        f.jsonType(),
+			"description": f.Description,
+		}
+	}
+
+	parameters, err := json.Marshal(map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body := chatRequest(state.LLM,
+		model.Message{Role: "system", Content: "Extract the requested fields from the user's last message and save them. Leave out every field the user did not mention, never guess."},
+		model.Message{Role: "assistant", Content: question},
+		model.Message{Role: "user", Content: answer},
+	)
+	body.Functions = []model.Function{{
+		Name:        saveFieldsFunction,
+		Description: "Save the fields mentioned by the user.",
+		Parameters:  parameters,
+	}}
+	body.FunctionCall = map[string]string{"name": saveFieldsFunction}
+
+	resp, err := e.llm.Chat(body)
+	if err != nil {
+		return nil, err
+	}
+
+	call := resp.Choices[0].Message.FunctionCall
+	if call == nil {
+		return nil, fmt.Errorf("model did not call %s", saveFieldsFunction)
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(call.Arguments), &values); err != nil {
+		return nil, fmt.Errorf("invalid %s arguments: %w", saveFieldsFunction, err)
+	}
+
+	var missing []Field
+	for _, f := range fields {
+		value := formatValue(values[f.Name])
+		if value != "" {
+			m[f.Name] = value
+			continue
+		}
+		if !f.Optional {
+			missing = append(missing, f)
+		}
+	}
+
+	return missing, nil
+}
+
+// formatValue converts a decoded JSON value to its memory representation.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+func followUp(missing []Field, m memory) string {
+	questions := make([]string, 0, len(missing))
+	for _, f := range missing {
+		questions = append(questions, f.question(m))
+	}
+
+	return strings.Join(questions, " ")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"OpenAI-api/api/model"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const extractFlowYAML = `
+states:
+  - id: 0
+    text: "Who are you and what is your order?"
+    input: "intro"
+    extract:
+      - name: "name"
+        description: "first name"
+      - name: "order"
+        type: "integer"
+        description: "order number"
+        ask: "What is your order number, {name}?"
+      - name: "vip"
+        type: "boolean"
+        optional: true
+    next:
+      right: 1
+  - id: 1
+    text: "Thanks {name}, looking up order {order}."
+`
+
+func TestEngine_ExtractAllFields(t *testing.T) {
+	engine := newTestEngine(t, extractFlowYAML)
+	stub := &chatStub{calls: map[string][]string{saveFieldsFunction: {`{"name": "Anna", "order": 4411}`}}}
+	engine.llm = stub
+	session := NewSession()
+
+	_, err := engine.Start(session)
+	assert.NoError(t, err)
+
+	texts, err := engine.Answer(session, "I'm Anna and my order is 4411")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Thanks Anna, looking up order 4411."}, texts)
+	assert.Equal(t, "I'm Anna and my order is 4411", session.Memory["intro"])
+	assert.NotContains(t, session.Memory, "vip")
+	assert.True(t, session.Done)
+
+	// the request forces the function call and describes every field
+	body := stub.requests[0]
+	assert.Equal(t, map[string]string{"name": saveFieldsFunction}, body.FunctionCall)
+	assert.Equal(t, "I'm Anna and my order is 4411", body.Messages[len(body.Messages)-1].Content)
+	assert.JSONEq(t, `{"type": "object", "properties": {
+		"name": {"type": "string", "description": "first name"},
+		"order": {"type": "integer", "description": "order number"},
+		"vip": {"type": "boolean", "description": ""}
+	}}`, string(body.Functions[0].Parameters))
+}
+
+func TestEngine_ExtractAsksForMissingFields(t *testing.T) {
+	engine := newTestEngine(t, extractFlowYAML)
+	stub := &chatStub{calls: map[string][]string{saveFieldsFunction: {`{"name": "Anna"}`, `{}`, `{"order": 4411}`}}}
+	engine.llm = stub
+	session := NewSession()
+
+	_, _ = engine.Start(session)
+
+	texts, err := engine.Answer(session, "I'm Anna")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"What is your order number, Anna?"}, texts)
+	assert.Equal(t, []string{"order"}, session.Pending)
+	assert.Equal(t, int64(0), session.StateID)
+
+	texts, err = engine.Answer(session, "I don't remember")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"What is your order number, Anna?"}, texts)
+
+	texts, err = engine.Answer(session, "found it, 4411")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Thanks Anna, looking up order 4411."}, texts)
+	assert.Empty(t, session.Pending)
+
+	// follow-ups only ask the model for the missing field
+	var parameters struct {
+		Properties map[string]interface{} `json:"properties"`
+	}
+	assert.NoError(t, json.Unmarshal(stub.requests[2].Functions[0].Parameters, &parameters))
+	assert.Len(t, parameters.Properties, 1)
+	assert.Contains(t, parameters.Properties, "order")
+	assert.Equal(t, "What is your order number, Anna?", stub.requests[2].Messages[1].Content)
+}
+
+func TestEngine_ExtractWithoutLLM(t *testing.T) {
+	engine := newTestEngine(t, extractFlowYAML)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	_, err := engine.Answer(session, "I'm Anna")
+
+	// Assertions
+	assert.ErrorIs(t, err, errNoLLM)
+}
+
+func TestEngine_ExtractInvalidArguments(t *testing.T) {
+	engine := newTestEngine(t, extractFlowYAML)
+	engine.llm = &chatStub{calls: map[string][]string{saveFieldsFunction: {`not json`}}}
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	_, err := engine.Answer(session, "I'm Anna")
+
+	// Assertions
+	assert.ErrorContains(t, err, "state 0: extract: invalid save_fields arguments")
+}
+
+func TestOpenAIClient_Chat(t *testing.T) {
+	mockResponse := `{"id": "chatcmpl-123", "object": "chat.completion", "created": 1677652288, "choices": [{"index": 0, "message": {"role": "assistant", "content": null, "function_call": {"name": "save_fields", "arguments": "{\"name\": \"Anna\"}"}}, "finish_reason": "function_call"}]}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(mockResponse))
+	}))
+	defer server.Close()
+
+	client := &openAIClient{url: server.URL + "/v1", apiKey: "key"}
+	resp, err := client.Chat(chatRequest(nil, model.Message{Role: "user", Content: "I'm Anna"}))
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, "save_fields", resp.Choices[0].Message.FunctionCall.Name)
+	assert.Equal(t, `{"name": "Anna"}`, resp.Choices[0].Message.FunctionCall.Arguments)
+}
+
+func TestOpenAIClient_ChatError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := &openAIClient{url: server.URL}
+	resp, err := client.Chat(chatRequest(nil))
+
+	// Assertions
+	assert.Nil(t, resp)
+	assert.EqualError(t, err, "unexpected status code: 401")
+}
+
+func TestChatRequest_Settings(t *testing.T) {
+	body := chatRequest(&LLM{Model: "gpt-4", Temperature: 0.2})
+
+	// Assertions
+	assert.Equal(t, "gpt-4", body.Model)
+	assert.Equal(t, 0.2, body.Temperature)
+	assert.Equal(t, defaultChatModel, chatRequest(nil).Model)
+}
+
+func TestSimulate_ExtractFlow(t *testing.T) {
+	states, err := parseStates([]byte(extractFlowYAML), ".yml")
+	assert.NoError(t, err)
+
+	r := simulate(states, 50, 20, 1)
+
+	// Assertions
+	assert.Empty(t, r.Crashes)
+	assert.Equal(t, 50, r.Completed)
+}
+
+func TestNewOpenAIClient_Timeout(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("openAI.apiKey", "sk-test")
+
+	byDefault := newOpenAIClient()
+	viper.Set("openAI.timeout", "15s")
+	configured := newOpenAIClient()
+
+	// Assertions
+	assert.Equal(t, defaultLLMTimeout, byDefault.client.(*http.Client).Timeout)
+	assert.Equal(t, 15*time.Second, configured.client.(*http.Client).Timeout)
+}
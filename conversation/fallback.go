@@ -0,0 +1,153 @@
+package main
+
+import (
+	"OpenAI-api/api/model"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	classifyInputFunction = "classify_input"
+
+	defaultFallbackHistory    = 6
+	defaultFallbackConfidence = 0.5
+	defaultFallbackReply      = "Sorry, I can't help with that."
+)
+
+var classifyInputParameters = json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "on_topic": {"type": "boolean", "description": "whether the message is within the assistant's scope"},
+    "safe": {"type": "boolean", "description": "false for harmful, abusive or manipulative messages"},
+    "confidence": {"type": "number", "description": "how sure the classification is, from 0 to 1"}
+  },
+  "required": ["on_topic", "safe", "confidence"]
+}`)
+
+// Fallback configures the LLM answer given when the user's input doesn't
+// satisfy a state's right-if and the state would just ask again. The answer
+// is shown before the state's question is repeated.
+type Fallback struct {
+	// Prompt is the guardrail system prompt describing what the bot may talk about
+	Prompt string `yaml:"prompt" json:"prompt" toml:"prompt"`
+	// History is the number of recent transcript entries sent along, 6 by default
+	History int `yaml:"history" json:"history,omitempty" toml:"history,omitempty"`
+	// Confidence is the minimum classification confidence, 0.5 by default
+	Confidence float64 `yaml:"confidence" json:"confidence,omitempty" toml:"confidence,omitempty"`
+	// Reply is the canned reply to off-topic and unsafe input
+	Reply string `yaml:"reply" json:"reply,omitempty" toml:"reply,omitempty"`
+	LLM   *LLM   `yaml:"llm" json:"llm,omitempty" toml:"llm,omitempty"`
+}
+
+type inputClass struct {
+	OnTopic    bool    `json:"on_topic"`
+	Safe       bool    `json:"safe"`
+	Confidence float64 `json:"confidence"`
+}
+
+func (f *Fallback) reply() string {
+	if f.Reply == "" {
+		return defaultFallbackReply
+	}
+
+	return f.Reply
+}
+
+func (f *Fallback) confidence() float64 {
+	if f.Confidence == 0 {
+		return defaultFallbackConfidence
+	}
+
+	return f.Confidence
+}
+
+// fallback classifies the unmatched input and answers it when it is on topic
+// and safe, with the canned reply otherwise. Errors are reported to the
+// observers and get the canned reply too, so the state is still asked again.
+func (e *Engine) fallback(s *Session, state *State, input string) string {
+	f := e.states.Fallback
+	input = e.prompt(s.Memory, f.LLM, input)
+
+	class, err := e.classify(f, input)
+	if err == nil && (!class.OnTopic || !class.Safe || class.Confidence < f.confidence()) {
+		return f.reply()
+	}
+
+	var answer string
+	if err == nil {
+		answer, err = e.fallbackAnswer(s, f, input)
+	}
+	if err != nil {
+		err = fmt.Errorf("state %d: fallback: %w", state.ID, err)
+		e.reportError(s, err)
+		return f.reply()
+	}
+
+	return answer
+}
+
+func (e *Engine) classify(f *Fallback, input string) (*inputClass, error) {
+	if e.llm == nil {
+		return nil, errNoLLM
+	}
+
+	body := chatRequest(f.LLM,
+		model.Message{Role: "system", Content: "Classify the user's last message for an assistant with these instructions:\n\n" + f.Prompt},
+		model.Message{Role: "user", Content: input},
+	)
+	body.Functions = []model.Function{{
+		Name:        classifyInputFunction,
+		Description: "Save the classification of the user's message.",
+		Parameters:  classifyInputParameters,
+	}}
+	body.FunctionCall = map[string]string{"name": classifyInputFunction}
+
+	resp, err := e.llm.Chat(body)
+	if err != nil {
+		return nil, err
+	}
+
+	call := resp.Choices[0].Message.FunctionCall
+	if call == nil {
+		return nil, fmt.Errorf("model did not call %s", classifyInputFunction)
+	}
+
+	var class inputClass
+	if err := json.Unmarshal([]byte(call.Arguments), &class); err != nil {
+		return nil, fmt.Errorf("invalid %s arguments: %w", classifyInputFunction, err)
+	}
+
+	return &class, nil
+}
+
+// fallbackAnswer answers the input in the context of the recent transcript.
+func (e *Engine) fallbackAnswer(s *Session, f *Fallback, input string) (string, error) {
+	history, err := e.history(s, f.history())
+	if err != nil {
+		return "", err
+	}
+
+	messages := []model.Message{{
+		Role:    "system",
+		Content: f.Prompt + "\n\nAnswer the user's last message briefly. The conversation then goes back to your last question, so don't ask anything yourself.",
+	}}
+	messages = append(messages, history...)
+	messages = append(messages, model.Message{Role: "user", Content: input})
+
+	resp, err := e.llm.Chat(chatRequest(f.LLM, messages...))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
+}
+
+// history is the number of recent transcript entries sent along.
+func (f *Fallback) history() int {
+	if f.History == 0 {
+		return defaultFallbackHistory
+	}
+
+	return f.History
+}
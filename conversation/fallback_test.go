@@ -0,0 +1,101 @@
+package main
+
+import (
+	"OpenAI-api/api/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fallbackFlowYAML = `
+fallback:
+  prompt: "You are the ACME order bot. Only talk about orders."
+  history: 2
+  reply: "I can only help with orders."
+states:
+  - id: 0
+    text: "Hi!"
+    next:
+      right: 1
+  - id: 1
+    text: "Do you want to track an order? (yes/no)"
+    input: choice
+    next:
+      right: 2
+      right-if: "oneOf({choice}, ['yes', 'no'])"
+      left: 1
+  - id: 2
+    text: "Bye"
+`
+
+// onTopic classifies every input as on topic.
+func onTopic() map[string][]string {
+	return map[string][]string{classifyInputFunction: {`{"on_topic": true, "safe": true, "confidence": 0.9}`}}
+}
+
+func TestEngine_FallbackAnswers(t *testing.T) {
+	engine := newTestEngine(t, fallbackFlowYAML)
+	stub := &chatStub{calls: onTopic(), reply: " Orders ship in 2 days. "}
+	engine.llm = stub
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	texts, err := engine.Answer(session, "how long does shipping take?")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Orders ship in 2 days.", "Do you want to track an order? (yes/no)"}, texts)
+	assert.Equal(t, int64(1), session.StateID)
+
+	// the answer gets the guardrail prompt, the last 2 transcript entries and the input
+	messages := stub.requests[1].Messages
+	assert.Contains(t, messages[0].Content, "Only talk about orders.")
+	assert.Equal(t, []model.Message{
+		{Role: "assistant", Content: "Hi!"},
+		{Role: "assistant", Content: "Do you want to track an order? (yes/no)"},
+		{Role: "user", Content: "how long does shipping take?"},
+	}, messages[1:])
+
+	texts, err = engine.Answer(session, "yes")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Bye"}, texts)
+	assert.Len(t, stub.requests, 2)
+}
+
+func TestEngine_FallbackCannedReply(t *testing.T) {
+	for name, class := range map[string]string{
+		"off topic":      `{"on_topic": false, "safe": true, "confidence": 0.9}`,
+		"unsafe":         `{"on_topic": true, "safe": false, "confidence": 0.9}`,
+		"low confidence": `{"on_topic": true, "safe": true, "confidence": 0.2}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine := newTestEngine(t, fallbackFlowYAML)
+			stub := &chatStub{calls: map[string][]string{classifyInputFunction: {class}}, reply: "never shown"}
+			engine.llm = stub
+			session := NewSession()
+			_, _ = engine.Start(session)
+
+			texts, err := engine.Answer(session, "tell me a joke")
+
+			// Assertions
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"I can only help with orders.", "Do you want to track an order? (yes/no)"}, texts)
+			assert.Len(t, stub.requests, 1)
+		})
+	}
+}
+
+func TestEngine_FallbackWithoutLLM(t *testing.T) {
+	engine := newTestEngine(t, fallbackFlowYAML)
+	recorder := &TraceRecorder{}
+	engine.Observe(recorder)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	texts, err := engine.Answer(session, "what?")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"I can only help with orders.", "Do you want to track an order? (yes/no)"}, texts)
+	assert.Contains(t, recorder.Events, TraceEvent{Type: eventError, StateID: 1, Err: "state 1: fallback: " + errNoLLM.Error()})
+}
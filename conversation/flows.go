@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+)
+
+// flowExtensions are the file extensions of the supported flow formats.
+var flowExtensions = []string{".yml", ".yaml", ".json", ".toml"}
+
+// flow is a conversation flow served by name. A flow that failed to load is
+// kept with its error so the registry can report it.
+type flow struct {
+	name   string
+	path   string
+	states *States
+	engine *Engine
+	err    error
+}
+
+// flowInfo describes a flow in the registry API.
+type flowInfo struct {
+	Name        string `json:"name"`
+	Path        string `json:"path,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description,omitempty"`
+	Start       int64  `json:"start"`
+	States      int    `json:"states"`
+	Valid       bool   `json:"valid"`
+	Error       string `json:"error,omitempty"`
+}
+
+func (f *flow) info() flowInfo {
+	info := flowInfo{Name: f.name, Path: f.path, Valid: f.err == nil}
+	if f.err != nil {
+		info.Error = f.err.Error()
+		return info
+	}
+
+	info.Version, info.Description = f.states.Version, f.states.Description
+	info.Start, info.States = f.states.Start, len(f.states.States)
+
+	return info
+}
+
+// flowRegistry holds the flows a server hosts, keyed by name.
+type flowRegistry struct {
+	flows map[string]*flow
+	names []string // sorted
+}
+
+func newFlowRegistry() *flowRegistry {
+	return &flowRegistry{flows: make(map[string]*flow)}
+}
+
+// add registers the flow and creates its engine with setup.
+func (r *flowRegistry) add(name, path string, states *States, err error, setup func(e *Engine)) *flow {
+	f := &flow{name: name, path: path, states: states, err: err}
+	if err == nil {
+		f.engine = NewEngine(states)
+		f.engine.name, f.engine.lookup = name, r.engine
+		if setup != nil {
+			setup(f.engine)
+		}
+	}
+
+	if _, ok := r.flows[name]; !ok {
+		r.names = append(r.names, name)
+		sort.Strings(r.names)
+	}
+	r.flows[name] = f
+
+	return f
+}
+
+func (r *flowRegistry) get(name string) *flow {
+	return r.flows[name]
+}
+
+// engine returns the engine of a valid flow.
+func (r *flowRegistry) engine(name string) *Engine {
+	if f := r.flows[name]; f != nil {
+		return f.engine
+	}
+
+	return nil
+}
+
+func (r *flowRegistry) list() []flowInfo {
+	infos := make([]flowInfo, 0, len(r.names))
+	for _, name := range r.names {
+		infos = append(infos, r.flows[name].info())
+	}
+
+	return infos
+}
+
+// loadFlows loads every flow file of the directory, named after the file
+// without its extension. Invalid flows are registered with their error.
+func loadFlows(dir string, setup func(e *Engine)) (*flowRegistry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := newFlowRegistry()
+	for _, entry := range entries {
+		if entry.IsDir() || !isFlowFile(entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		name := flowName(path)
+		if f := r.get(name); f != nil {
+			r.add(name, path, nil, fmt.Errorf("flow %s is also defined in %s", name, f.path), nil)
+			continue
+		}
+
+		states, err := loadStates(path)
+		r.add(name, path, states, err, setup)
+	}
+	r.checkTransfers()
+
+	return r, nil
+}
+
+func isFlowFile(name string) bool {
+	if isScriptFile(name) {
+		return false // tests of the flows next to them
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range flowExtensions {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flowName names a flow after its file.
+func flowName(path string) string {
+	base := filepath.Base(path)
+
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// flowDirectory is the directory flows are looked up in: the given one, or
+// the one of the config.
+func flowDirectory(dir string) string {
+	if dir == "" {
+		return viper.GetString("flows.dir")
+	}
+
+	return dir
+}
+
+// resolveFlow finds the file of a flow given by path, or by name in the
+// flows directory.
+func resolveFlow(flow, dir string) (string, error) {
+	if dir == "" || strings.ContainsAny(flow, `/\`) || isFlowFile(flow) {
+		return flow, nil
+	}
+
+	for _, ext := range flowExtensions {
+		path := filepath.Join(dir, flow+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("no flow %q in %s", flow, dir)
+}
+
+// loadEngine loads the flow given by path, or by name in the flows
+// directory, for the commands running a single flow. Flows of the directory
+// are loaded with the others, so they can transfer conversations to them.
+func loadEngine(flow, dir string, setup func(e *Engine)) (*Engine, string, error) {
+	path, err := resolveFlow(flow, dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if dir != "" && filepath.Clean(filepath.Dir(path)) == filepath.Clean(dir) {
+		flows, err := loadFlows(dir, setup)
+		if err != nil {
+			return nil, "", err
+		}
+		if f := flows.get(flowName(path)); f != nil {
+			return f.engine, path, f.err
+		}
+	}
+
+	states, err := loadStates(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	engine := NewEngine(states)
+	if setup != nil {
+		setup(engine)
+	}
+
+	return engine, path, nil
+}
+
+// registry API
+
+func (srv *server) handleListFlows(c echo.Context) error {
+	return c.JSON(http.StatusOK, srv.flows.list())
+}
+
+func (srv *server) handleGetFlow(c echo.Context) error {
+	f := srv.flows.get(c.Param("flow"))
+	if f == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "flow not found")
+	}
+
+	return c.JSON(http.StatusOK, f.info())
+}
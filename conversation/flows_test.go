@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFlows writes the flows to a temporary directory.
+func writeFlows(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	return dir
+}
+
+var testFlows = map[string]string{
+	"support.yml": `
+version: "2.1"
+description: "Support desk"
+start: 5
+states:
+  - id: 0
+    text: "Never shown"
+  - id: 5
+    text: "Support here, what's wrong?"
+    input: problem
+    next:
+      right: 6
+  - id: 6
+    text: "Noted"
+`,
+	"sales.json": testFlowJSON,
+	"broken.yml": "states: []",
+	"notes.txt":  "not a flow",
+}
+
+func TestLoadFlows(t *testing.T) {
+	dir := writeFlows(t, testFlows)
+
+	flows, err := loadFlows(dir, nil)
+
+	// Assertions
+	assert.NoError(t, err)
+	infos := flows.list()
+	assert.Len(t, infos, 3)
+	assert.Equal(t, "broken", infos[0].Name)
+	assert.False(t, infos[0].Valid)
+	assert.Contains(t, infos[0].Error, "states")
+	assert.Equal(t, "sales", infos[1].Name)
+	assert.True(t, infos[1].Valid)
+	assert.Equal(t, flowInfo{Name: "support", Path: filepath.Join(dir, "support.yml"), Version: "2.1", Description: "Support desk", Start: 5, States: 3, Valid: true}, infos[2])
+	assert.Nil(t, flows.get("broken").engine)
+
+	_, err = loadFlows(filepath.Join(dir, "missing"), nil)
+	assert.Error(t, err)
+}
+
+func TestResolveFlow(t *testing.T) {
+	dir := writeFlows(t, testFlows)
+
+	path, err := resolveFlow("sales", dir)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "sales.json"), path)
+
+	_, err = resolveFlow("nope", dir)
+	assert.EqualError(t, err, `no flow "nope" in `+dir)
+
+	// Assertions
+	for _, flow := range []string{"./conversation.yml", "flow.toml"} {
+		path, err = resolveFlow(flow, dir)
+		assert.NoError(t, err)
+		assert.Equal(t, flow, path)
+	}
+	path, err = resolveFlow("support", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "support", path)
+}
+
+func newTestFlowsServer(t *testing.T, defaultFlow string) *echo.Echo {
+	flows, err := loadFlows(writeFlows(t, testFlows), nil)
+	assert.NoError(t, err)
+
+	e := echo.New()
+	srv := newServer(flows, defaultFlow)
+	srv.routes(e)
+	srv.adminRoutes(e, testAdminToken)
+
+	return e
+}
+
+func TestServer_Flows(t *testing.T) {
+	e := newTestFlowsServer(t, "")
+
+	var infos []flowInfo
+	rec := do(t, e, http.MethodGet, "/flows", "", &infos)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, infos, 3)
+
+	var info flowInfo
+	rec = do(t, e, http.MethodGet, "/flows/support", "", &info)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2.1", info.Version)
+
+	rec = do(t, e, http.MethodGet, "/flows/nope", "", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	var support, sales sessionReply
+	rec = do(t, e, http.MethodPost, "/flows/support/sessions", "", &support)
+
+	// Assertions
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "support", support.Flow)
+	assert.Equal(t, []string{"Support here, what's wrong?"}, support.Messages)
+
+	rec = do(t, e, http.MethodPost, "/sessions", `{"flow": "sales"}`, &sales)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, []string{"Hello, I'm a bot.", "What is your name?"}, sales.Messages)
+
+	var reply sessionReply
+	do(t, e, http.MethodPost, "/sessions/"+support.ID+"/messages", `{"text": "printer"}`, &reply)
+	assert.Equal(t, []string{"Noted"}, reply.Messages)
+
+	for body, code := range map[string]int{
+		``:                   http.StatusBadRequest,
+		`{"flow": "nope"}`:   http.StatusNotFound,
+		`{"flow": "broken"}`: http.StatusConflict,
+	} {
+		rec = do(t, e, http.MethodPost, "/sessions", body, nil)
+		assert.Equal(t, code, rec.Code, body)
+	}
+}
+
+func TestServer_DefaultFlow(t *testing.T) {
+	e := newTestFlowsServer(t, "sales")
+
+	var reply sessionReply
+	rec := do(t, e, http.MethodPost, "/sessions", "", &reply)
+
+	// Assertions
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "sales", reply.Flow)
+}
+
+func TestAdminAPI_FlowBroadcast(t *testing.T) {
+	e := newTestFlowsServer(t, "")
+	do(t, e, http.MethodPost, "/flows/support/sessions", "", nil)
+	do(t, e, http.MethodPost, "/flows/sales/sessions", "", nil)
+
+	var sent map[string]int
+	doAdmin(t, e, http.MethodPost, "/admin/broadcast", `{"flow": "support", "text": "Support closes at 6pm"}`, &sent)
+
+	var sessions []adminSession
+	doAdmin(t, e, http.MethodGet, "/admin/sessions?flow=sales", "", &sessions)
+
+	// Assertions
+	assert.Equal(t, map[string]int{"sessions": 1}, sent)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, "sales", sessions[0].Flow)
+}
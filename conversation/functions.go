@@ -0,0 +1,417 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hooks, set assignments and right-if conditions are calls like
+//
+//	total = sum({a}, {b}, 10)
+//	contains({prompt}, 'bye')
+//
+// Arguments are {var} memory values, 'quoted' or "quoted" strings, numbers,
+// true/false and [lists, of, them]. They are converted to the Go types of the
+// registered function's parameters: string, int, int64, float64, bool and
+// []string, variadic ones included. Empty values convert to zero, so counters
+// work before they are first set. A memory value is split on commas when a
+// list is expected.
+//
+// Functions may return nothing, a value, an error or a value and an error.
+// The value of an assignment is formatted like an extracted field and stored
+// in memory. Filters used in conditions must return a bool.
+
+// functions are the Go functions hooks and set assignments can call.
+type functions map[string]interface{}
+
+// filters are the Go functions right-if conditions can call.
+type filters map[string]interface{}
+
+const dateLayout = "2006-01-02"
+
+var (
+	callRe       = regexp.MustCompile(`^(?:([A-Za-z_][\w.]*)\s*=\s*)?([A-Za-z_]\w*)\s*\((.*)\)$`)
+	assignRe     = regexp.MustCompile(`^([A-Za-z_][\w.]*)\s*=\s*(.+)$`)
+	errNotAFunc  = errors.New("not a function")
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+	stringsType  = reflect.TypeOf([]string(nil))
+	now          = time.Now
+	errDivByZero = errors.New("division by zero")
+)
+
+var (
+	ff = functions{
+		"print": printTo(os.Stdout),
+
+		// strings
+		"upper":   strings.ToUpper,
+		"lower":   strings.ToLower,
+		"trim":    strings.TrimSpace,
+		"replace": func(s, old, new string) string { return strings.ReplaceAll(s, old, new) },
+		"concat":  func(values ...string) string { return strings.Join(values, "") },
+		"join":    func(values []string, sep string) string { return strings.Join(values, sep) },
+		"length":  func(s string) int { return len([]rune(s)) },
+
+		// math
+		"sum": func(values ...float64) float64 {
+			var total float64
+			for _, v := range values {
+				total += v
+			}
+			return total
+		},
+		"sub": func(a, b float64) float64 { return a - b },
+		"mul": func(values ...float64) float64 {
+			product := 1.0
+			for _, v := range values {
+				product *= v
+			}
+			return product
+		},
+		"div": func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, errDivByZero
+			}
+			return a / b, nil
+		},
+		"round": func(x float64, digits int) float64 {
+			shift := math.Pow(10, float64(digits))
+			return math.Round(x*shift) / shift
+		},
+		"min": math.Min,
+		"max": math.Max,
+
+		// dates, formatted as 2006-01-02; the date inputs' timestamps are
+		// accepted too
+		"today": func() string { return now().Format(dateLayout) },
+		"addDays": func(date string, days int) (string, error) {
+			t, err := parseDay(date)
+			if err != nil {
+				return "", err
+			}
+			return t.AddDate(0, 0, days).Format(dateLayout), nil
+		},
+		"daysBetween": func(from, to string) (int, error) {
+			a, err := parseDay(from)
+			if err != nil {
+				return 0, err
+			}
+			b, err := parseDay(to)
+			if err != nil {
+				return 0, err
+			}
+			return int(b.Sub(a).Hours() / 24), nil
+		},
+		"weekday": func(date string) (string, error) {
+			t, err := parseDay(date)
+			if err != nil {
+				return "", err
+			}
+			return t.Weekday().String(), nil
+		},
+
+		// counters
+		"increment": func(n int) int { return n + 1 },
+		"decrement": func(n int) int { return n - 1 },
+	}
+	fl = filters{
+		"isEmpty": func(s string) bool {
+			return s == ""
+		},
+		"contains": func(s, sub string) bool {
+			return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+		},
+		"equals":     strings.EqualFold,
+		"startsWith": strings.HasPrefix,
+		"endsWith":   strings.HasSuffix,
+		"matches": func(s, pattern string) (bool, error) {
+			return regexp.MatchString(pattern, s)
+		},
+		"oneOf": func(s string, options []string) bool {
+			for _, o := range options {
+				if strings.EqualFold(s, o) {
+					return true
+				}
+			}
+			return false
+		},
+		"greater": func(a, b float64) bool { return a > b },
+		"less":    func(a, b float64) bool { return a < b },
+	}
+)
+
+// printTo is the print function writing to w; engines write to their output.
+func printTo(w io.Writer) func(values ...string) error {
+	return func(values ...string) error {
+		if text := strings.TrimSpace(strings.Join(values, " ")); text != "" {
+			_, err := fmt.Fprintln(w, text)
+			return err
+		}
+
+		return nil
+	}
+}
+
+// call is a parsed hook, assignment or condition.
+type call struct {
+	Assign string // memory key the result is stored under, empty when not assigned
+	Name   string // empty for assignments of a plain value, like status = 'open'
+	Args   []argument
+}
+
+// argument is a {var} reference, a literal or a list of arguments.
+type argument struct {
+	Var     string
+	Literal string
+	List    []argument
+	IsList  bool
+}
+
+// parseCall parses expressions like "total = sum({a}, {b})" and "status = 'open'".
+func parseCall(expr string) (*call, error) {
+	match := callRe.FindStringSubmatch(strings.TrimSpace(expr))
+	if match == nil {
+		if match = assignRe.FindStringSubmatch(strings.TrimSpace(expr)); match != nil {
+			arg, err := parseArg(match[2])
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", expr, err)
+			}
+			return &call{Assign: match[1], Args: []argument{arg}}, nil
+		}
+		return nil, fmt.Errorf("%q is not a function call", expr)
+	}
+
+	args, err := parseArgs(match[3])
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", expr, err)
+	}
+
+	return &call{Assign: match[1], Name: match[2], Args: args}, nil
+}
+
+func parseArgs(s string) ([]argument, error) {
+	parts, err := splitArgs(s)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]argument, 0, len(parts))
+	for _, part := range parts {
+		arg, err := parseArg(part)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	return args, nil
+}
+
+func parseArg(s string) (argument, error) {
+	switch {
+	case s == "":
+		return argument{}, errors.New("empty argument")
+	case strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}"):
+		return argument{Var: s[1 : len(s)-1]}, nil
+	case len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0]:
+		return argument{Literal: s[1 : len(s)-1]}, nil
+	case strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]"):
+		list, err := parseArgs(s[1 : len(s)-1])
+		return argument{List: list, IsList: true}, err
+	case s == "true" || s == "false":
+		return argument{Literal: s}, nil
+	}
+
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return argument{}, fmt.Errorf("unexpected argument %s, quote strings", s)
+	}
+
+	return argument{Literal: s}, nil
+}
+
+// splitArgs splits on the commas outside quotes, braces and brackets.
+func splitArgs(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	var parts []string
+	var quote rune
+	depth, start := 0, 0
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '[' || r == '{':
+			depth++
+		case r == ']' || r == '}':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil, fmt.Errorf("unbalanced %q", s)
+	}
+
+	return append(parts, strings.TrimSpace(s[start:])), nil
+}
+
+// values evaluates the arguments against memory; lists become []string.
+func (c *call) values(m memory) []interface{} {
+	values := make([]interface{}, 0, len(c.Args))
+	for _, arg := range c.Args {
+		values = append(values, arg.value(m))
+	}
+
+	return values
+}
+
+func (a argument) value(m memory) interface{} {
+	switch {
+	case a.IsList:
+		list := make([]string, 0, len(a.List))
+		for _, item := range a.List {
+			list = append(list, fmt.Sprint(item.value(m)))
+		}
+		return list
+	case a.Var != "":
+		return m[a.Var]
+	default:
+		return a.Literal
+	}
+}
+
+// invoke calls fn with the values converted to its parameter types and returns
+// its result, nil when it returns none.
+func invoke(fn interface{}, values []interface{}) (interface{}, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return nil, errNotAFunc
+	}
+	t := v.Type()
+
+	fixed := t.NumIn()
+	if t.IsVariadic() {
+		fixed--
+	}
+	if len(values) < fixed || (!t.IsVariadic() && len(values) > fixed) {
+		return nil, fmt.Errorf("expected %d arguments, got %d", fixed, len(values))
+	}
+
+	in := make([]reflect.Value, 0, len(values))
+	for i, value := range values {
+		var param reflect.Type
+		if i < fixed {
+			param = t.In(i)
+		} else {
+			param = t.In(fixed).Elem()
+		}
+
+		arg, err := convert(value, param)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		in = append(in, arg)
+	}
+
+	out := v.Call(in)
+	if n := len(out); n > 0 && t.Out(n-1) == errorType {
+		if err, _ := out[n-1].Interface().(error); err != nil {
+			return nil, err
+		}
+		out = out[:n-1]
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+
+	return out[0].Interface(), nil
+}
+
+func convert(value interface{}, to reflect.Type) (reflect.Value, error) {
+	if to == stringsType {
+		if list, ok := value.([]string); ok {
+			return reflect.ValueOf(list), nil
+		}
+		return reflect.ValueOf(splitList(value.(string))), nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("expected %s, got a list", to)
+	}
+	s = strings.TrimSpace(s)
+
+	switch to.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(value).Convert(to), nil
+	case reflect.Int, reflect.Int64:
+		if s == "" {
+			return reflect.Zero(to), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("expected an integer, got %q", s)
+		}
+		return reflect.ValueOf(n).Convert(to), nil
+	case reflect.Float64:
+		if s == "" {
+			return reflect.Zero(to), nil
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("expected a number, got %q", s)
+		}
+		return reflect.ValueOf(n), nil
+	case reflect.Bool:
+		if s == "" {
+			return reflect.Zero(to), nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("expected true or false, got %q", s)
+		}
+		return reflect.ValueOf(b), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("unsupported parameter type %s", to)
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// formatResult formats a function's result for memory.
+func formatResult(v interface{}) string {
+	switch v := v.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case []string:
+		return strings.Join(v, ", ")
+	default:
+		return formatValue(v)
+	}
+}
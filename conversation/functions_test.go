@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCall(t *testing.T) {
+	c, err := parseCall(`total = sum({a}, 'x, y', [1, {b}], -2.5, true)`)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, &call{
+		Assign: "total",
+		Name:   "sum",
+		Args: []argument{
+			{Var: "a"},
+			{Literal: "x, y"},
+			{List: []argument{{Literal: "1"}, {Var: "b"}}, IsList: true},
+			{Literal: "-2.5"},
+			{Literal: "true"},
+		},
+	}, c)
+
+	_, err = parseCall("sum({a}, b)")
+	assert.EqualError(t, err, `"sum({a}, b)": unexpected argument b, quote strings`)
+
+	_, err = parseCall("{a} + {b}")
+	assert.EqualError(t, err, `"{a} + {b}" is not a function call`)
+}
+
+func TestInvoke(t *testing.T) {
+	m := memory{"a": "2", "b": "3.5", "tags": "red, green"}
+	eval := func(name, expr string) (interface{}, error) {
+		c, err := parseCall(expr)
+		assert.NoError(t, err)
+
+		fn := ff[name]
+		if fn == nil {
+			fn = fl[name]
+		}
+		return invoke(fn, c.values(m))
+	}
+
+	// Assertions
+	result, err := eval("sum", "sum({a}, {b}, 1)")
+	assert.NoError(t, err)
+	assert.Equal(t, 6.5, result)
+
+	result, err = eval("increment", "increment({count})")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result)
+
+	result, err = eval("oneOf", "oneOf('Green', {tags})")
+	assert.NoError(t, err)
+	assert.Equal(t, true, result)
+
+	result, err = eval("join", "join(['a', {a}], '-')")
+	assert.NoError(t, err)
+	assert.Equal(t, "a-2", result)
+
+	_, err = eval("div", "div({a}, 0)")
+	assert.ErrorIs(t, err, errDivByZero)
+
+	_, err = eval("sub", "sub({a})")
+	assert.EqualError(t, err, "expected 2 arguments, got 1")
+
+	_, err = eval("greater", "greater({tags}, 1)")
+	assert.EqualError(t, err, `argument 1: expected a number, got "red, green"`)
+}
+
+func TestBuiltins_Dates(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	now = func() time.Time { return time.Date(2023, 8, 30, 12, 0, 0, 0, time.UTC) }
+
+	today, _ := invoke(ff["today"], nil)
+	later, _ := invoke(ff["addDays"], []interface{}{"2023-08-30", "3"})
+	days, _ := invoke(ff["daysBetween"], []interface{}{"2023-08-30", "2023-09-02"})
+	weekday, _ := invoke(ff["weekday"], []interface{}{"2023-09-02"})
+
+	// Assertions
+	assert.Equal(t, "2023-08-30", today)
+	assert.Equal(t, "2023-09-02", later)
+	assert.Equal(t, 3, days)
+	assert.Equal(t, "Saturday", weekday)
+}
+
+func TestEngine_SetAndConditions(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    text: "How many items?"
+    input: items
+    after: "count = increment({count})"
+    next:
+      right: 1
+  - id: 1
+    set:
+      - "total = mul({items}, 2.5)"
+      - "label = upper({name})"
+    text: "{label}: {total} after {count} question(s)"
+    next:
+      right: 2
+      right-if: "greater({total}, 10)"
+      left: 0
+  - id: 2
+    text: "Bye"
+`)
+	session := NewSession()
+	session.Memory["name"] = "anna"
+
+	_, _ = engine.Start(session)
+	texts, err := engine.Answer(session, "2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ANNA: 5 after 1 question(s)", "How many items?"}, texts)
+
+	texts, err = engine.Answer(session, "6")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ANNA: 15 after 2 question(s)", "Bye"}, texts)
+	assert.True(t, session.Done)
+}
+
+func TestEngine_ConditionError(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    input: age
+    next:
+      right: 1
+      right-if: "greater({age}, 17)"
+      left: 0
+  - id: 1
+`)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	_, err := engine.Answer(session, "old enough")
+
+	// Assertions
+	assert.EqualError(t, err, `state 0: right-if: greater: argument 1: expected a number, got "old enough"`)
+}
+
+func TestEngine_PrintOutput(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    before: "print('Hello', {name})"
+    after: "print({empty})"
+    input: name
+    next:
+      right: 1
+  - id: 1
+`)
+	var out bytes.Buffer
+	engine.output = &out
+	session := NewSession()
+	session.Memory["name"] = "Anna"
+
+	_, _ = engine.Start(session)
+	_, err := engine.Answer(session, "Bob")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello Anna\n", out.String())
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"OpenAI-api/api/model"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	writeFlowFunction = "write_flow"
+
+	defaultGenerateAttempts = 5
+
+	// lintWalks and lintMaxTurns bound the simulation run on every draft
+	lintWalks    = 200
+	lintMaxTurns = 50
+	lintSeed     = 1
+)
+
+const generatePrompt = `You write conversation flows for a chat bot engine. A flow is a YAML document with a list of states; the conversation starts at the start state, 0 by default.
+
+The flow must validate against this JSON Schema:
+%s
+
+Conditions (right-if) may call these functions: %s.
+Hooks (before, after) and set assignments may call these functions: %s.
+Placeholders like {name} are replaced with memory values; a state's input stores the user's answer under its key.
+
+Every state must be reachable and lead to a terminal state (a state without next). Call %s with the complete flow.`
+
+// generate asks the model to write a flow from a description, feeding the
+// problems of each draft back to it until a draft lints clean.
+func generate(llm chatClient, description string, attempts int) (string, error) {
+	parameters, err := json.Marshal(map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"yaml": map[string]string{"type": "string", "description": "The complete flow as YAML."},
+		},
+		"required": []string{"yaml"},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	body := chatRequest(nil,
+		model.Message{Role: "system", Content: fmt.Sprintf(generatePrompt, conversationSchema, names(fl), names(ff), writeFlowFunction)},
+		model.Message{Role: "user", Content: description},
+	)
+	body.Functions = []model.Function{{
+		Name:        writeFlowFunction,
+		Description: "Write the conversation flow.",
+		Parameters:  parameters,
+	}}
+	body.FunctionCall = map[string]string{"name": writeFlowFunction}
+
+	var problems []string
+	for attempt := 0; attempt < attempts; attempt++ {
+		resp, err := llm.Chat(body)
+		if err != nil {
+			return "", err
+		}
+
+		call := resp.Choices[0].Message.FunctionCall
+		if call == nil {
+			return "", fmt.Errorf("model did not call %s", writeFlowFunction)
+		}
+
+		var args struct {
+			YAML string `json:"yaml"`
+		}
+		if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil {
+			problems = []string{fmt.Sprintf("invalid %s arguments: %v", writeFlowFunction, err)}
+		} else {
+			problems = lint(args.YAML)
+		}
+
+		if len(problems) == 0 {
+			return strings.TrimSpace(args.YAML) + "\n", nil
+		}
+
+		body.Messages = append(body.Messages,
+			model.Message{Role: "assistant", FunctionCall: call},
+			model.Message{Role: "function", Name: writeFlowFunction, Content: "The flow has problems, fix them and call " + writeFlowFunction + " again:\n- " + strings.Join(problems, "\n- ")},
+		)
+	}
+
+	return "", fmt.Errorf("no clean flow after %d attempts:\n  %s", attempts, strings.Join(problems, "\n  "))
+}
+
+// lint validates the flow and simulates it, returning the problems found.
+func lint(flow string) []string {
+	states, err := parseStates([]byte(flow), ".yml")
+	if err != nil {
+		var invalid validationErrors
+		if errors.As(err, &invalid) {
+			problems := make([]string, 0, len(invalid))
+			for _, v := range invalid {
+				problems = append(problems, v.Error())
+			}
+			return problems
+		}
+		return []string{err.Error()}
+	}
+
+	r := simulate(states, lintWalks, lintMaxTurns, lintSeed)
+
+	var problems []string
+	for _, e := range r.DeadEnds {
+		problems = append(problems, fmt.Sprintf("transition %s leads to a missing state", e))
+	}
+	for _, id := range r.NoTerminal {
+		problems = append(problems, fmt.Sprintf("state %d has no way to a terminal state", id))
+	}
+	for _, id := range r.Unreachable {
+		problems = append(problems, fmt.Sprintf("state %d is unreachable from state %d", id, r.Start))
+	}
+	for _, u := range r.Unknown {
+		problems = append(problems, "unknown function: "+u)
+	}
+	for _, issue := range r.Loops {
+		problems = append(problems, fmt.Sprintf("state %d: %s, e.g. with the answers %q", issue.StateID, issue.Message, issue.Inputs))
+	}
+	for _, issue := range r.Crashes {
+		problems = append(problems, fmt.Sprintf("state %d: %s, e.g. with the answers %q", issue.StateID, issue.Message, issue.Inputs))
+	}
+
+	return problems
+}
+
+// names lists the registered function names, sorted.
+func names[T ~map[string]interface{}](fns T) string {
+	list := make([]string, 0, len(fns))
+	for name := range fns {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+
+	return strings.Join(list, ", ")
+}
+
+func generateCommand(args []string) {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	configPath := flags.String("config", "./config.yaml", "configuration file with the openAI settings")
+	out := flags.String("out", "", "file to write the flow to, standard output by default")
+	attempts := flags.Int("attempts", defaultGenerateAttempts, "drafts to ask for until one lints clean")
+	_ = flags.Parse(args)
+
+	description := strings.Join(flags.Args(), " ")
+	if description == "" || description == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		description = string(data)
+	}
+	if strings.TrimSpace(description) == "" {
+		fmt.Println("usage: conversation generate [-out file] <description of the bot>")
+		os.Exit(2)
+	}
+
+	if err := loadConfig(*configPath); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	client := newOpenAIClient()
+	if client == nil {
+		fmt.Println(errNoLLM)
+		os.Exit(1)
+	}
+
+	flow, err := generate(client, description, *attempts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *out == "" {
+		fmt.Print(flow)
+		return
+	}
+	if err := os.WriteFile(*out, []byte(flow), 0o644); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
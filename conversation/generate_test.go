@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const draftMissingState = `
+states:
+  - id: 0
+    text: "What's your name?"
+    input: name
+    next:
+      right: 5
+`
+
+// drafts are the arguments of writing the flows, one request each.
+func drafts(flows ...string) []string {
+	arguments := make([]string, 0, len(flows))
+	for _, flow := range flows {
+		args, _ := json.Marshal(map[string]string{"yaml": flow})
+		arguments = append(arguments, string(args))
+	}
+
+	return arguments
+}
+
+func TestGenerate(t *testing.T) {
+	stub := &chatStub{calls: map[string][]string{writeFlowFunction: drafts("states: nope", draftMissingState, testFlowYAML)}}
+
+	flow, err := generate(stub, "ask for the user's name and say bye", 3)
+
+	// Assertions
+	assert.NoError(t, err)
+	_, err = parseStates([]byte(flow), ".yml")
+	assert.NoError(t, err)
+	assert.Len(t, stub.requests, 3)
+	assert.Contains(t, stub.requests[0].Messages[0].Content, `"$defs"`)
+	assert.Contains(t, stub.requests[0].Messages[0].Content, "isEmpty")
+	assert.Equal(t, "ask for the user's name and say bye", stub.requests[0].Messages[1].Content)
+
+	// each draft's problems are fed back
+	feedback := stub.requests[2].Messages
+	assert.Len(t, feedback, 6)
+	assert.Equal(t, "function", feedback[3].Role)
+	assert.Contains(t, feedback[3].Content, "states: ")
+	assert.Contains(t, feedback[5].Content, "transition 0 -right-> 5 leads to a missing state")
+}
+
+func TestGenerate_GivesUp(t *testing.T) {
+	stub := &chatStub{calls: map[string][]string{writeFlowFunction: drafts(draftMissingState, draftMissingState)}}
+
+	_, err := generate(stub, "a bot", 2)
+
+	// Assertions
+	assert.ErrorContains(t, err, "no clean flow after 2 attempts")
+	assert.ErrorContains(t, err, "leads to a missing state")
+}
+
+func TestLint(t *testing.T) {
+	// Assertions
+	assert.Empty(t, lint(testFlowYAML))
+	assert.Contains(t, lint(draftMissingState), "state 0 has no way to a terminal state")
+	assert.NotEmpty(t, lint("states: [{id: 0, text: 3}]"))
+}
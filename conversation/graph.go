@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// edge kinds of flow graphs
+const (
+	graphRight = "right"
+	graphLeft  = "left"
+	graphCase  = "case"
+)
+
+// flowGraph is the node and edge graph visual flow editors work with. Nodes
+// are the states with all their settings as data, edges their transitions;
+// the settings of the flow itself are kept aside, so the graph holds
+// everything a flow file does.
+type flowGraph struct {
+	Flow  json.RawMessage `json:"flow,omitempty"`
+	Nodes []graphNode     `json:"nodes"`
+	Edges []graphEdge     `json:"edges"`
+}
+
+type graphNode struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`            // always "state"
+	Label string `json:"label,omitempty"` // text shown in editors
+	// Data are the settings of the state but its id and transitions; the
+	// clarify settings of cases are kept here as "clarify"
+	Data json.RawMessage `json:"data"`
+}
+
+type graphEdge struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Kind   string `json:"kind"`            // right, left or case
+	Label  string `json:"label,omitempty"` // text shown in editors
+
+	Condition string   `json:"condition,omitempty"` // right-if of right edges
+	Intent    string   `json:"intent,omitempty"`    // intent of case edges
+	Examples  []string `json:"examples,omitempty"`
+}
+
+func encodeGraph(states *States) ([]byte, error) {
+	g := flowGraph{Nodes: []graphNode{}, Edges: []graphEdge{}}
+
+	flow := *states
+	flow.States = nil
+	settings, err := settingsOf(&flow, "states")
+	if err != nil {
+		return nil, err
+	}
+	if len(settings) > 0 {
+		if g.Flow, err = json.Marshal(settings); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, state := range states.States {
+		id := strconv.FormatInt(state.ID, 10)
+
+		node := state
+		node.Next = nil
+		data, err := settingsOf(&node, "id")
+		if err != nil {
+			return nil, err
+		}
+
+		label := state.Text
+		if label == "" {
+			label = "state " + id
+		}
+
+		if next := state.Next; next != nil {
+			if next.Clarify != nil {
+				if data["clarify"], err = json.Marshal(next.Clarify); err != nil {
+					return nil, err
+				}
+			}
+
+			g.Edges = append(g.Edges, graphEdge{ID: id + "-" + graphRight, Source: id, Target: strconv.FormatInt(next.RightId, 10), Kind: graphRight, Label: next.RightIf, Condition: next.RightIf})
+			if next.RightIf != "" || next.LeftId != 0 {
+				g.Edges = append(g.Edges, graphEdge{ID: id + "-" + graphLeft, Source: id, Target: strconv.FormatInt(next.LeftId, 10), Kind: graphLeft, Label: "otherwise"})
+			}
+			for i, c := range next.Cases {
+				g.Edges = append(g.Edges, graphEdge{ID: fmt.Sprintf("%s-%s-%d", id, graphCase, i), Source: id, Target: strconv.FormatInt(c.To, 10), Kind: graphCase, Label: c.Intent, Intent: c.Intent, Examples: c.Examples})
+			}
+		}
+
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		g.Nodes = append(g.Nodes, graphNode{ID: id, Type: "state", Label: label, Data: raw})
+	}
+
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+// settingsOf is the JSON object of v without the key.
+func settingsOf(v interface{}, key string) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	delete(settings, key)
+
+	return settings, nil
+}
+
+func decodeGraph(data []byte) (*States, error) {
+	var g flowGraph
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, err
+	}
+
+	states := &States{}
+	if len(g.Flow) > 0 {
+		if err := json.Unmarshal(g.Flow, states); err != nil {
+			return nil, fmt.Errorf("flow: %w", err)
+		}
+	}
+	states.States = make([]State, 0, len(g.Nodes))
+
+	index := make(map[string]int, len(g.Nodes))
+	for i, node := range g.Nodes {
+		id, err := strconv.ParseInt(node.ID, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("nodes[%d]: id %q must be a state number", i, node.ID)
+		}
+		if _, ok := index[node.ID]; ok {
+			return nil, fmt.Errorf("nodes[%d]: id %s is used twice", i, node.ID)
+		}
+
+		var state State
+		var clarify struct {
+			Clarify *Clarify `json:"clarify"`
+		}
+		if len(node.Data) > 0 {
+			if err := json.Unmarshal(node.Data, &state); err != nil {
+				return nil, fmt.Errorf("nodes[%d]: %w", i, err)
+			}
+			if err := json.Unmarshal(node.Data, &clarify); err != nil {
+				return nil, fmt.Errorf("nodes[%d]: %w", i, err)
+			}
+		}
+		state.ID = id
+		if clarify.Clarify != nil {
+			state.Next = &Next{Clarify: clarify.Clarify}
+		}
+
+		index[node.ID] = len(states.States)
+		states.States = append(states.States, state)
+	}
+
+	right := make(map[string]bool)
+	for i, e := range g.Edges {
+		n, ok := index[e.Source]
+		if !ok {
+			return nil, fmt.Errorf("edges[%d]: source %q is not a node", i, e.Source)
+		}
+		to, err := strconv.ParseInt(e.Target, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("edges[%d]: target %q must be a state number", i, e.Target)
+		}
+
+		state := &states.States[n]
+		if state.Next == nil {
+			state.Next = &Next{}
+		}
+		switch e.Kind {
+		case graphRight:
+			if right[e.Source] {
+				return nil, fmt.Errorf("edges[%d]: state %s has more than one right edge", i, e.Source)
+			}
+			state.Next.RightId, state.Next.RightIf, right[e.Source] = to, e.Condition, true
+		case graphLeft:
+			state.Next.LeftId = to
+		case graphCase:
+			state.Next.Cases = append(state.Next.Cases, Case{Intent: e.Intent, Examples: e.Examples, To: to})
+		default:
+			return nil, fmt.Errorf("edges[%d]: kind %q must be right, left or case", i, e.Kind)
+		}
+	}
+
+	for _, state := range states.States {
+		if state.Next != nil && !right[strconv.FormatInt(state.ID, 10)] {
+			return nil, fmt.Errorf("state %d has transitions but no right edge", state.ID)
+		}
+	}
+
+	return states, nil
+}
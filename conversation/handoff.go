@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const defaultQueue = "default"
+
+var errNotHandedOff = errors.New("conversation is not handed off to an operator")
+
+// Handoff configures a state that parks the conversation in an operator queue.
+type Handoff struct {
+	Queue string `yaml:"queue" json:"queue,omitempty" toml:"queue,omitempty"`
+}
+
+func (h *Handoff) queue() string {
+	if h.Queue == "" {
+		return defaultQueue
+	}
+
+	return h.Queue
+}
+
+// handoffStatus tracks a session waiting for, or talking to, an operator.
+type handoffStatus struct {
+	Queue    string    `json:"queue"`
+	Since    time.Time `json:"since"`
+	Operator string    `json:"operator,omitempty"` // empty until an operator claims the session
+}
+
+// Say records an operator's message in a handed off session.
+func (e *Engine) Say(s *Session, text string) error {
+	if s.Handoff == nil {
+		return errNotHandedOff
+	}
+
+	s.record(roleOperator, text)
+
+	return nil
+}
+
+// Resume gives the conversation back to the bot at the given state.
+func (e *Engine) Resume(s *Session, stateID int64) ([]string, error) {
+	if other := e.of(s); other != e {
+		return other.Resume(s, stateID)
+	}
+	if s.Handoff == nil {
+		return nil, errNotHandedOff
+	}
+
+	if e.states.GetState(stateID) == nil {
+		return nil, fmt.Errorf("no state with id %d", stateID)
+	}
+
+	s.Handoff = nil
+	s.StateID = stateID
+
+	texts, err := e.run(s)
+
+	return e.finish(s, texts, err)
+}
+
+// operator API
+
+type waitingSession struct {
+	ID         string            `json:"id"`
+	Flow       string            `json:"flow"`
+	Queue      string            `json:"queue"`
+	Since      time.Time         `json:"since"`
+	Operator   string            `json:"operator,omitempty"`
+	StateID    int64             `json:"state"`
+	Memory     memory            `json:"memory"`
+	Transcript []transcriptEntry `json:"transcript"`
+}
+
+type operatorRequest struct {
+	Operator string `json:"operator"`
+	Text     string `json:"text"`
+	StateID  *int64 `json:"state"`
+}
+
+// viewWaiting shows a handed off session to operators, with secret values masked.
+func (srv *server) viewWaiting(ls *liveSession) waitingSession {
+	return waitingSession{
+		ID:         ls.id,
+		Flow:       ls.flowName(),
+		Queue:      ls.session.Handoff.Queue,
+		Since:      ls.session.Handoff.Since,
+		Operator:   ls.session.Handoff.Operator,
+		StateID:    ls.session.StateID,
+		Memory:     ls.engine().redactMemory(ls.session.Memory),
+		Transcript: ls.session.Transcript,
+	}
+}
+
+// operatorRoutes registers the operator API, which is only served with a
+// token.
+func (srv *server) operatorRoutes(e *echo.Echo, token string) {
+	if token == "" {
+		return
+	}
+
+	g := e.Group("/operator", tokenAuth("operator", token))
+	g.GET("/sessions", srv.handleListWaiting)
+	g.POST("/sessions/:id/claim", srv.handleClaim)
+	g.POST("/sessions/:id/messages", srv.handleOperatorMessage)
+	g.POST("/sessions/:id/return", srv.handleReturn)
+}
+
+// handleListWaiting lists the handed off sessions, oldest first, optionally
+// filtered by ?queue=.
+func (srv *server) handleListWaiting(c echo.Context) error {
+	queue := c.QueryParam("queue")
+
+	waiting := make([]waitingSession, 0)
+	srv.sessions.each(func(ls *liveSession) {
+		if ls.session.Handoff != nil && (queue == "" || ls.session.Handoff.Queue == queue) {
+			waiting = append(waiting, srv.viewWaiting(ls))
+		}
+	})
+
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].Since.Before(waiting[j].Since) })
+
+	return c.JSON(http.StatusOK, waiting)
+}
+
+func (srv *server) handleClaim(c echo.Context) error {
+	var req operatorRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Operator == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "required parameters are not set (required: operator)")
+	}
+
+	return srv.withHandoff(c, "", func(ls *liveSession) error {
+		if current := ls.session.Handoff.Operator; current != "" && current != req.Operator {
+			return echo.NewHTTPError(http.StatusConflict, "session is claimed by "+current)
+		}
+		ls.session.Handoff.Operator = req.Operator
+		ls.session.sync()
+
+		return c.JSON(http.StatusOK, srv.viewWaiting(ls))
+	})
+}
+
+func (srv *server) handleOperatorMessage(c echo.Context) error {
+	var req operatorRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Operator == "" || req.Text == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "required parameters are not set (required: operator, text)")
+	}
+
+	return srv.withHandoff(c, req.Operator, func(ls *liveSession) error {
+		if err := ls.engine().Say(ls.session, req.Text); err != nil {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+func (srv *server) handleReturn(c echo.Context) error {
+	var req operatorRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Operator == "" || req.StateID == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "required parameters are not set (required: operator, state)")
+	}
+
+	return srv.withHandoff(c, req.Operator, func(ls *liveSession) error {
+		texts, err := ls.engine().Resume(ls.session, *req.StateID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		srv.schedule(ls)
+
+		return c.JSON(http.StatusOK, srv.reply(ls, texts))
+	})
+}
+
+// withHandoff runs fn with the locked session of the request, checking it is
+// handed off and, when operator is set, claimed by that operator.
+func (srv *server) withHandoff(c echo.Context, operator string, fn func(ls *liveSession) error) error {
+	ls := srv.sessions.get(c.Param("id"))
+	if ls == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "session not found")
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if ls.session.Handoff == nil {
+		return echo.NewHTTPError(http.StatusConflict, errNotHandedOff.Error())
+	}
+
+	if operator != "" && ls.session.Handoff.Operator != operator {
+		return echo.NewHTTPError(http.StatusConflict, "session is not claimed by "+operator)
+	}
+
+	return fn(ls)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const handoffFlowYAML = `
+states:
+  - id: 0
+    text: "What's wrong?"
+    input: "problem"
+    next:
+      right: 1
+  - id: 1
+    text: "Connecting you to a human."
+    handoff:
+      queue: "support"
+  - id: 2
+    text: "Back to the bot. Anything else?"
+    input: "more"
+    next:
+      right: 3
+  - id: 3
+    text: "Bye!"
+`
+
+func TestEngine_Handoff(t *testing.T) {
+	engine := newTestEngine(t, handoffFlowYAML)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	texts, err := engine.Answer(session, "My order is late")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Connecting you to a human."}, texts)
+	assert.Equal(t, "support", session.Handoff.Queue)
+
+	// user messages go to the operator, the flow doesn't move
+	texts, err = engine.Answer(session, "Hello?")
+	assert.NoError(t, err)
+	assert.Empty(t, texts)
+	assert.Equal(t, int64(1), session.StateID)
+
+	assert.NoError(t, engine.Say(session, "Hi, I'm Bob from support."))
+
+	texts, err = engine.Resume(session, 2)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Back to the bot. Anything else?"}, texts)
+	assert.Nil(t, session.Handoff)
+
+	var roles, lines []string
+	for _, entry := range session.Transcript {
+		roles = append(roles, entry.Role)
+		lines = append(lines, entry.Text)
+	}
+	assert.Equal(t, []string{roleBot, roleUser, roleBot, roleUser, roleOperator, roleBot}, roles)
+	assert.Equal(t, "Hi, I'm Bob from support.", lines[4])
+
+	assert.ErrorIs(t, engine.Say(session, "still there?"), errNotHandedOff)
+	_, err = engine.Resume(session, 2)
+	assert.ErrorIs(t, err, errNotHandedOff)
+}
+
+func TestEngine_ResumeAtMissingState(t *testing.T) {
+	engine := newTestEngine(t, handoffFlowYAML)
+	session := NewSession()
+	_, _ = engine.Start(session)
+	_, _ = engine.Answer(session, "My order is late")
+
+	_, err := engine.Resume(session, 42)
+
+	// Assertions
+	assert.EqualError(t, err, "no state with id 42")
+	assert.NotNil(t, session.Handoff)
+}
+
+const testOperatorToken = "0perat0r"
+
+// doOperator is do with the operator token.
+func doOperator(t *testing.T, e *echo.Echo, method, path, body string, out interface{}) *httptest.ResponseRecorder {
+	return doAuth(t, e, testOperatorToken, method, path, body, out)
+}
+
+func TestOperatorAPI(t *testing.T) {
+	srv, e := newTestServer(t, handoffFlowYAML)
+	srv.operatorRoutes(e, testOperatorToken)
+
+	var started, reply sessionReply
+	do(t, e, http.MethodPost, "/sessions", "", &started)
+	doOperator(t, e, http.MethodPost, "/sessions/"+started.ID+"/messages", `{"text": "My order is late"}`, &reply)
+	assert.True(t, reply.Handoff)
+
+	// a second session that never reaches the handoff
+	do(t, e, http.MethodPost, "/sessions", "", nil)
+
+	var waiting []waitingSession
+	rec := doOperator(t, e, http.MethodGet, "/operator/sessions?queue=support", "", &waiting)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, waiting, 1)
+	assert.Equal(t, started.ID, waiting[0].ID)
+	assert.Equal(t, "My order is late", waiting[0].Memory["problem"])
+	assert.Len(t, waiting[0].Transcript, 3)
+
+	doOperator(t, e, http.MethodGet, "/operator/sessions?queue=billing", "", &waiting)
+	assert.Empty(t, waiting)
+
+	path := "/operator/sessions/" + started.ID
+
+	// messages need a claimed session
+	rec = doOperator(t, e, http.MethodPost, path+"/messages", `{"operator": "bob", "text": "Hi"}`, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	var claimed waitingSession
+	rec = doOperator(t, e, http.MethodPost, path+"/claim", `{"operator": "bob"}`, &claimed)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "bob", claimed.Operator)
+
+	// the claim is part of the session's history
+	ls := srv.sessions.get(started.ID)
+	assert.Equal(t, "bob", Replay(ls.session.Events).Handoff.Operator)
+
+	rec = doOperator(t, e, http.MethodPost, path+"/claim", `{"operator": "alice"}`, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = doOperator(t, e, http.MethodPost, path+"/messages", `{"operator": "bob", "text": "Hi, I'm Bob."}`, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = doOperator(t, e, http.MethodPost, path+"/return", `{"operator": "bob"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doOperator(t, e, http.MethodPost, path+"/return", `{"operator": "bob", "state": 2}`, &reply)
+
+	// Assertions
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"Back to the bot. Anything else?"}, reply.Messages)
+	assert.False(t, reply.Handoff)
+
+	rec = doOperator(t, e, http.MethodPost, path+"/claim", `{"operator": "bob"}`, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = doOperator(t, e, http.MethodPost, "/operator/sessions/missing/claim", `{"operator": "bob"}`, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestOperatorAPI_Auth(t *testing.T) {
+	srv, e := newTestServer(t, handoffFlowYAML)
+
+	rec := do(t, e, http.MethodGet, "/operator/sessions", "", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	srv.operatorRoutes(e, testOperatorToken)
+	rec = do(t, e, http.MethodGet, "/operator/sessions", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doAuth(t, e, testAdminToken, http.MethodGet, "/operator/sessions", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doOperator(t, e, http.MethodGet, "/operator/sessions", "", nil)
+
+	// Assertions
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestSimulate_HandoffFlow(t *testing.T) {
+	states, err := parseStates([]byte(handoffFlowYAML), ".yml")
+	assert.NoError(t, err)
+
+	r := simulate(states, 20, 10, 1)
+
+	// Assertions
+	assert.Empty(t, r.NoTerminal)
+	assert.Equal(t, []int64{2, 3}, r.Unreachable)
+	assert.Equal(t, 20, r.Completed)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	isoDateRe  = regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`)
+	weekdayRe  = regexp.MustCompile(`\b(?:(next|this|on)\s+)?(monday|tuesday|wednesday|thursday|friday|saturday|sunday)\b`)
+	monthDayRe = regexp.MustCompile(`\b(january|february|march|april|may|june|july|august|september|october|november|december)\s+(\d{1,2})(?:st|nd|rd|th)?\b`)
+	dayMonthRe = regexp.MustCompile(`\b(\d{1,2})(?:st|nd|rd|th)?\s+(?:of\s+)?(january|february|march|april|may|june|july|august|september|october|november|december)\b`)
+	inRe       = regexp.MustCompile(`\bin\s+(\d+|an?|one|two|three|four|five|six|seven|eight|nine|ten)\s+(minute|hour|day|week|month)s?\b`)
+	meridiemRe = regexp.MustCompile(`\b(?:at\s+)?(\d{1,2})(?::(\d{2}))?\s*(am|pm)\b`)
+	atRe       = regexp.MustCompile(`\bat\s+(\d{1,2})(?::(\d{2}))?\b`)
+	clockRe    = regexp.MustCompile(`\b(\d{1,2}):(\d{2})\b`)
+	dayPartRe  = regexp.MustCompile(`\b(midnight|noon|midday|morning|afternoon|evening|tonight)\b`)
+
+	numberWords = map[string]int{"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10}
+
+	// dayParts are the hours of the times of day words stand for
+	dayParts = map[string]int{
+		"midnight": 0, "noon": 12, "midday": 12, "morning": 9,
+		"afternoon": 15, "evening": 19, "tonight": 20,
+	}
+)
+
+// capture stores the named groups the state's capture pattern matches in the
+// input. Groups that match nothing are removed from memory.
+func (e *Engine) capture(s *Session, state *State, input string) {
+	re := regexp.MustCompile(state.Capture) // checked when the flow was loaded
+
+	match := re.FindStringSubmatch(input)
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if match == nil || match[i] == "" {
+			delete(s.Memory, name)
+			continue
+		}
+		s.Memory[name] = match[i]
+	}
+}
+
+// date stores the date and time the input mentions as an ISO timestamp in the
+// flow's timezone, or removes the key when it mentions none.
+func (e *Engine) date(s *Session, state *State, input string) {
+	t, ok := parseDate(input, now().In(e.states.location()))
+	if !ok {
+		delete(s.Memory, state.Date)
+		return
+	}
+
+	s.Memory[state.Date] = t.Format(time.RFC3339)
+}
+
+// location is the flow's timezone, UTC by default.
+func (s *States) location() *time.Location {
+	if s.Timezone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC // checked when the flow was loaded
+	}
+
+	return loc
+}
+
+// checkInputs reports the capture patterns and timezone that don't compile.
+func (s *States) checkInputs() validationErrors {
+	var errs validationErrors
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			errs = append(errs, validationError{"timezone", fmt.Sprintf("unknown timezone %q", s.Timezone)})
+		}
+	}
+
+	for i, state := range s.States {
+		if state.Capture == "" {
+			continue
+		}
+		if _, err := regexp.Compile(state.Capture); err != nil {
+			errs = append(errs, validationError{fmt.Sprintf("states[%d].capture", i), err.Error()})
+		}
+	}
+
+	return errs
+}
+
+// parseDate finds a date and time like "tomorrow at 3", "next Friday", "in 2
+// hours" or "May 3rd at 10:30 am" in the text, relative to now. Dates without
+// a time are at midnight; a time without a date is the next one to come.
+// Hours from 1 to 7 without am or pm are taken as afternoon hours.
+func parseDate(text string, now time.Time) (time.Time, bool) {
+	text = strings.ToLower(text)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	if m := inRe.FindStringSubmatch(text); m != nil {
+		n, ok := numberWords[m[1]]
+		if !ok {
+			n, _ = strconv.Atoi(m[1])
+		}
+
+		switch m[2] {
+		case "minute":
+			return now.Add(time.Duration(n) * time.Minute).Truncate(time.Minute), true
+		case "hour":
+			return now.Add(time.Duration(n) * time.Hour).Truncate(time.Minute), true
+		case "day":
+			return atClock(today.AddDate(0, 0, n), text)
+		case "week":
+			return atClock(today.AddDate(0, 0, 7*n), text)
+		case "month":
+			return atClock(today.AddDate(0, n, 0), text)
+		}
+	}
+
+	day, found := dayOf(text, today)
+	if found {
+		return atClock(day, text)
+	}
+
+	hour, minute, ok := clockOf(text)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t := clockAt(today, hour, minute)
+	if t.Before(now) {
+		t = clockAt(today.AddDate(0, 0, 1), hour, minute)
+	}
+
+	return t, true
+}
+
+// dayOf finds the day the text mentions.
+func dayOf(text string, today time.Time) (time.Time, bool) {
+	if m := isoDateRe.FindStringSubmatch(text); m != nil {
+		t, err := time.ParseInLocation(dateLayout, m[0], today.Location())
+		return t, err == nil
+	}
+
+	switch {
+	case strings.Contains(text, "day after tomorrow"):
+		return today.AddDate(0, 0, 2), true
+	case strings.Contains(text, "tomorrow"):
+		return today.AddDate(0, 0, 1), true
+	case strings.Contains(text, "yesterday"):
+		return today.AddDate(0, 0, -1), true
+	case strings.Contains(text, "today") || strings.Contains(text, "tonight"):
+		return today, true
+	case strings.Contains(text, "next week"):
+		return today.AddDate(0, 0, 7), true
+	case strings.Contains(text, "next month"):
+		return today.AddDate(0, 1, 0), true
+	}
+
+	if m := weekdayRe.FindStringSubmatch(text); m != nil {
+		days := (int(weekdayOf(m[2])) - int(today.Weekday()) + 7) % 7
+		if days == 0 && m[1] == "next" {
+			days = 7
+		}
+		return today.AddDate(0, 0, days), true
+	}
+
+	month, dayOfMonth := "", ""
+	if m := monthDayRe.FindStringSubmatch(text); m != nil {
+		month, dayOfMonth = m[1], m[2]
+	} else if m := dayMonthRe.FindStringSubmatch(text); m != nil {
+		month, dayOfMonth = m[2], m[1]
+	}
+	if month != "" {
+		m := monthOf(month)
+		d, _ := strconv.Atoi(dayOfMonth)
+		t := time.Date(today.Year(), m, d, 0, 0, 0, 0, today.Location())
+		if d < 1 || t.Month() != m {
+			return time.Time{}, false // e.g. April 31
+		}
+		if t.Before(today) {
+			t = t.AddDate(1, 0, 0)
+		}
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// atClock sets the time of day the text mentions, if any.
+func atClock(day time.Time, text string) (time.Time, bool) {
+	if hour, minute, ok := clockOf(text); ok {
+		day = clockAt(day, hour, minute)
+	}
+
+	return day, true
+}
+
+// clockAt is the wall clock time on the day. Adding the time to midnight
+// would be an hour off on days switching to or from daylight saving time.
+func clockAt(day time.Time, hour, minute int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+}
+
+// clockOf finds the time of day the text mentions.
+func clockOf(text string) (int, int, bool) {
+	var hour, minute int
+	switch {
+	case meridiemRe.MatchString(text):
+		m := meridiemRe.FindStringSubmatch(text)
+		hour, _ = strconv.Atoi(m[1])
+		minute, _ = strconv.Atoi(m[2])
+		if hour == 12 {
+			hour = 0
+		}
+		if m[3] == "pm" {
+			hour += 12
+		}
+	case atRe.MatchString(text):
+		m := atRe.FindStringSubmatch(text)
+		hour, _ = strconv.Atoi(m[1])
+		minute, _ = strconv.Atoi(m[2])
+		if hour >= 1 && hour <= 7 {
+			hour += 12
+		}
+	case clockRe.MatchString(text):
+		m := clockRe.FindStringSubmatch(text)
+		hour, _ = strconv.Atoi(m[1])
+		minute, _ = strconv.Atoi(m[2])
+	case dayPartRe.MatchString(text):
+		hour = dayParts[dayPartRe.FindStringSubmatch(text)[1]]
+	default:
+		return 0, 0, false
+	}
+
+	if hour > 23 || minute > 59 {
+		return 0, 0, false
+	}
+
+	return hour, minute, true
+}
+
+func monthOf(name string) time.Month {
+	for m := time.January; m <= time.December; m++ {
+		if strings.EqualFold(m.String(), name) {
+			return m
+		}
+	}
+
+	return time.January
+}
+
+func weekdayOf(name string) time.Weekday {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), name) {
+			return d
+		}
+	}
+
+	return time.Sunday
+}
+
+// parseDay reads a date formatted as 2006-01-02, or the day of an ISO
+// timestamp as stored by date inputs.
+func parseDay(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+	}
+
+	return time.Parse(dateLayout, value)
+}
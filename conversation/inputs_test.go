@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDate(t *testing.T) {
+	wednesday := time.Date(2023, 8, 30, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]string{
+		"tomorrow at 3":              "2023-08-31T15:00:00Z",
+		"the day after tomorrow 9am": "2023-09-01T09:00:00Z",
+		"next Friday":                "2023-09-01T00:00:00Z",
+		"next wednesday evening":     "2023-09-06T19:00:00Z",
+		"at 9am":                     "2023-08-31T09:00:00Z",
+		"15:30":                      "2023-08-30T15:30:00Z",
+		"tonight":                    "2023-08-30T20:00:00Z",
+		"tomorrow afternoon":         "2023-08-31T15:00:00Z",
+		"this afternoon":             "2023-08-30T15:00:00Z",
+		"friday afternoon":           "2023-09-01T15:00:00Z",
+		"tomorrow at noon":           "2023-08-31T12:00:00Z",
+		"in 2 hours":                 "2023-08-30T14:00:00Z",
+		"in three days":              "2023-09-02T00:00:00Z",
+		"May 3rd at 10:30 am":        "2024-05-03T10:30:00Z",
+		"on the 3rd of September":    "2023-09-03T00:00:00Z",
+		"2023-09-10 at 18:45":        "2023-09-10T18:45:00Z",
+	}
+
+	for text, want := range tests {
+		got, ok := parseDate(text, wednesday)
+
+		// Assertions
+		assert.True(t, ok, text)
+		assert.Equal(t, want, got.Format(time.RFC3339), text)
+	}
+
+	// days switching to and from daylight saving time
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err)
+	dst := []struct {
+		now  time.Time
+		text string
+		want string
+	}{
+		{time.Date(2026, 3, 28, 12, 0, 0, 0, berlin), "tomorrow at 3", "2026-03-29T15:00:00+02:00"},
+		{time.Date(2026, 3, 28, 12, 0, 0, 0, berlin), "sunday 9am", "2026-03-29T09:00:00+02:00"},
+		{time.Date(2026, 3, 29, 1, 0, 0, 0, berlin), "10:30", "2026-03-29T10:30:00+02:00"},
+		{time.Date(2026, 10, 24, 12, 0, 0, 0, berlin), "tomorrow at 10:30", "2026-10-25T10:30:00+01:00"},
+		{time.Date(2026, 10, 24, 23, 0, 0, 0, berlin), "at 8am", "2026-10-25T08:00:00+01:00"},
+	}
+	for _, tt := range dst {
+		got, ok := parseDate(tt.text, tt.now)
+
+		// Assertions
+		assert.True(t, ok, tt.text)
+		assert.Equal(t, tt.want, got.Format(time.RFC3339), tt.text)
+	}
+
+	_, ok := parseDate("whenever suits you", wednesday)
+	assert.False(t, ok)
+	_, ok = parseDate("April 31", wednesday)
+	assert.False(t, ok)
+}
+
+func TestEngine_CaptureAndDate(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	now = func() time.Time { return time.Date(2023, 8, 30, 12, 0, 0, 0, time.UTC) }
+
+	engine := newTestEngine(t, `
+timezone: Europe/Berlin
+states:
+  - id: 0
+    text: "Where to?"
+    capture: '(?P<city>[A-Za-z]+),? (?P<zip>\d{5})'
+    next:
+      right: 1
+  - id: 1
+    text: "When should we deliver to {city}?"
+    input: when
+    date: delivery
+    next:
+      right: 2
+  - id: 2
+    text: "Delivery to {zip} on {delivery}, {weekday}."
+    set: "weekday = weekday({delivery})"
+`)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	texts, err := engine.Answer(session, "Berlin 10115")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"When should we deliver to Berlin?"}, texts)
+
+	texts, err = engine.Answer(session, "tomorrow at 3")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "tomorrow at 3", session.Memory["when"])
+	assert.Equal(t, []string{"Delivery to 10115 on 2023-08-31T15:00:00+02:00, Thursday."}, texts)
+}
+
+func TestEngine_CaptureNoMatch(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    text: "Your order number?"
+    capture: 'A-(?P<order>\d+)'
+    date: when
+    next:
+      right: 1
+  - id: 1
+    text: "Thanks"
+`)
+	session := NewSession()
+	session.Memory["order"] = "old"
+	_, _ = engine.Start(session)
+
+	_, err := engine.Answer(session, "I don't know")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.NotContains(t, session.Memory, "order")
+	assert.NotContains(t, session.Memory, "when")
+}
+
+func TestParseStates_InvalidInputs(t *testing.T) {
+	_, err := parseStates([]byte(`
+timezone: Mars/Olympus
+states:
+  - id: 0
+    capture: '(?P<x>'
+`), ".yml")
+
+	// Assertions
+	assert.EqualError(t, err, "invalid conversation:\n  timezone: unknown timezone \"Mars/Olympus\"\n  states[0].capture: error parsing regexp: missing closing ): `(?P<x>`")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	edgeCase = "case"
+
+	defaultClarifyThreshold = 0.3
+	defaultClarifyMargin    = 0.1
+	defaultClarifyQuestion  = "Did you mean {_options}?"
+
+	// maxCandidates limits the intents a clarification question lists
+	maxCandidates = 3
+)
+
+var (
+	wordRe    = regexp.MustCompile(`[\p{L}\p{N}]+`)
+	ordinals  = map[string]int{"1": 0, "first": 0, "2": 1, "second": 1, "3": 2, "third": 2}
+	optionsRe = regexp.MustCompile(`\{_options\}`)
+)
+
+// Case routes the user's answer to the state of the intent it matches best.
+type Case struct {
+	Intent   string   `yaml:"intent" json:"intent" toml:"intent"`
+	Examples []string `yaml:"examples" json:"examples,omitempty" toml:"examples,omitempty"`
+	To       int64    `yaml:"to" json:"to" toml:"to"`
+}
+
+// Clarify configures matching the answer against the cases: answers scoring
+// below the threshold go right, and when the best intents score within the
+// margin of each other the bot asks which one the user meant.
+type Clarify struct {
+	Threshold float64 `yaml:"threshold" json:"threshold,omitempty" toml:"threshold,omitempty"` // 0.3 by default
+	Margin    float64 `yaml:"margin" json:"margin,omitempty" toml:"margin,omitempty"`          // 0.1 by default
+	Question  string  `yaml:"question" json:"question,omitempty" toml:"question,omitempty"`    // {_options} lists the candidates
+}
+
+func (c *Clarify) threshold() float64 {
+	if c == nil || c.Threshold == 0 {
+		return defaultClarifyThreshold
+	}
+
+	return c.Threshold
+}
+
+func (c *Clarify) margin() float64 {
+	if c == nil || c.Margin == 0 {
+		return defaultClarifyMargin
+	}
+
+	return c.Margin
+}
+
+func (c *Clarify) question() string {
+	if c == nil || c.Question == "" {
+		return defaultClarifyQuestion
+	}
+
+	return c.Question
+}
+
+// caseScore is how well an answer matches a case, from 0 to 1.
+type caseScore struct {
+	Case  Case
+	Score float64
+}
+
+// route sends the answer to the best matching case and goes right when
+// nothing matches. When the best intents score too close it doesn't move and
+// returns the question asking which one the user meant.
+func (e *Engine) route(s *Session, state *State, input string) (Transition, string, error) {
+	scores, err := e.score(state.Next.Cases, input)
+	if err != nil {
+		return Transition{}, "", fmt.Errorf("state %d: cases: %w", state.ID, err)
+	}
+
+	clarify := state.Next.Clarify
+	if len(scores) == 0 || scores[0].Score < clarify.threshold() {
+		return e.take(s, state, nil), "", nil
+	}
+
+	var candidates []string
+	for _, sc := range scores {
+		if scores[0].Score-sc.Score > clarify.margin() || len(candidates) == maxCandidates {
+			break
+		}
+		candidates = append(candidates, sc.Case.Intent)
+	}
+
+	if len(candidates) > 1 {
+		s.Candidates = candidates
+		question := optionsRe.ReplaceAllLiteralString(clarify.question(), listOptions(candidates))
+		return Transition{}, render(question, s.Memory), nil
+	}
+
+	return e.take(s, state, &scores[0].Case), "", nil
+}
+
+// clarified routes the answer to a clarification question: an ordinal, the
+// name of a candidate, or whatever candidate it matches best.
+func (e *Engine) clarified(s *Session, state *State, input string) (Transition, error) {
+	candidates := s.Candidates
+	s.Candidates = nil
+
+	var cases []Case
+	for _, name := range candidates {
+		for _, c := range state.Next.Cases {
+			if c.Intent == name {
+				cases = append(cases, c)
+			}
+		}
+	}
+
+	answer := strings.ToLower(input)
+	for _, word := range wordRe.FindAllString(answer, -1) {
+		if i, ok := ordinals[word]; ok && i < len(cases) {
+			return e.take(s, state, &cases[i]), nil
+		}
+	}
+
+	for i, c := range cases {
+		if strings.Contains(answer, strings.ToLower(c.Intent)) {
+			return e.take(s, state, &cases[i]), nil
+		}
+	}
+
+	scores, err := e.score(cases, input)
+	if err != nil {
+		return Transition{}, fmt.Errorf("state %d: cases: %w", state.ID, err)
+	}
+	if len(scores) == 0 || scores[0].Score < state.Next.Clarify.threshold() {
+		return e.take(s, state, nil), nil
+	}
+
+	return e.take(s, state, &scores[0].Case), nil
+}
+
+// take follows the transition of the case, or goes right without one.
+func (e *Engine) take(s *Session, state *State, c *Case) Transition {
+	t := Transition{From: state.ID, To: state.Next.RightId, Edge: edgeRight}
+	if c != nil {
+		t = Transition{From: state.ID, To: c.To, Edge: edgeCase, Condition: c.Intent, Result: true}
+	}
+
+	e.follow(s, t)
+
+	return t
+}
+
+// score rates the input against every case, best first: by the cosine
+// similarity of embeddings when an embedder is configured, by shared words
+// otherwise. A case scores as its closest example, or its intent name.
+func (e *Engine) score(cases []Case, input string) ([]caseScore, error) {
+	var texts []string
+	for _, c := range cases {
+		texts = append(texts, c.examples()...)
+	}
+
+	similarity := wordSimilarity
+	if e.embedder != nil {
+		embeddings, err := e.embedder.Embed(append([]string{input}, texts...))
+		if err != nil {
+			return nil, err
+		}
+
+		byText := make(map[string][]float64, len(texts))
+		for i, text := range texts {
+			byText[text] = embeddings[i+1]
+		}
+		similarity = func(_, text string) float64 {
+			return cosine(embeddings[0], byText[text])
+		}
+	}
+
+	scores := make([]caseScore, 0, len(cases))
+	for _, c := range cases {
+		sc := caseScore{Case: c}
+		for _, example := range c.examples() {
+			sc.Score = math.Max(sc.Score, similarity(input, example))
+		}
+		scores = append(scores, sc)
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool { return scores[i].Score > scores[j].Score })
+
+	return scores, nil
+}
+
+func (c Case) examples() []string {
+	if len(c.Examples) == 0 {
+		return []string{c.Intent}
+	}
+
+	return c.Examples
+}
+
+// wordSimilarity is the cosine similarity of the sets of words of a and b.
+func wordSimilarity(a, b string) float64 {
+	wordsA, wordsB := words(a), words(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for w := range wordsA {
+		if wordsB[w] {
+			shared++
+		}
+	}
+
+	return float64(shared) / math.Sqrt(float64(len(wordsA)*len(wordsB)))
+}
+
+func words(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range wordRe.FindAllString(strings.ToLower(s), -1) {
+		set[w] = true
+	}
+
+	return set
+}
+
+// listOptions joins the options like "A, B or C".
+func listOptions(options []string) string {
+	if len(options) == 1 {
+		return options[0]
+	}
+
+	return strings.Join(options[:len(options)-1], ", ") + " or " + options[len(options)-1]
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const intentsFlowYAML = `
+states:
+  - id: 0
+    text: "How can I help?"
+    next:
+      right: 9
+      cases:
+        - intent: track order
+          examples: ["where is my order", "track my package"]
+          to: 1
+        - intent: cancel order
+          examples: ["cancel my order", "I don't want my order anymore"]
+          to: 2
+        - intent: talk to a human
+          to: 3
+      clarify:
+        threshold: 0.4
+        margin: 0.15
+        question: "Sorry, {_options}?"
+  - id: 1
+    text: "Tracking"
+  - id: 2
+    text: "Cancelling"
+  - id: 3
+    text: "Calling a human"
+  - id: 9
+    text: "I didn't get that"
+`
+
+func TestEngine_Cases(t *testing.T) {
+	for input, want := range map[string]string{
+		"where is my order?":       "Tracking",
+		"please cancel my order":   "Cancelling",
+		"can I talk to a human":    "Calling a human",
+		"what's the weather like?": "I didn't get that",
+	} {
+		t.Run(input, func(t *testing.T) {
+			engine := newTestEngine(t, intentsFlowYAML)
+			session := NewSession()
+			_, _ = engine.Start(session)
+
+			texts, err := engine.Answer(session, input)
+
+			// Assertions
+			assert.NoError(t, err)
+			assert.Equal(t, []string{want}, texts)
+		})
+	}
+}
+
+func TestEngine_Clarification(t *testing.T) {
+	for answer, want := range map[string]string{
+		"the second one":      "Tracking",
+		"1":                   "Cancelling",
+		"cancel order please": "Cancelling",
+		"nope":                "I didn't get that",
+	} {
+		t.Run(answer, func(t *testing.T) {
+			engine := newTestEngine(t, intentsFlowYAML)
+			recorder := &TraceRecorder{}
+			engine.Observe(recorder)
+			session := NewSession()
+			_, _ = engine.Start(session)
+
+			texts, err := engine.Answer(session, "my order")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"Sorry, cancel order or track order?"}, texts)
+			assert.Equal(t, []string{"cancel order", "track order"}, session.Candidates)
+			assert.Equal(t, session.Candidates, engine.Replies(session))
+			assert.Equal(t, int64(0), session.StateID)
+
+			texts, err = engine.Answer(session, answer)
+
+			// Assertions
+			assert.NoError(t, err)
+			assert.Equal(t, []string{want}, texts)
+			assert.Nil(t, session.Candidates)
+		})
+	}
+}
+
+func TestEngine_CasesTrace(t *testing.T) {
+	engine := newTestEngine(t, intentsFlowYAML)
+	recorder := &TraceRecorder{}
+	engine.Observe(recorder)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	_, _ = engine.Answer(session, "track my package")
+
+	// Assertions
+	assert.Contains(t, recorder.Events, TraceEvent{Type: eventTransition, StateID: 0, To: 1, Edge: edgeCase, Condition: "track order", Result: true})
+}
+
+func TestEngine_CasesWithEmbeddings(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    text: "How can I help?"
+    next:
+      right: 0
+      cases:
+        - intent: refund
+          examples: ["I want a refund"]
+          to: 1
+        - intent: shipping
+          examples: ["shipping costs", "free shipping?"]
+          to: 2
+  - id: 1
+    text: "Refunding"
+  - id: 2
+    text: "Shipping"
+`)
+	embedder := &keywordEmbedder{}
+	engine.embedder = embedder
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	texts, err := engine.Answer(session, "how much is shipping")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Shipping"}, texts)
+	assert.Equal(t, []string{"how much is shipping", "I want a refund", "shipping costs", "free shipping?"}, embedder.inputs)
+}
+
+func TestWordSimilarity(t *testing.T) {
+	// Assertions
+	assert.Equal(t, 1.0, wordSimilarity("Cancel my order", "cancel MY order!"))
+	assert.Equal(t, 0.0, wordSimilarity("hello", "cancel my order"))
+	assert.InDelta(t, 0.816, wordSimilarity("my order", "cancel my order"), 0.01)
+}
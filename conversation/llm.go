@@ -0,0 +1,134 @@
+package main
+
+import (
+	"OpenAI-api/api/model"
+	"OpenAI-api/api/request"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultOpenAIURL = "https://api.openai.com/v1"
+	defaultChatModel = "gpt-3.5-turbo"
+
+	// defaultLLMTimeout bounds requests to the API unless openAI.timeout says
+	// otherwise, so a stuck upstream can't hold a session forever
+	defaultLLMTimeout = 60 * time.Second
+)
+
+var errNoLLM = errors.New("no LLM configured (set openAI.apiKey in config.yaml)")
+
+// chatClient sends chat completion requests to an OpenAI compatible API.
+type chatClient interface {
+	Chat(body *model.ChatRequestBody) (*model.ChatResponse, error)
+}
+
+type openAIClient struct {
+	url    string
+	apiKey string
+	client request.HttpClient
+}
+
+// newOpenAIClient configures a client from the openAI section of the config.
+// It returns nil when neither an API key nor a custom URL is set.
+func newOpenAIClient() *openAIClient {
+	url, apiKey := viper.GetString("openAI.url"), viper.GetString("openAI.apiKey")
+	if url == "" {
+		if apiKey == "" {
+			return nil
+		}
+		url = defaultOpenAIURL
+	}
+
+	timeout := viper.GetDuration("openAI.timeout")
+	if timeout <= 0 {
+		timeout = defaultLLMTimeout
+	}
+
+	return &openAIClient{
+		url:    strings.TrimSuffix(url, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// setupLLM gives the engine the configured OpenAI client, if any.
+func setupLLM(engine *Engine) {
+	if client := newOpenAIClient(); client != nil {
+		engine.llm, engine.embedder, engine.moderator = client, client, client
+	}
+}
+
+// loadConfig reads the configuration file; a missing file leaves the defaults.
+func loadConfig(path string) error {
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	return nil
+}
+
+func (c *openAIClient) Chat(body *model.ChatRequestBody) (*model.ChatResponse, error) {
+	var resp model.ChatResponse
+	if err := post(c, "/chat/completions", body, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("chat completion returned no choices")
+	}
+
+	return &resp, nil
+}
+
+func post[T model.RequestBody](c *openAIClient, path string, body *T, resp interface{}) error {
+	req, err := request.MakeRequest(body, c.url+path, c.apiKey)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	data, err := request.SendRequest(c.client, req)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, resp)
+}
+
+// LLM holds per-state model settings.
+type LLM struct {
+	Model       string  `yaml:"model" json:"model,omitempty" toml:"model,omitempty"`
+	Temperature float64 `yaml:"temperature" json:"temperature,omitempty" toml:"temperature,omitempty"`
+
+	// AllowSecrets sends secret values to the model instead of masking them
+	AllowSecrets bool `yaml:"allow-secrets" json:"allow-secrets,omitempty" toml:"allow-secrets,omitempty"`
+}
+
+// chatRequest creates a request body with the state's model settings.
+func chatRequest(settings *LLM, messages ...model.Message) *model.ChatRequestBody {
+	body := &model.ChatRequestBody{
+		Model:    viper.GetString("openAI.model"),
+		Messages: messages,
+	}
+	if body.Model == "" {
+		body.Model = defaultChatModel
+	}
+
+	if settings != nil {
+		if settings.Model != "" {
+			body.Model = settings.Model
+		}
+		body.Temperature = settings.Temperature
+	}
+
+	return body
+}
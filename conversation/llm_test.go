@@ -0,0 +1,41 @@
+package main
+
+import (
+	"OpenAI-api/api/model"
+	"fmt"
+)
+
+// chatStub is a scripted chat model. Requests offering functions are answered
+// with a call of the first one, taking the next of its arguments and repeating
+// the last; others with the reply set for their system prompt, or the reply.
+type chatStub struct {
+	calls    map[string][]string // arguments by function name
+	replies  map[string]string   // replies by system prompt
+	reply    string
+	requests []*model.ChatRequestBody
+}
+
+func (c *chatStub) Chat(body *model.ChatRequestBody) (*model.ChatResponse, error) {
+	c.requests = append(c.requests, body)
+
+	if len(body.Functions) > 0 {
+		name := body.Functions[0].Name
+		arguments := c.calls[name]
+		if len(arguments) == 0 {
+			return nil, fmt.Errorf("no arguments for %s", name)
+		}
+		if len(arguments) > 1 {
+			c.calls[name] = arguments[1:]
+		}
+
+		call := &model.FunctionCall{Name: name, Arguments: arguments[0]}
+		return &model.ChatResponse{Choices: []model.Choice{{Message: model.Message{Role: "assistant", FunctionCall: call}}}}, nil
+	}
+
+	reply := c.reply
+	if r, ok := c.replies[body.Messages[0].Content]; ok {
+		reply = r
+	}
+
+	return &model.ChatResponse{Choices: []model.Choice{{Message: model.Message{Role: "assistant", Content: reply}}}}, nil
+}
@@ -1 +1,59 @@
 package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "simulate":
+			simulateCommand(os.Args[2:])
+			return
+		case "index":
+			indexCommand(os.Args[2:])
+			return
+		case "serve":
+			serveCommand(os.Args[2:])
+			return
+		case "generate":
+			generateCommand(os.Args[2:])
+			return
+		case "telegram":
+			telegramCommand(os.Args[2:])
+			return
+		case "test":
+			testCommand(os.Args[2:])
+			return
+		case "convert":
+			convertCommand(os.Args[2:])
+			return
+		}
+	}
+
+	flags := flag.NewFlagSet("conversation", flag.ExitOnError)
+	flowPath := flags.String("flow", "./conversation.yml", "conversation flow file (.yml, .yaml, .json or .toml), or the name of a flow in -flows")
+	flowsDir := flags.String("flows", "", "directory to look up flow names in, flows.dir of the config by default")
+	configPath := flags.String("config", "./config.yaml", "configuration file with the openAI settings")
+	trace := flags.Bool("trace", false, "start with tracing of transitions, hooks and inputs on")
+	_ = flags.Parse(os.Args[1:])
+
+	if err := loadConfig(*configPath); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// read the conversation file and parse it to States struct
+	engine, path, err := loadEngine(*flowPath, flowDirectory(*flowsDir), setupLLM)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	r := newREPL(engine, path, os.Stdin, os.Stdout)
+	r.tracing = *trace
+	fmt.Println("type :help for debugging commands")
+	r.run()
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Mermaid state diagram shows a flow with the text of each state as its
+// description, the input and hooks as notes and the transitions as labeled
+// arrows:
+//
+//	stateDiagram-v2
+//	    [*] --> s0
+//	    note right of s1 : before print({header})
+//	    s1 : What is your name?
+//	    note right of s1 : input {name}
+//	    note right of s1 : after greet({name})
+//	    s1 --> s2 : isEmpty({name})
+//	    s1 --> s1 : otherwise
+//	    s2 --> [*]
+//
+// States are ordered as they first appear other than as a target.
+const (
+	mermaidHeader    = "stateDiagram-v2"
+	mermaidArrow     = " --> "
+	mermaidEnd       = "[*]"
+	mermaidNote      = "note right of "
+	mermaidInput     = "input "
+	mermaidOtherwise = "otherwise"
+	mermaidIndent    = "    "
+)
+
+func mermaidID(id int64) string {
+	return "s" + strconv.FormatInt(id, 10)
+}
+
+func parseMermaidID(name string) (int64, error) {
+	if !strings.HasPrefix(name, "s") {
+		return 0, fmt.Errorf("state %q must be named s<id>", name)
+	}
+	id, err := strconv.ParseInt(name[1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("state %q must be named s<id>", name)
+	}
+
+	return id, nil
+}
+
+func encodeMermaid(states *States) ([]byte, error) {
+	if err := checkSimple(states, formatMermaid); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(mermaidHeader + "\n")
+	fmt.Fprintf(&out, "%s%s%s%s\n", mermaidIndent, mermaidEnd, mermaidArrow, mermaidID(states.Start))
+
+	for _, state := range states.States {
+		id := mermaidID(state.ID)
+		before, after := hookNotes(state)
+		for _, note := range before {
+			fmt.Fprintf(&out, "%s%s%s : %s\n", mermaidIndent, mermaidNote, id, note)
+		}
+		if state.Text != "" {
+			for _, line := range strings.Split(state.Text, "\n") {
+				if strings.Contains(line, mermaidArrow) {
+					return nil, fmt.Errorf("state %d: %s can't hold text with %q", state.ID, formatMermaid, strings.TrimSpace(mermaidArrow))
+				}
+				if line == "" {
+					fmt.Fprintf(&out, "%s%s :\n", mermaidIndent, id)
+					continue
+				}
+				fmt.Fprintf(&out, "%s%s : %s\n", mermaidIndent, id, line)
+			}
+		}
+		if state.Input != "" {
+			fmt.Fprintf(&out, "%s%s%s : %s{%s}\n", mermaidIndent, mermaidNote, id, mermaidInput, state.Input)
+		}
+		for _, note := range after {
+			fmt.Fprintf(&out, "%s%s%s : %s\n", mermaidIndent, mermaidNote, id, note)
+		}
+
+		next := state.Next
+		if next == nil {
+			fmt.Fprintf(&out, "%s%s%s%s\n", mermaidIndent, id, mermaidArrow, mermaidEnd)
+			continue
+		}
+		if next.RightIf != "" {
+			fmt.Fprintf(&out, "%s%s%s%s : %s\n", mermaidIndent, id, mermaidArrow, mermaidID(next.RightId), next.RightIf)
+		} else {
+			fmt.Fprintf(&out, "%s%s%s%s\n", mermaidIndent, id, mermaidArrow, mermaidID(next.RightId))
+		}
+		if next.RightIf != "" || next.LeftId != 0 {
+			fmt.Fprintf(&out, "%s%s%s%s : %s\n", mermaidIndent, id, mermaidArrow, mermaidID(next.LeftId), mermaidOtherwise)
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+func decodeMermaid(data []byte) (*States, error) {
+	var order []int64
+	byID := make(map[int64]*State)
+	texts := make(map[int64][]string)
+	right := make(map[int64]bool)
+	left := make(map[int64]bool)
+
+	// state returns the state, adding it the first time it shows up
+	state := func(name string) (*State, error) {
+		id, err := parseMermaidID(name)
+		if err != nil {
+			return nil, err
+		}
+		if byID[id] == nil {
+			byID[id] = &State{ID: id}
+			order = append(order, id)
+		}
+		return byID[id], nil
+	}
+
+	states := &States{States: []State{}}
+	header, start := false, false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		raw := strings.TrimSuffix(scanner.Text(), "\r")
+		line := strings.TrimSpace(raw)
+		err := func() error {
+			switch {
+			case line == "", strings.HasPrefix(line, "%%"):
+				return nil
+			case !header:
+				if line != mermaidHeader {
+					return fmt.Errorf("diagrams must start with %s", mermaidHeader)
+				}
+				header = true
+				return nil
+			case strings.HasPrefix(line, mermaidNote):
+				name, note, ok := strings.Cut(strings.TrimPrefix(line, mermaidNote), " : ")
+				note = strings.TrimSpace(note)
+				if !ok {
+					return fmt.Errorf("notes must name the input or a hook, like %q", mermaidNote+"s1 : "+mermaidInput+"{name}")
+				}
+				s, err := state(strings.TrimSpace(name))
+				if err != nil {
+					return err
+				}
+				if !strings.HasPrefix(note, mermaidInput) {
+					return addHookNote(s, note)
+				}
+				if !strings.HasPrefix(note, mermaidInput+"{") || !strings.HasSuffix(note, "}") || len(note) < len(mermaidInput)+3 {
+					return fmt.Errorf("notes must name the input, like %q", mermaidNote+"s1 : "+mermaidInput+"{name}")
+				}
+				s.Input = note[len(mermaidInput)+1 : len(note)-1]
+				return nil
+			case strings.Contains(line, mermaidArrow):
+				from, rest, _ := strings.Cut(line, mermaidArrow)
+				to, label, _ := strings.Cut(rest, " : ")
+				from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+
+				if from == mermaidEnd {
+					id, err := parseMermaidID(to)
+					if err != nil {
+						return err
+					}
+					if start {
+						return fmt.Errorf("flows have a single start")
+					}
+					states.Start, start = id, true
+					return nil
+				}
+
+				s, err := state(from)
+				if err != nil || to == mermaidEnd {
+					return err
+				}
+				target, err := parseMermaidID(to)
+				if err != nil {
+					return err
+				}
+				if s.Next == nil {
+					s.Next = &Next{}
+				}
+
+				label = strings.TrimSpace(label)
+				switch {
+				case label == mermaidOtherwise:
+					if left[s.ID] {
+						return fmt.Errorf("state %d has more than one otherwise", s.ID)
+					}
+					s.Next.LeftId, left[s.ID] = target, true
+				case right[s.ID]:
+					return fmt.Errorf("state %d has more than one transition", s.ID)
+				default:
+					s.Next.RightId, s.Next.RightIf, right[s.ID] = target, label, true
+				}
+				return nil
+			}
+
+			// descriptions keep their spacing, "s1 : text" or "s1 :" for an
+			// empty line
+			name, text, ok := strings.Cut(strings.TrimLeft(raw, " \t"), " :")
+			if !ok || strings.ContainsAny(name, " \t") {
+				return fmt.Errorf("%q is not a description, note or transition", line)
+			}
+			s, err := state(name)
+			if err != nil {
+				return err
+			}
+			texts[s.ID] = append(texts[s.ID], strings.TrimPrefix(text, " "))
+			return nil
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range order {
+		s := byID[id]
+		if left[id] && !right[id] {
+			return nil, fmt.Errorf("state %d: otherwise without another transition", id)
+		}
+		if lines, ok := texts[id]; ok {
+			s.Text = strings.Join(lines, "\n")
+		}
+		states.States = append(states.States, *s)
+	}
+
+	return states, nil
+}
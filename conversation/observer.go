@@ -0,0 +1,119 @@
+package main
+
+// Observer is notified of what the engine does with a session. Analytics,
+// logging, transcripts and metrics plug in here instead of into the loop.
+// Embed BaseObserver to implement only some of the callbacks.
+type Observer interface {
+	StateEntered(s *Session, stateID int64)
+	StateExited(s *Session, stateID int64)
+	InputReceived(s *Session, stateID int64, input string)
+	TransitionChosen(s *Session, t Transition)
+	HookExecuted(s *Session, h HookCall)
+	Error(s *Session, err error)
+}
+
+// Transition is a transition taken from a state.
+type Transition struct {
+	From      int64
+	To        int64
+	Edge      string // right or left
+	Condition string // right-if of the state, empty for unconditional transitions
+	Result    bool   // whether the condition held, true for unconditional transitions
+}
+
+// HookCall is a before or after hook or a set assignment executed in a state.
+type HookCall struct {
+	StateID int64
+	Stage   string // before, set or after
+	Hook    string
+	Arg     string
+	Err     error
+	// Skipped hooks call functions the engine doesn't know, which are left
+	// out rather than failing the flow
+	Skipped bool
+}
+
+// BaseObserver implements Observer with callbacks that do nothing.
+type BaseObserver struct{}
+
+func (BaseObserver) StateEntered(*Session, int64)          {}
+func (BaseObserver) StateExited(*Session, int64)           {}
+func (BaseObserver) InputReceived(*Session, int64, string) {}
+func (BaseObserver) TransitionChosen(*Session, Transition) {}
+func (BaseObserver) HookExecuted(*Session, HookCall)       {}
+func (BaseObserver) Error(*Session, error)                 {}
+
+const (
+	eventStateEntered = "state_entered"
+	eventStateExited  = "state_exited"
+	eventInput        = "input"
+	eventTransition   = "transition"
+	eventHook         = "hook"
+	eventError        = "error"
+)
+
+// TraceEvent is a structured record of an observer callback.
+type TraceEvent struct {
+	Type      string `json:"type"`
+	StateID   int64  `json:"state"`
+	To        int64  `json:"to,omitempty"`
+	Edge      string `json:"edge,omitempty"`
+	Condition string `json:"condition,omitempty"`
+	Result    bool   `json:"result,omitempty"`
+	Stage     string `json:"stage,omitempty"`
+	Hook      string `json:"hook,omitempty"`
+	Value     string `json:"value,omitempty"` // user input or hook argument
+	Err       string `json:"error,omitempty"`
+	Skipped   bool   `json:"skipped,omitempty"`
+}
+
+// TraceRecorder is an Observer collecting every callback as a TraceEvent, so
+// tests can assert on what the engine did.
+type TraceRecorder struct {
+	Events []TraceEvent
+}
+
+func (r *TraceRecorder) StateEntered(s *Session, stateID int64) {
+	r.Events = append(r.Events, TraceEvent{Type: eventStateEntered, StateID: stateID})
+}
+
+func (r *TraceRecorder) StateExited(s *Session, stateID int64) {
+	r.Events = append(r.Events, TraceEvent{Type: eventStateExited, StateID: stateID})
+}
+
+func (r *TraceRecorder) InputReceived(s *Session, stateID int64, input string) {
+	r.Events = append(r.Events, TraceEvent{Type: eventInput, StateID: stateID, Value: input})
+}
+
+func (r *TraceRecorder) TransitionChosen(s *Session, t Transition) {
+	r.Events = append(r.Events, TraceEvent{
+		Type:      eventTransition,
+		StateID:   t.From,
+		To:        t.To,
+		Edge:      t.Edge,
+		Condition: t.Condition,
+		Result:    t.Result,
+	})
+}
+
+func (r *TraceRecorder) HookExecuted(s *Session, h HookCall) {
+	event := TraceEvent{Type: eventHook, StateID: h.StateID, Stage: h.Stage, Hook: h.Hook, Value: h.Arg, Skipped: h.Skipped}
+	if h.Err != nil {
+		event.Err = h.Err.Error()
+	}
+	r.Events = append(r.Events, event)
+}
+
+func (r *TraceRecorder) Error(s *Session, err error) {
+	r.Events = append(r.Events, TraceEvent{Type: eventError, StateID: s.StateID, Err: err.Error()})
+}
+
+// Types returns the types of the recorded events, handy for assertions.
+func (r *TraceRecorder) Types() []string {
+	types := make([]string, 0, len(r.Events))
+	for _, e := range r.Events {
+		types = append(types, e.Type)
+	}
+
+	return types
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"OpenAI-api/api/model"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultEmbeddingModel = "text-embedding-ada-002"
+	defaultTopChunks      = 4
+	defaultChunkSize      = 1000
+	defaultIndexFile      = ".index.json"
+
+	defaultAnswerPrompt = "You are a support assistant. Answer the user's question using only the numbered context below " +
+		"and cite the context you used as [n]. If the context doesn't contain the answer, say that you don't know."
+)
+
+var citationRe = regexp.MustCompile(`\[(\d+)]`)
+
+// Answer configures a state that answers the user's question from a local
+// directory of markdown and text documents.
+type Answer struct {
+	Docs   string `yaml:"docs" json:"docs" toml:"docs"`
+	Index  string `yaml:"index" json:"index,omitempty" toml:"index,omitempty"` // <docs>/.index.json by default
+	Top    int    `yaml:"top" json:"top,omitempty" toml:"top,omitempty"`       // chunks given to the model, 4 by default
+	Prompt string `yaml:"prompt" json:"prompt,omitempty" toml:"prompt,omitempty"`
+}
+
+// resolveDocs makes the relative docs and index paths of the flow relative to
+// dir, the directory of its file, so every command uses the same documents
+// wherever it runs.
+func resolveDocs(states *States, dir string) {
+	for _, state := range states.States {
+		a := state.Answer
+		if a == nil {
+			continue
+		}
+		if a.Docs != "" && !filepath.IsAbs(a.Docs) {
+			a.Docs = filepath.Join(dir, a.Docs)
+		}
+		if a.Index != "" && !filepath.IsAbs(a.Index) {
+			a.Index = filepath.Join(dir, a.Index)
+		}
+	}
+}
+
+func (a *Answer) indexPath() string {
+	if a.Index != "" {
+		return a.Index
+	}
+
+	return filepath.Join(a.Docs, defaultIndexFile)
+}
+
+// embedder creates embeddings through an OpenAI compatible API.
+type embedder interface {
+	Embed(inputs []string) ([][]float64, error)
+}
+
+func (c *openAIClient) Embed(inputs []string) ([][]float64, error) {
+	body := &model.EmbeddingsRequestBody{
+		Model: embeddingModel(),
+		Input: inputs,
+	}
+
+	var resp model.EmbeddingsResponse
+	if err := post(c, "/embeddings", body, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Data) != len(inputs) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(inputs), len(resp.Data))
+	}
+
+	embeddings := make([][]float64, len(inputs))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(inputs) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		embeddings[d.Index] = d.Embedding
+	}
+
+	return embeddings, nil
+}
+
+func embeddingModel() string {
+	if m := viper.GetString("openAI.embeddingModel"); m != "" {
+		return m
+	}
+
+	return defaultEmbeddingModel
+}
+
+// docIndex is the on-disk vector index of a document directory.
+type docIndex struct {
+	Model string                  `json:"model"`
+	Files map[string]*indexedFile `json:"files"`
+}
+
+type indexedFile struct {
+	Hash   string  `json:"hash"`
+	Chunks []chunk `json:"chunks"`
+}
+
+type chunk struct {
+	Text      string    `json:"text"`
+	Embedding []float64 `json:"embedding"`
+}
+
+type retrievedChunk struct {
+	Source string
+	Text   string
+	Score  float64
+}
+
+type indexStats struct {
+	Files     int
+	Reindexed int
+	Removed   int
+}
+
+func loadIndex(path string) (*docIndex, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &docIndex{Files: make(map[string]*indexedFile)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var index docIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index %s: %w", path, err)
+	}
+	if index.Files == nil {
+		index.Files = make(map[string]*indexedFile)
+	}
+
+	return &index, nil
+}
+
+func (idx *docIndex) save(path string) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// update embeds the documents of dir that are new or changed since the index
+// was built and drops the ones that were removed.
+func (idx *docIndex) update(dir string, emb embedder) (indexStats, error) {
+	var stats indexStats
+
+	name := embeddingModel()
+	if idx.Model != name {
+		// embeddings of different models can't be compared
+		idx.Model, idx.Files = name, make(map[string]*indexedFile)
+	}
+
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isDocument(path) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		seen[rel] = true
+		stats.Files++
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		if f, ok := idx.Files[rel]; ok && f.Hash == hash {
+			return nil
+		}
+
+		texts := chunkText(string(data), defaultChunkSize)
+		file := &indexedFile{Hash: hash}
+		if len(texts) > 0 {
+			embeddings, err := emb.Embed(texts)
+			if err != nil {
+				return fmt.Errorf("failed to embed %s: %w", rel, err)
+			}
+			for i, text := range texts {
+				file.Chunks = append(file.Chunks, chunk{Text: text, Embedding: embeddings[i]})
+			}
+		}
+
+		idx.Files[rel] = file
+		stats.Reindexed++
+
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	for rel := range idx.Files {
+		if !seen[rel] {
+			delete(idx.Files, rel)
+			stats.Removed++
+		}
+	}
+
+	return stats, nil
+}
+
+// search returns the top chunks most similar to the embedding.
+func (idx *docIndex) search(embedding []float64, top int) []retrievedChunk {
+	var found []retrievedChunk
+	for source, f := range idx.Files {
+		for _, c := range f.Chunks {
+			found = append(found, retrievedChunk{Source: source, Text: c.Text, Score: cosine(embedding, c.Embedding)})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Score != found[j].Score {
+			return found[i].Score > found[j].Score
+		}
+		return found[i].Source < found[j].Source
+	})
+
+	if len(found) > top {
+		found = found[:top]
+	}
+
+	return found
+}
+
+func isDocument(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown", ".txt":
+		return true
+	}
+
+	return false
+}
+
+// chunkText splits a document into chunks of about size characters, keeping
+// paragraphs together where possible.
+func chunkText(text string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+
+	add := func(piece, sep string) {
+		if current.Len() > 0 && current.Len()+len(sep)+len(piece) > size {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteString(sep)
+		}
+		current.WriteString(piece)
+	}
+
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+
+		if len(paragraph) <= size {
+			add(paragraph, "\n\n")
+			continue
+		}
+
+		// paragraphs longer than a chunk are split between words
+		flush()
+		for _, word := range strings.Fields(paragraph) {
+			add(word, " ")
+		}
+		flush()
+	}
+	flush()
+
+	return chunks
+}
+
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// refreshIndex loads the index of the documents and brings it up to date,
+// saving it when anything changed.
+func refreshIndex(a *Answer, emb embedder, save bool) (*docIndex, indexStats, error) {
+	path := a.indexPath()
+
+	idx, err := loadIndex(path)
+	if err != nil {
+		return nil, indexStats{}, err
+	}
+
+	stats, err := idx.update(a.Docs, emb)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	if save && (stats.Reindexed > 0 || stats.Removed > 0) {
+		if err := idx.save(path); err != nil {
+			return nil, stats, err
+		}
+	}
+
+	return idx, stats, nil
+}
+
+// corpus returns the up-to-date index of the state's documents. Indexes are
+// refreshed once per engine.
+func (e *Engine) corpus(a *Answer) (*docIndex, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if idx, ok := e.indexes[a.indexPath()]; ok {
+		return idx, nil
+	}
+
+	idx, _, err := refreshIndex(a, e.embedder, !e.dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.indexes == nil {
+		e.indexes = make(map[string]*docIndex)
+	}
+	e.indexes[a.indexPath()] = idx
+
+	return idx, nil
+}
+
+// answer retrieves the chunks most relevant to the question and lets the LLM
+// answer it from them, citing the source documents. Flows summarizing long
+// conversations send the summary and the recent entries along.
+func (e *Engine) answer(s *Session, state *State, question string) (string, error) {
+	if e.llm == nil || e.embedder == nil {
+		return "", errNoLLM
+	}
+
+	idx, err := e.corpus(state.Answer)
+	if err != nil {
+		return "", err
+	}
+
+	embeddings, err := e.embedder.Embed([]string{question})
+	if err != nil {
+		return "", err
+	}
+
+	top := state.Answer.Top
+	if top <= 0 {
+		top = defaultTopChunks
+	}
+	chunks := idx.search(embeddings[0], top)
+
+	prompt := state.Answer.Prompt
+	if prompt == "" {
+		prompt = defaultAnswerPrompt
+	}
+
+	var context strings.Builder
+	context.WriteString(prompt)
+	context.WriteString("\n\nContext:")
+	for i, c := range chunks {
+		fmt.Fprintf(&context, "\n\n[%d] (%s)\n%s", i+1, c.Source, c.Text)
+	}
+
+	messages := []model.Message{{Role: "system", Content: context.String()}}
+	if e.states.Summarize != nil {
+		history, err := e.history(s, 0)
+		if err != nil {
+			return "", err
+		}
+		messages = append(messages, history...)
+	}
+	messages = append(messages, model.Message{Role: "user", Content: question})
+
+	resp, err := e.llm.Chat(chatRequest(state.LLM, messages...))
+	if err != nil {
+		return "", err
+	}
+
+	reply := strings.TrimSpace(resp.Choices[0].Message.Content)
+	if sources := citedSources(reply, chunks); len(sources) > 0 {
+		reply += "\n\nSources: " + strings.Join(sources, ", ")
+	}
+
+	return reply, nil
+}
+
+// citedSources lists the documents the reply cites as [n].
+func citedSources(reply string, chunks []retrievedChunk) []string {
+	var sources []string
+	seen := make(map[string]bool)
+
+	for _, match := range citationRe.FindAllStringSubmatch(reply, -1) {
+		var n int
+		_, _ = fmt.Sscan(match[1], &n)
+		if n < 1 || n > len(chunks) {
+			continue
+		}
+
+		source := fmt.Sprintf("[%d] %s", n, chunks[n-1].Source)
+		if !seen[source] {
+			seen[source] = true
+			sources = append(sources, source)
+		}
+	}
+
+	return sources
+}
+
+func indexCommand(args []string) {
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	flowPath := flags.String("flow", "./conversation.yml", "conversation flow file (.yml, .yaml, .json or .toml), or the name of a flow in -flows")
+	flowsDir := flags.String("flows", "", "directory to look up flow names in, flows.dir of the config by default")
+	configPath := flags.String("config", "./config.yaml", "configuration file with the openAI settings")
+	_ = flags.Parse(args)
+
+	if err := loadConfig(*configPath); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	path, err := resolveFlow(*flowPath, flowDirectory(*flowsDir))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	states, err := loadStates(path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	client := newOpenAIClient()
+	if client == nil {
+		fmt.Println(errNoLLM)
+		os.Exit(1)
+	}
+
+	done := make(map[string]bool)
+	for _, state := range states.States {
+		if state.Answer == nil || done[state.Answer.indexPath()] {
+			continue
+		}
+		done[state.Answer.indexPath()] = true
+
+		_, stats, err := refreshIndex(state.Answer, client, true)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: %d documents, %d re-indexed, %d removed\n", state.Answer.Docs, stats.Files, stats.Reindexed, stats.Removed)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// keywordEmbedder embeds texts as counts of a few keywords, so similarity is predictable.
+type keywordEmbedder struct {
+	inputs []string
+}
+
+func (e *keywordEmbedder) Embed(inputs []string) ([][]float64, error) {
+	e.inputs = append(e.inputs, inputs...)
+
+	embeddings := make([][]float64, len(inputs))
+	for i, input := range inputs {
+		input = strings.ToLower(input)
+		for _, keyword := range []string{"refund", "shipping", "password"} {
+			embeddings[i] = append(embeddings[i], float64(strings.Count(input, keyword)))
+		}
+	}
+
+	return embeddings, nil
+}
+
+func writeDocs(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	return dir
+}
+
+func TestChunkText(t *testing.T) {
+	text := "First paragraph.\n\nSecond paragraph.\r\n\r\n" + strings.Repeat("word ", 10)
+
+	chunks := chunkText(text, 20)
+
+	// Assertions
+	assert.Equal(t, []string{
+		"First paragraph.",
+		"Second paragraph.",
+		"word word word word",
+		"word word word word",
+		"word word",
+	}, chunks)
+	assert.Equal(t, []string{"First paragraph.\n\nSecond paragraph."}, chunkText("First paragraph.\n\nSecond paragraph.", 100))
+	assert.Empty(t, chunkText(" \n\n ", 100))
+}
+
+func TestCosine(t *testing.T) {
+	// Assertions
+	assert.InDelta(t, 1, cosine([]float64{1, 2}, []float64{2, 4}), 1e-9)
+	assert.InDelta(t, 0, cosine([]float64{1, 0}, []float64{0, 1}), 1e-9)
+	assert.Equal(t, float64(0), cosine([]float64{1}, []float64{1, 2}))
+	assert.Equal(t, float64(0), cosine([]float64{0, 0}, []float64{1, 2}))
+}
+
+func TestDocIndex_ReindexesOnlyChangedFiles(t *testing.T) {
+	dir := writeDocs(t, map[string]string{
+		"refunds.md":       "Refund policy: refunds take 5 days.",
+		"faq/shipping.txt": "Shipping is free.",
+		"image.png":        "not a document",
+		"faq/passwords.md": "Reset your password in settings.",
+	})
+	answer := &Answer{Docs: dir}
+	emb := &keywordEmbedder{}
+
+	_, stats, err := refreshIndex(answer, emb, true)
+	assert.NoError(t, err)
+	assert.Equal(t, indexStats{Files: 3, Reindexed: 3}, stats)
+	assert.FileExists(t, filepath.Join(dir, defaultIndexFile))
+
+	// nothing changed, nothing is embedded again
+	emb.inputs = nil
+	_, stats, err = refreshIndex(answer, emb, true)
+	assert.NoError(t, err)
+	assert.Equal(t, indexStats{Files: 3}, stats)
+	assert.Empty(t, emb.inputs)
+
+	// one file changed, one removed
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "refunds.md"), []byte("Refunds take 10 days."), 0o644))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "faq", "passwords.md")))
+	idx, stats, err := refreshIndex(answer, emb, true)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, indexStats{Files: 2, Reindexed: 1, Removed: 1}, stats)
+	assert.Equal(t, []string{"Refunds take 10 days."}, emb.inputs)
+	assert.Len(t, idx.Files, 2)
+	assert.Contains(t, idx.Files, "faq/shipping.txt")
+}
+
+func TestDocIndex_Search(t *testing.T) {
+	dir := writeDocs(t, map[string]string{
+		"refunds.md":  "Refund policy: refunds take 5 days.",
+		"shipping.md": "Shipping is free.",
+	})
+	idx, _, err := refreshIndex(&Answer{Docs: dir}, &keywordEmbedder{}, false)
+	assert.NoError(t, err)
+
+	found := idx.search([]float64{1, 0, 0}, 1)
+
+	// Assertions
+	assert.Len(t, found, 1)
+	assert.Equal(t, "refunds.md", found[0].Source)
+	assert.NoFileExists(t, filepath.Join(dir, defaultIndexFile))
+}
+
+func TestEngine_AnswerFromDocuments(t *testing.T) {
+	dir := writeDocs(t, map[string]string{
+		"refunds.md":  "Refund policy: refunds take 5 days.",
+		"shipping.md": "Shipping is free.",
+	})
+	flow := `
+states:
+  - id: 0
+    text: "Ask me anything."
+    input: "question"
+    answer:
+      docs: "` + dir + `"
+      top: 1
+    next:
+      right: 1
+  - id: 1
+    text: "Anything else?"
+`
+	engine := newTestEngine(t, flow)
+	chat := &chatStub{reply: "Refunds take 5 days [1]. See also [7]."}
+	engine.llm, engine.embedder = chat, &keywordEmbedder{}
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	texts, err := engine.Answer(session, "How long does a refund take?")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"Refunds take 5 days [1]. See also [7].\n\nSources: [1] refunds.md",
+		"Anything else?",
+	}, texts)
+	assert.Equal(t, "How long does a refund take?", session.Memory["question"])
+
+	system := chat.requests[0].Messages[0].Content
+	assert.True(t, strings.HasPrefix(system, defaultAnswerPrompt))
+	assert.Contains(t, system, "[1] (refunds.md)\nRefund policy: refunds take 5 days.")
+	assert.NotContains(t, system, "shipping.md")
+	assert.Equal(t, "How long does a refund take?", chat.requests[0].Messages[1].Content)
+}
+
+func TestEngine_AnswerWithoutLLM(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    answer:
+      docs: "./docs"
+    next:
+      right: 0
+`)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	_, err := engine.Answer(session, "How long does a refund take?")
+
+	// Assertions
+	assert.ErrorIs(t, err, errNoLLM)
+}
+
+func TestLoadStates_ResolvesDocs(t *testing.T) {
+	abs := filepath.Join(t.TempDir(), "manuals")
+	dir := writeFlows(t, map[string]string{"support.yml": `
+states:
+  - id: 0
+    input: question
+    answer:
+      docs: ./docs
+      index: cache/index.json
+    next:
+      right: 1
+  - id: 1
+    input: question
+    answer:
+      docs: "` + abs + `"
+`})
+
+	path, err := resolveFlow("support", dir)
+	assert.NoError(t, err)
+	states, err := loadStates(path)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "docs"), states.States[0].Answer.Docs)
+	assert.Equal(t, filepath.Join(dir, "cache", "index.json"), states.States[0].Answer.indexPath())
+	assert.Equal(t, abs, states.States[1].Answer.Docs)
+	assert.Equal(t, filepath.Join(abs, defaultIndexFile), states.States[1].Answer.indexPath())
+}
+
+func TestOpenAIClient_Embed(t *testing.T) {
+	// the embeddings are returned out of order
+	mockResponse := `{"object": "list", "data": [{"object": "embedding", "embedding": [0.3, 0.4], "index": 1}, {"object": "embedding", "embedding": [0.1, 0.2], "index": 0}], "model": "text-embedding-ada-002"}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/embeddings", r.URL.Path)
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(mockResponse))
+	}))
+	defer server.Close()
+
+	client := &openAIClient{url: server.URL}
+	embeddings, err := client.Embed([]string{"a", "b"})
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float64{{0.1, 0.2}, {0.3, 0.4}}, embeddings)
+
+	_, err = client.Embed([]string{"a"})
+	assert.EqualError(t, err, "expected 1 embeddings, got 2")
+}
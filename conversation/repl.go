@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const replHelp = `commands:
+  :state               show the current state
+  :memory              dump the memory
+  :set <key> <value>   set a memory value
+  :unset <key>         remove a memory value
+  :goto <id>           jump to a state
+  :undo [n]            take back the last n answers, 1 by default
+  :reload              reload the flow file, keeping the session
+  :break <id>          pause before entering a state
+  :clear <id>          remove a breakpoint
+  :breaks              list the breakpoints
+  :step                toggle pausing before every state, hook and set assignment
+  :continue, :c        continue from a breakpoint or a paused hook
+  :trace [on|off]      toggle tracing of transitions, hooks and inputs
+  :help                show this help
+  :quit                exit
+anything else is sent to the bot as your answer`
+
+// repl runs a flow in the terminal with debugging commands for flow authors.
+type repl struct {
+	BaseObserver
+
+	engine   *Engine
+	session  *Session
+	flowPath string
+
+	in    *bufio.Scanner
+	out   io.Writer
+	lines <-chan string
+
+	tracing     bool
+	stepping    bool
+	breakpoints map[int64]bool
+	// quitting is set when the REPL is quit while paused before a hook
+	quitting bool
+}
+
+func newREPL(engine *Engine, flowPath string, in io.Reader, out io.Writer) *repl {
+	r := &repl{
+		engine:      engine,
+		session:     engine.NewSession(),
+		flowPath:    flowPath,
+		in:          bufio.NewScanner(in),
+		out:         out,
+		breakpoints: make(map[int64]bool),
+	}
+
+	engine.output = out
+	engine.Observe(r)
+	engine.breakAt = func(stateID int64) bool {
+		return r.stepping || r.breakpoints[stateID]
+	}
+	engine.breakHook = r.pauseHook
+
+	return r
+}
+
+// current returns the engine of the flow the session is in, which changes
+// when a flow transfers the conversation to another one.
+func (r *repl) current() *Engine {
+	return r.engine.of(r.session)
+}
+
+func (r *repl) run() {
+	lines, done := make(chan string), make(chan struct{})
+	r.lines = lines
+	defer close(done)
+	go func() {
+		defer close(lines)
+		for r.in.Scan() {
+			select {
+			case lines <- r.in.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	r.show(r.engine.Start(r.session))
+
+	for {
+		if r.quitting {
+			return
+		}
+
+		fmt.Fprint(r.out, "> ")
+		line, ok := r.read(lines)
+		if !ok {
+			fmt.Fprintln(r.out)
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, ":") {
+			if quit := r.command(line); quit {
+				return
+			}
+			continue
+		}
+
+		r.answer(line)
+	}
+}
+
+// read waits for the next line, firing the timeouts of the states the
+// session waits in meanwhile.
+func (r *repl) read(lines <-chan string) (string, bool) {
+	for {
+		var timeout <-chan time.Time
+		if at, ok := r.engine.Deadline(r.session); ok {
+			timeout = time.After(time.Until(at))
+		}
+
+		select {
+		case line, ok := <-lines:
+			return line, ok
+		case <-timeout:
+			fmt.Fprintln(r.out)
+			r.show(r.engine.Timeout(r.session))
+			fmt.Fprint(r.out, "> ")
+		}
+	}
+}
+
+func (r *repl) answer(line string) {
+	switch {
+	case r.session.Done:
+		fmt.Fprintln(r.out, "the conversation is over, use :goto <id> to go on")
+		return
+	case r.session.Paused:
+		fmt.Fprintln(r.out, "paused at a breakpoint, use :continue first")
+		return
+	case r.session.Handoff != nil:
+		fmt.Fprintln(r.out, "the conversation is handed off to an operator, use :goto <id> to take it back")
+		return
+	}
+
+	r.show(r.engine.Answer(r.session, line))
+}
+
+// show prints the bot's texts and where the conversation stopped.
+func (r *repl) show(texts []string, err error) {
+	if r.quitting {
+		return
+	}
+
+	for _, text := range texts {
+		fmt.Fprintln(r.out, text)
+	}
+
+	if err != nil {
+		fmt.Fprintln(r.out, "error:", r.current().redactError(r.session.Memory, err))
+	}
+
+	switch {
+	case r.session.Paused:
+		r.showPause()
+	case r.session.Handoff != nil:
+		fmt.Fprintln(r.out, "the conversation is handed off to an operator, run the serve command to talk to operators")
+	case r.session.Done:
+		fmt.Fprintln(r.out, "end")
+	}
+
+	if replies := r.engine.Replies(r.session); len(replies) > 0 {
+		fmt.Fprintf(r.out, "[%s]\n", strings.Join(replies, "] ["))
+	}
+}
+
+func (r *repl) showPause() {
+	fmt.Fprintf(r.out, "paused before state %d\n", r.session.StateID)
+
+	state := r.current().states.GetState(r.session.StateID)
+	if state == nil {
+		return
+	}
+
+	stages := []struct {
+		name    string
+		actions Actions
+	}{{"before", state.Before}, {"set", setActions(state.Set)}, {"after", state.After}}
+	for _, stage := range stages {
+		for _, a := range stage.actions {
+			fmt.Fprintf(r.out, "  hook: %s\n", r.describeHook(stage.name, a.String()))
+		}
+	}
+}
+
+// pauseHook pauses before a hook or set assignment while stepping, running
+// commands until the flow continues. Answers and commands moving the session
+// wait, as the engine is in the middle of the state.
+func (r *repl) pauseHook(s *Session, h HookCall) {
+	if !r.stepping || r.quitting || r.lines == nil {
+		return
+	}
+
+	fmt.Fprintf(r.out, "paused before hook in state %d: %s\n", h.StateID, r.describeHook(h.Stage, h.Hook))
+	for {
+		fmt.Fprint(r.out, "> ")
+		line, ok := <-r.lines
+		if !ok {
+			fmt.Fprintln(r.out)
+			r.quitting = true
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		name, _, _ := strings.Cut(strings.TrimPrefix(line, ":"), " ")
+		switch {
+		case !strings.HasPrefix(line, ":"):
+			fmt.Fprintln(r.out, "paused before a hook, use :continue first")
+		case name == "continue" || name == "c":
+			return
+		case name == "goto" || name == "undo" || name == "reload":
+			fmt.Fprintf(r.out, "paused before a hook, use :continue before :%s\n", name)
+		default:
+			if quit := r.command(line); quit {
+				r.quitting = true
+				return
+			}
+		}
+	}
+}
+
+// describeHook shows a hook with the memory values it refers to.
+func (r *repl) describeHook(stage, hook string) string {
+	desc := hookName(stage, hook)
+	m := r.current().redactMemory(r.session.Memory)
+	sep, seen := " with", make(map[string]bool)
+	for _, match := range placeholderRe.FindAllStringSubmatch(hook, -1) {
+		if key := match[1]; !seen[key] {
+			desc += fmt.Sprintf("%s {%s} = %q", sep, key, m[key])
+			sep, seen[key] = ",", true
+		}
+	}
+
+	return desc
+}
+
+// hookName is the hook with its stage; set assignments name theirs already.
+func hookName(stage, hook string) string {
+	if stage == "set" {
+		return hook
+	}
+
+	return stage + " " + hook
+}
+
+// command runs a colon command and reports whether the REPL should quit.
+func (r *repl) command(line string) bool {
+	name, args, _ := strings.Cut(line[1:], " ")
+	args = strings.TrimSpace(args)
+
+	switch name {
+	case "quit", "q":
+		return true
+	case "help", "h":
+		fmt.Fprintln(r.out, replHelp)
+	case "state":
+		r.showState()
+	case "memory", "m":
+		r.showMemory()
+	case "set":
+		key, value, _ := strings.Cut(args, " ")
+		if key == "" {
+			fmt.Fprintln(r.out, "usage: :set <key> <value>")
+			break
+		}
+		r.session.Memory[key] = value
+		r.session.sync()
+	case "unset":
+		delete(r.session.Memory, args)
+		r.session.sync()
+	case "goto":
+		if id, ok := r.stateID(args); ok {
+			r.show(r.engine.Goto(r.session, id))
+		}
+	case "undo":
+		r.undo(args)
+	case "reload":
+		r.reload()
+	case "break", "b":
+		if id, ok := r.stateID(args); ok {
+			r.breakpoints[id] = true
+		}
+	case "clear":
+		if id, ok := r.stateID(args); ok {
+			delete(r.breakpoints, id)
+		}
+	case "breaks":
+		r.showBreakpoints()
+	case "step", "s":
+		r.stepping = !r.stepping
+		fmt.Fprintln(r.out, "stepping:", onOff(r.stepping))
+	case "continue", "c":
+		r.show(r.engine.Continue(r.session))
+	case "trace", "t":
+		switch args {
+		case "on":
+			r.tracing = true
+		case "off":
+			r.tracing = false
+		default:
+			r.tracing = !r.tracing
+		}
+		fmt.Fprintln(r.out, "tracing:", onOff(r.tracing))
+	default:
+		fmt.Fprintf(r.out, "unknown command :%s, see :help\n", name)
+	}
+
+	return false
+}
+
+// undo takes back answers and repeats the question the bot is back at.
+func (r *repl) undo(arg string) {
+	turns := 1
+	if arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintln(r.out, "expected a number of answers, got", strconv.Quote(arg))
+			return
+		}
+		turns = n
+	}
+
+	if err := r.engine.Undo(r.session, turns); err != nil {
+		fmt.Fprintln(r.out, "error:", err)
+		return
+	}
+
+	fmt.Fprintf(r.out, "back at state %d\n", r.session.StateID)
+	if state := r.current().states.GetState(r.session.StateID); state != nil && state.Text != "" {
+		fmt.Fprintln(r.out, render(state.Text, r.session.Memory))
+	}
+}
+
+func (r *repl) stateID(arg string) (int64, bool) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		fmt.Fprintln(r.out, "expected a state id, got", strconv.Quote(arg))
+		return 0, false
+	}
+
+	if r.current().states.GetState(id) == nil {
+		fmt.Fprintf(r.out, "no state with id %d\n", id)
+		return 0, false
+	}
+
+	return id, true
+}
+
+func (r *repl) showState() {
+	state := r.current().states.GetState(r.session.StateID)
+	if state == nil {
+		fmt.Fprintf(r.out, "no state with id %d\n", r.session.StateID)
+		return
+	}
+
+	// the JSON representation leaves out unset fields
+	data, err := json.Marshal(state)
+	if err == nil {
+		var doc interface{}
+		if err = json.Unmarshal(data, &doc); err == nil {
+			data, err = yaml.Marshal(doc)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(r.out, "error:", err)
+		return
+	}
+
+	fmt.Fprint(r.out, string(data))
+}
+
+func (r *repl) showMemory() {
+	keys := make([]string, 0, len(r.session.Memory))
+	for k := range r.session.Memory {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	m := r.current().redactMemory(r.session.Memory)
+	for _, k := range keys {
+		fmt.Fprintf(r.out, "%s = %q\n", k, m[k])
+	}
+}
+
+func (r *repl) showBreakpoints() {
+	ids := make([]int64, 0, len(r.breakpoints))
+	for id := range r.breakpoints {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	fmt.Fprintln(r.out, "breakpoints:", ids)
+}
+
+func (r *repl) reload() {
+	states, err := loadStates(r.flowPath)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+
+	r.engine.states = states
+	fmt.Fprintf(r.out, "reloaded %d states from %s\n", len(states.States), r.flowPath)
+
+	if states.GetState(r.session.StateID) == nil {
+		fmt.Fprintf(r.out, "the current state %d no longer exists, use :goto <id>\n", r.session.StateID)
+	}
+}
+
+func (r *repl) InputReceived(s *Session, stateID int64, input string) {
+	if state := r.current().states.GetState(stateID); state != nil && state.Input != "" {
+		r.tracef("state %d input %s = %q", stateID, state.Input, input)
+	}
+}
+
+func (r *repl) TransitionChosen(s *Session, t Transition) {
+	if t.Condition != "" {
+		r.tracef("%d -%s-> %d (%s is %t)", t.From, t.Edge, t.To, t.Condition, t.Result)
+		return
+	}
+
+	r.tracef("%d -%s-> %d", t.From, t.Edge, t.To)
+}
+
+func (r *repl) HookExecuted(s *Session, h HookCall) {
+	result := "ok"
+	switch {
+	case h.Err != nil:
+		result = "error: " + h.Err.Error()
+	case h.Skipped:
+		result = "skipped, unknown function"
+	}
+
+	if r.stepping && !r.tracing {
+		fmt.Fprintf(r.out, "[step] state %d %s with %q: %s\n", h.StateID, hookName(h.Stage, h.Hook), h.Arg, result)
+		return
+	}
+
+	r.tracef("state %d %s with %q: %s", h.StateID, hookName(h.Stage, h.Hook), h.Arg, result)
+}
+
+func (r *repl) tracef(format string, args ...interface{}) {
+	if r.tracing {
+		fmt.Fprintf(r.out, "[trace] "+format+"\n", args...)
+	}
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+
+	return "off"
+}
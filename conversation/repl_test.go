@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runREPL(t *testing.T, flowPath, script string) string {
+	states, err := loadStates(flowPath)
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	newREPL(NewEngine(states), flowPath, strings.NewReader(script), &out).run()
+
+	return out.String()
+}
+
+func writeFlow(t *testing.T, flow string) string {
+	path := filepath.Join(t.TempDir(), "conversation.yml")
+	assert.NoError(t, os.WriteFile(path, []byte(flow), 0o644))
+
+	return path
+}
+
+func TestREPL_Conversation(t *testing.T) {
+	out := runREPL(t, writeFlow(t, testFlowYAML), "Anna\n\n")
+
+	// Assertions
+	assert.Equal(t, "Hello, I'm a bot.\nWhat is your name?\n> What is your name?\n> Bye, !\nend\n> \n", out)
+}
+
+func TestREPL_TraceAndMemory(t *testing.T) {
+	out := runREPL(t, writeFlow(t, testFlowYAML), ":trace on\nAnna\n:memory\n:set name Bob\n:unset header\n:memory\n:quit\nnever read\n")
+
+	// Assertions
+	assert.Contains(t, out, "tracing: on\n")
+	assert.Contains(t, out, "[trace] state 1 input name = \"Anna\"\n")
+	assert.Contains(t, out, "[trace] 1 -left-> 1 (isEmpty({name}) is false)\n")
+	assert.Contains(t, out, "> name = \"Anna\"\n")
+	assert.Contains(t, out, "> name = \"Bob\"\n")
+	assert.NotContains(t, out, "never read")
+}
+
+func TestREPL_BreakpointsAndStepping(t *testing.T) {
+	out := runREPL(t, writeFlow(t, testFlowYAML), ":break 1\n:breaks\n:goto 0\nAnna\n:c\n:clear 1\n:step\n:goto 0\n:quit\n")
+
+	// Assertions
+	assert.Contains(t, out, "breakpoints: [1]\n")
+	assert.Contains(t, out, "Hello, I'm a bot.\npaused before state 1\n")
+	assert.Contains(t, out, "paused at a breakpoint, use :continue first\n")
+	assert.Contains(t, out, "> What is your name?\n")
+	assert.Contains(t, out, "stepping: on\n")
+	assert.Contains(t, out, "paused before state 0\n  hook: before print({header}) with {header} = \"\"\n")
+}
+
+const hooksFlowYAML = `
+states:
+  - id: 0
+    before: "print({status})"
+    set: "status = 'open'"
+    text: "Hello, the shop is {status}."
+    input: name
+    after: "print({name})"
+    next:
+      right: 1
+  - id: 1
+    text: "Bye, {name}!"
+`
+
+func TestREPL_SteppingHooks(t *testing.T) {
+	path := writeFlow(t, hooksFlowYAML)
+	out := runREPL(t, path, ":unset status\n:step\n:goto 0\n:c\nAnna\n:goto 1\n:memory\n:c\n:c\nAnna\n:step\n:c\n:quit\n")
+	quit := runREPL(t, path, ":step\n:goto 0\n:c\n:quit\nnever read\n")
+
+	// Assertions
+	assert.Contains(t, out, "paused before state 0\n"+
+		"  hook: before print({status}) with {status} = \"\"\n"+
+		"  hook: set status = 'open'\n"+
+		"  hook: after print({name}) with {name} = \"\"\n")
+	assert.Contains(t, out, "> paused before hook in state 0: before print({status}) with {status} = \"\"\n")
+	assert.Contains(t, out, "> paused before a hook, use :continue first\n")
+	assert.Contains(t, out, "> paused before a hook, use :continue before :goto\n")
+	assert.Contains(t, out, "[step] state 0 before print({status}) with \"\": ok\n"+
+		"paused before hook in state 0: set status = 'open'\n")
+	assert.Contains(t, out, "Hello, the shop is open.\n")
+	assert.Contains(t, out, "> paused before hook in state 0: after print({name}) with {name} = \"Anna\"\n")
+	assert.Contains(t, out, "stepping: off\n> Anna\nBye, Anna!\nend\n")
+	assert.Equal(t, 1, strings.Count(quit, "Hello"))
+	assert.NotContains(t, quit, "never read")
+}
+
+func TestREPL_Undo(t *testing.T) {
+	out := runREPL(t, writeFlow(t, testFlowYAML), "Anna\n:undo\n:memory\n:undo\n:undo x\n:quit\n")
+
+	// Assertions
+	assert.Contains(t, out, "> back at state 1\nWhat is your name?\n")
+	assert.NotContains(t, out, "name = ")
+	assert.Contains(t, out, "> error: nothing to undo\n")
+	assert.Contains(t, out, "> expected a number of answers, got \"x\"\n")
+}
+
+func TestREPL_StateAndErrors(t *testing.T) {
+	out := runREPL(t, writeFlow(t, testFlowYAML), ":state\n:goto x\n:goto 42\n:nope\n")
+
+	// Assertions
+	assert.Contains(t, out, "id: 1\ninput: name\nnext:\n")
+	assert.Contains(t, out, "right-if: isEmpty({name})\ntext: What is your name?\n")
+	assert.Contains(t, out, "expected a state id, got \"x\"\n")
+	assert.Contains(t, out, "no state with id 42\n")
+	assert.Contains(t, out, "unknown command :nope, see :help\n")
+}
+
+func TestREPL_Reload(t *testing.T) {
+	path := writeFlow(t, testFlowYAML)
+	states, err := loadStates(path)
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	in := strings.NewReader(":reload\nAnna\n")
+	r := newREPL(NewEngine(states), path, in, &out)
+
+	// the flow changes on disk while the REPL runs
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Replace(testFlowYAML, "What is your name?", "Your name, please?", 1)), 0o644))
+	r.run()
+
+	// Assertions
+	assert.Contains(t, out.String(), "reloaded 3 states from "+path+"\n")
+	assert.Contains(t, out.String(), "> Your name, please?\n")
+}
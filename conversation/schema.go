@@ -0,0 +1,263 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//go:embed conversation.schema.json
+var conversationSchema []byte
+
+// schema is the subset of JSON Schema used by conversation.schema.json.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Defs                 map[string]*schema `json:"$defs"`
+	Type                 schemaTypes        `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *additional        `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	MinItems             *int               `json:"minItems"`
+	MinLength            *int               `json:"minLength"`
+	Minimum              *float64           `json:"minimum"`
+	Enum                 []interface{}      `json:"enum"`
+}
+
+// schemaTypes accepts both "type": "string" and "type": ["string", "array"].
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*t = list
+
+	return nil
+}
+
+// additional accepts both "additionalProperties": false and a schema the
+// additional properties must match.
+type additional struct {
+	Allowed bool
+	Schema  *schema
+}
+
+func (a *additional) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.Allowed); err == nil {
+		return nil
+	}
+
+	a.Allowed = true
+	return json.Unmarshal(data, &a.Schema)
+}
+
+type validationError struct {
+	Path    string
+	Message string
+}
+
+func (e validationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+type validationErrors []validationError
+
+func (ee validationErrors) Error() string {
+	lines := make([]string, 0, len(ee))
+	for _, e := range ee {
+		lines = append(lines, e.Error())
+	}
+
+	return "invalid conversation:\n  " + strings.Join(lines, "\n  ")
+}
+
+func loadSchema(data []byte) (*schema, error) {
+	var s schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+
+	return &s, nil
+}
+
+// validate checks a decoded document (as produced by the yaml, json and toml
+// decoders) against the schema and returns every violation found.
+func (s *schema) validate(doc interface{}) error {
+	var errs validationErrors
+	s.validateNode(s, doc, "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (s *schema) validateNode(root *schema, node interface{}, path string, errs *validationErrors) {
+	// keywords next to a $ref apply as well, e.g. required
+	if s.Ref != "" {
+		ref, err := root.resolve(s.Ref)
+		if err != nil {
+			*errs = append(*errs, validationError{pathOrRoot(path), err.Error()})
+			return
+		}
+		ref.validateNode(root, node, path, errs)
+	}
+
+	if len(s.Type) > 0 && !s.Type.matches(node) {
+		*errs = append(*errs, validationError{pathOrRoot(path), fmt.Sprintf("expected %s, got %s", strings.Join(s.Type, " or "), typeName(node))})
+		return
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, node) {
+		*errs = append(*errs, validationError{pathOrRoot(path), fmt.Sprintf("must be one of %v", s.Enum)})
+	}
+
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, validationError{pathOrRoot(path), fmt.Sprintf("missing required property %q", name)})
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			child, ok := s.Properties[k]
+			if !ok && s.AdditionalProperties != nil {
+				if !s.AdditionalProperties.Allowed {
+					*errs = append(*errs, validationError{joinPath(path, k), "unknown property"})
+				}
+				child = s.AdditionalProperties.Schema
+			}
+			if child == nil {
+				continue
+			}
+			child.validateNode(root, v[k], joinPath(path, k), errs)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			*errs = append(*errs, validationError{pathOrRoot(path), fmt.Sprintf("must have at least %d items", *s.MinItems)})
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validateNode(root, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case string:
+		if s.MinLength != nil && len(v) < *s.MinLength {
+			*errs = append(*errs, validationError{pathOrRoot(path), fmt.Sprintf("must be at least %d characters long", *s.MinLength)})
+		}
+	default:
+		if n, ok := toNumber(node); ok && s.Minimum != nil && n < *s.Minimum {
+			*errs = append(*errs, validationError{pathOrRoot(path), fmt.Sprintf("must be >= %v", *s.Minimum)})
+		}
+	}
+}
+
+func (s *schema) resolve(ref string) (*schema, error) {
+	name, ok := strings.CutPrefix(ref, "#/$defs/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported schema reference %q", ref)
+	}
+
+	def, ok := s.Defs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema reference %q", ref)
+	}
+
+	return def, nil
+}
+
+func (t schemaTypes) matches(node interface{}) bool {
+	for _, name := range t {
+		if typeName(node) == name {
+			return true
+		}
+		// every integer is a number as well
+		if name == "number" && typeName(node) == "integer" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func typeName(node interface{}) string {
+	switch v := node.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		n, ok := toNumber(v)
+		if !ok {
+			return fmt.Sprintf("%T", v)
+		}
+		if n == float64(int64(n)) {
+			return "integer"
+		}
+		return "number"
+	}
+}
+
+func toNumber(node interface{}) (float64, bool) {
+	switch v := node.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	return 0, false
+}
+
+func inEnum(enum []interface{}, node interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(node) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+
+	return path
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func validateYAML(t *testing.T, flow string) error {
+	var doc interface{}
+	assert.NoError(t, yaml.Unmarshal([]byte(flow), &doc))
+
+	s, err := loadSchema(conversationSchema)
+	assert.NoError(t, err)
+
+	return s.validate(doc)
+}
+
+func TestSchema_ValidFlow(t *testing.T) {
+	err := validateYAML(t, testFlowYAML)
+
+	// Assertions
+	assert.NoError(t, err)
+}
+
+func TestSchema_ReportsEveryViolationWithPath(t *testing.T) {
+	flow := `
+states:
+  - id: 0
+    text: 5
+    next:
+      rigth: 1
+  - id: -1
+    input: ""
+`
+	err := validateYAML(t, flow)
+
+	// Assertions
+	assert.Error(t, err)
+	assert.Equal(t, validationErrors{
+		{Path: "states[0].next", Message: `missing required property "right"`},
+		{Path: "states[0].next.rigth", Message: "unknown property"},
+		{Path: "states[0].text", Message: "expected string, got integer"},
+		{Path: "states[1].id", Message: "must be >= 0"},
+		{Path: "states[1].input", Message: "must be at least 1 characters long"},
+	}, err)
+}
+
+func TestSchema_MissingStates(t *testing.T) {
+	err := validateYAML(t, `state: []`)
+
+	// Assertions
+	assert.Equal(t, validationErrors{
+		{Path: "(root)", Message: `missing required property "states"`},
+		{Path: "state", Message: "unknown property"},
+	}, err)
+}
+
+func TestSchema_EmptyStates(t *testing.T) {
+	err := validateYAML(t, `states: []`)
+
+	// Assertions
+	assert.Equal(t, validationErrors{
+		{Path: "states", Message: "must have at least 1 items"},
+	}, err)
+}
+
+func TestSchema_UnknownReference(t *testing.T) {
+	s, err := loadSchema([]byte(`{"$ref": "#/$defs/missing"}`))
+	assert.NoError(t, err)
+
+	err = s.validate(map[string]interface{}{})
+
+	// Assertions
+	assert.EqualError(t, err, "invalid conversation:\n  (root): unknown schema reference \"#/$defs/missing\"")
+}
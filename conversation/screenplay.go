@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A screenplay writes a flow as markdown the way a dialog script reads:
+//
+//	Start: 1
+//
+//	## 1
+//	NOTE: before print({header})
+//	BOT: What is your name?
+//	USER: {name}
+//	NOTE: after greet({name})
+//	-> 2 if isEmpty({name})
+//	-> 1 otherwise
+//
+// Each BOT line is a line of the state's text, each NOTE line a hook or set
+// assignment. Blank lines and headings other than the state ones are skipped,
+// so the file may carry a title.
+const (
+	screenplayStart = "Start:"
+	screenplayState = "## "
+	screenplayBot   = "BOT:"
+	screenplayUser  = "USER:"
+	screenplayNote  = "NOTE:"
+	screenplayNext  = "->"
+)
+
+func encodeScreenplay(states *States) ([]byte, error) {
+	if err := checkSimple(states, formatScreenplay); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if states.Start != 0 {
+		fmt.Fprintf(&out, "%s %d\n\n", screenplayStart, states.Start)
+	}
+
+	for i, state := range states.States {
+		if i > 0 {
+			out.WriteByte('\n')
+		}
+		fmt.Fprintf(&out, "%s%d\n", screenplayState, state.ID)
+
+		before, after := hookNotes(state)
+		for _, note := range before {
+			fmt.Fprintf(&out, "%s %s\n", screenplayNote, note)
+		}
+
+		if state.Text != "" {
+			for _, line := range strings.Split(state.Text, "\n") {
+				if line == "" {
+					out.WriteString(screenplayBot + "\n")
+					continue
+				}
+				fmt.Fprintf(&out, "%s %s\n", screenplayBot, line)
+			}
+		}
+		if state.Input != "" {
+			fmt.Fprintf(&out, "%s {%s}\n", screenplayUser, state.Input)
+		}
+		for _, note := range after {
+			fmt.Fprintf(&out, "%s %s\n", screenplayNote, note)
+		}
+
+		if next := state.Next; next != nil {
+			if next.RightIf != "" {
+				fmt.Fprintf(&out, "%s %d if %s\n", screenplayNext, next.RightId, next.RightIf)
+			} else {
+				fmt.Fprintf(&out, "%s %d\n", screenplayNext, next.RightId)
+			}
+			if next.RightIf != "" || next.LeftId != 0 {
+				fmt.Fprintf(&out, "%s %d otherwise\n", screenplayNext, next.LeftId)
+			}
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+func decodeScreenplay(data []byte) (*States, error) {
+	states := &States{States: []State{}}
+	var state *State
+	var text []string
+	hasText, hasRight, hasLeft := false, false, false
+
+	// done completes the state read so far
+	done := func() error {
+		if state == nil {
+			return nil
+		}
+		if hasLeft && !hasRight {
+			return fmt.Errorf("state %d: otherwise without a transition before it", state.ID)
+		}
+		if hasText {
+			state.Text = strings.Join(text, "\n")
+		}
+		states.States = append(states.States, *state)
+
+		state, text, hasText, hasRight, hasLeft = nil, nil, false, false, false
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		errorf := func(format string, args ...interface{}) error {
+			return fmt.Errorf("line %d: %s", n, fmt.Sprintf(format, args...))
+		}
+
+		switch {
+		case strings.HasPrefix(line, screenplayState):
+			if err := done(); err != nil {
+				return nil, err
+			}
+			id, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, screenplayState)), 10, 64)
+			if err != nil {
+				return nil, errorf("state heading %q needs a number", line)
+			}
+			state = &State{ID: id}
+		case strings.TrimSpace(line) == "", strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, screenplayStart) && state == nil:
+			start, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, screenplayStart)), 10, 64)
+			if err != nil {
+				return nil, errorf("start %q needs a number", line)
+			}
+			states.Start = start
+		case state == nil:
+			return nil, errorf("%q is outside a state, start states with %q", line, screenplayState+"<id>")
+		case strings.HasPrefix(line, screenplayBot):
+			text = append(text, strings.TrimPrefix(strings.TrimPrefix(line, screenplayBot), " "))
+			hasText = true
+		case strings.HasPrefix(line, screenplayUser):
+			input := strings.TrimSpace(strings.TrimPrefix(line, screenplayUser))
+			if !strings.HasPrefix(input, "{") || !strings.HasSuffix(input, "}") || len(input) < 3 {
+				return nil, errorf("user input %q must be a memory key in braces, like {name}", input)
+			}
+			state.Input = input[1 : len(input)-1]
+		case strings.HasPrefix(line, screenplayNote):
+			if err := addHookNote(state, strings.TrimSpace(strings.TrimPrefix(line, screenplayNote))); err != nil {
+				return nil, errorf("%v", err)
+			}
+		case strings.HasPrefix(line, screenplayNext):
+			fields := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, screenplayNext)), " ", 2)
+			to, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				return nil, errorf("transition %q needs a state number", line)
+			}
+			if state.Next == nil {
+				state.Next = &Next{}
+			}
+
+			switch {
+			case len(fields) == 2 && strings.TrimSpace(fields[1]) == "otherwise":
+				if hasLeft {
+					return nil, errorf("state %d has more than one otherwise", state.ID)
+				}
+				state.Next.LeftId, hasLeft = to, true
+			case hasRight:
+				return nil, errorf("state %d has more than one transition", state.ID)
+			case len(fields) == 2 && strings.HasPrefix(fields[1], "if "):
+				state.Next.RightId, state.Next.RightIf = to, strings.TrimSpace(strings.TrimPrefix(fields[1], "if "))
+				hasRight = true
+			case len(fields) == 1:
+				state.Next.RightId, hasRight = to, true
+			default:
+				return nil, errorf("transition %q must end in \"if <condition>\" or \"otherwise\"", line)
+			}
+		default:
+			return nil, errorf("%q is not a BOT, USER, NOTE or -> line", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := done(); err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
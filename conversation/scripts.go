@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// scriptSuffixes are the file name endings of conversation test scripts.
+var scriptSuffixes = []string{".test.yml", ".test.yaml"}
+
+// scriptFile holds the scripted conversations testing a flow. The flow is
+// given by path relative to the file, or by name in the flows directory; by
+// default it is the flow next to the file named like it, e.g. order.yml for
+// order.test.yml.
+type scriptFile struct {
+	Flow  string   `yaml:"flow"`
+	Tests []script `yaml:"tests"`
+}
+
+// script is a conversation to have with the flow. The first step without a
+// user answer checks the texts the flow starts with.
+type script struct {
+	Name   string            `yaml:"name"`
+	Memory map[string]string `yaml:"memory"` // set before the conversation starts
+	Steps  []scriptStep      `yaml:"steps"`
+	Expect *scriptExpect     `yaml:"expect"`
+}
+
+type scriptStep struct {
+	User *string     `yaml:"user"`
+	Bot  scriptTexts `yaml:"bot"` // not checked when left out
+}
+
+// scriptExpect checks where the conversation ended up.
+type scriptExpect struct {
+	State  *int64            `yaml:"state"`
+	Done   *bool             `yaml:"done"`
+	Memory map[string]string `yaml:"memory"`
+}
+
+// scriptTexts accepts both a single "bot: text" and a list of texts.
+type scriptTexts []string
+
+func (t *scriptTexts) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*t = scriptTexts{node.Value}
+		return nil
+	}
+
+	list := []string{}
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*t = list
+
+	return nil
+}
+
+// run has the scripted conversation with the engine, returning the first
+// difference from the script.
+func (sc *script) run(engine *Engine) error {
+	session := engine.NewSession()
+	for k, v := range sc.Memory {
+		session.Memory[k] = v
+	}
+
+	texts, err := engine.Start(session)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+
+	for i, step := range sc.Steps {
+		switch {
+		case step.User != nil:
+			if texts, err = engine.Answer(session, *step.User); err != nil {
+				return fmt.Errorf("step %d: %w", i+1, err)
+			}
+		case i > 0:
+			return fmt.Errorf("step %d: user is missing", i+1)
+		}
+
+		if step.Bot != nil && !reflect.DeepEqual([]string(step.Bot), append([]string{}, texts...)) {
+			return fmt.Errorf("step %d: bot said %q, expected %q", i+1, texts, []string(step.Bot))
+		}
+	}
+
+	if x := sc.Expect; x != nil {
+		if x.State != nil && *x.State != session.StateID {
+			return fmt.Errorf("ended in state %d, expected %d", session.StateID, *x.State)
+		}
+		if x.Done != nil && *x.Done != session.Done {
+			return fmt.Errorf("done is %t, expected %t", session.Done, *x.Done)
+		}
+
+		keys := make([]string, 0, len(x.Memory))
+		for k := range x.Memory {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if v, ok := session.Memory[k]; !ok || v != x.Memory[k] {
+				return fmt.Errorf("memory %s is %q, expected %q", k, v, x.Memory[k])
+			}
+		}
+	}
+
+	return nil
+}
+
+// scriptRun runs test scripts, collecting the coverage of each flow tested.
+type scriptRun struct {
+	dir    string // flows directory
+	out    io.Writer
+	failed int
+
+	engines   map[string]*Engine // by flow path
+	coverages map[string]*coverage
+	paths     []string
+}
+
+func newScriptRun(dir string, out io.Writer) *scriptRun {
+	return &scriptRun{dir: dir, out: out, engines: make(map[string]*Engine), coverages: make(map[string]*coverage)}
+}
+
+// file runs the scripts of the file.
+func (sr *scriptRun) file(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var sf scriptFile
+	if err := yaml.Unmarshal(data, &sf); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	engine, err := sr.engine(path, sf.Flow)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i, sc := range sf.Tests {
+		name := sc.Name
+		if name == "" {
+			name = fmt.Sprintf("test %d", i+1)
+		}
+
+		if err := sc.run(engine); err != nil {
+			sr.failed++
+			fmt.Fprintf(sr.out, "FAIL %s: %s: %v\n", path, name, err)
+			continue
+		}
+		fmt.Fprintf(sr.out, "ok   %s: %s\n", path, name)
+	}
+
+	return nil
+}
+
+// engine loads the flow a script file tests, once per flow, observing its
+// coverage.
+func (sr *scriptRun) engine(scriptPath, flow string) (*Engine, error) {
+	dir := filepath.Dir(scriptPath)
+
+	var path string
+	var err error
+	switch {
+	case flow == "":
+		path, err = resolveFlow(scriptName(scriptPath), dir)
+	case isFlowFile(flow) || strings.ContainsAny(flow, `/\`):
+		path = filepath.Join(dir, flow)
+	default:
+		path, err = resolveFlow(flow, sr.dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if engine := sr.engines[path]; engine != nil {
+		return engine, nil
+	}
+
+	engine, _, err := loadEngine(path, sr.dir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newCoverage(path, engine.states)
+	engine.Observe(c)
+	sr.engines[path], sr.coverages[path] = engine, c
+	sr.paths = append(sr.paths, path)
+
+	return engine, nil
+}
+
+func (sr *scriptRun) reports() []*coverageReport {
+	reports := make([]*coverageReport, 0, len(sr.paths))
+	for _, path := range sr.paths {
+		reports = append(reports, sr.coverages[path].report())
+	}
+
+	return reports
+}
+
+// scriptName is the name of the flow a script file tests by default.
+func scriptName(path string) string {
+	base := filepath.Base(path)
+	for _, suffix := range scriptSuffixes {
+		if strings.HasSuffix(base, suffix) {
+			return strings.TrimSuffix(base, suffix)
+		}
+	}
+
+	return flowName(path)
+}
+
+func isScriptFile(name string) bool {
+	for _, suffix := range scriptSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scriptFiles lists the script files given, looking for them in directories.
+func scriptFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && isScriptFile(d.Name()) {
+				files = append(files, p)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("no test scripts found (*.test.yml)")
+	}
+
+	return files, nil
+}
+
+func testCommand(args []string) {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	flowsDir := flags.String("flows", "", "directory to look up flow names in, flows.dir of the config by default")
+	configPath := flags.String("config", "./config.yaml", "configuration file with the flows settings")
+	htmlPath := flags.String("html", "", "file to write an HTML coverage report to")
+	threshold := flags.Float64("threshold", 0, "coverage in percent every flow must reach")
+	_ = flags.Parse(args)
+
+	if err := loadConfig(*configPath); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := scriptFiles(paths)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	sr := newScriptRun(flowDirectory(*flowsDir), os.Stdout)
+	for _, file := range files {
+		if err := sr.file(file); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Println()
+	below := 0
+	reports := sr.reports()
+	for _, r := range reports {
+		r.print(os.Stdout)
+		if r.Percent() < *threshold {
+			below++
+		}
+	}
+
+	if *htmlPath != "" {
+		f, err := os.Create(*htmlPath)
+		if err == nil {
+			err = writeCoverageHTML(f, reports)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if below > 0 {
+		fmt.Printf("\n%d flow(s) below the coverage threshold of %.1f%%\n", below, *threshold)
+	}
+	if sr.failed > 0 || below > 0 {
+		os.Exit(1)
+	}
+}
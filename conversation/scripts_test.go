@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const greetingScript = `
+tests:
+  - name: empty name
+    steps:
+      - bot: ["Hello, I'm a bot.", "What is your name?"]
+      - user: ""
+        bot: "Bye, !"
+    expect:
+      state: 2
+      done: true
+`
+
+func TestScriptRun_Coverage(t *testing.T) {
+	dir := writeFlows(t, map[string]string{"greeting.yml": testFlowYAML, "greeting.test.yml": greetingScript})
+	var out bytes.Buffer
+	sr := newScriptRun("", &out)
+
+	err := sr.file(filepath.Join(dir, "greeting.test.yml"))
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sr.failed)
+	assert.Contains(t, out.String(), "ok   "+filepath.Join(dir, "greeting.test.yml")+": empty name\n")
+
+	reports := sr.reports()
+	assert.Len(t, reports, 1)
+	assert.InDelta(t, 100*6/7.0, reports[0].Percent(), 0.01)
+
+	out.Reset()
+	reports[0].print(&out)
+	assert.Equal(t, "coverage of "+filepath.Join(dir, "greeting.yml")+": 85.7%\n"+
+		"  states: 3/3\n"+
+		"  edges: 2/3\n"+
+		"    never: 1 -left-> 1\n"+
+		"  hooks: 1/1\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, writeCoverageHTML(&out, reports))
+	assert.Contains(t, out.String(), `<tr class="miss"><td>1 -left-&gt; 1</td><td>0</td></tr>`)
+	assert.Contains(t, out.String(), `<tr class="hit"><td>state 0 before: print({header})</td><td>1</td></tr>`)
+}
+
+func TestCoverage_SkippedHooks(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    text: "How can I help?"
+    input: prompt
+    after: "printPrompt({prompt})"
+    next:
+      right: 1
+  - id: 1
+    text: "Bye"
+`)
+	c := newCoverage("prompt.yml", engine.states)
+	engine.Observe(c)
+	recorder := &TraceRecorder{}
+	engine.Observe(recorder)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	_, err := engine.Answer(session, "hi")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []coverageItem{{Name: "state 0 after: printPrompt({prompt})", Count: 1}}, c.report().Hooks)
+	assert.Contains(t, recorder.Events, TraceEvent{Type: eventHook, StateID: 0, Stage: "after", Hook: "printPrompt({prompt})", Skipped: true})
+}
+
+func TestScriptRun_Failures(t *testing.T) {
+	dir := writeFlows(t, map[string]string{"flows.yml": testFlowYAML, "greeting.test.yml": `
+flow: flows.yml
+tests:
+  - name: names are asked again
+    steps:
+      - user: Anna
+        bot: "Bye, Anna!"
+  - steps:
+      - user: ""
+    expect:
+      memory:
+        name: Anna
+  - steps:
+      - user: ""
+      - bot: "Bye"
+`})
+	var out bytes.Buffer
+	sr := newScriptRun("", &out)
+
+	err := sr.file(filepath.Join(dir, "greeting.test.yml"))
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, 3, sr.failed)
+	assert.Contains(t, out.String(), `names are asked again: step 1: bot said ["What is your name?"], expected ["Bye, Anna!"]`)
+	assert.Contains(t, out.String(), `test 2: memory name is "", expected "Anna"`)
+	assert.Contains(t, out.String(), `test 3: step 2: user is missing`)
+	assert.Equal(t, 100.0, sr.reports()[0].Percent())
+}
+
+func TestLoadFlows_SkipsScripts(t *testing.T) {
+	flows, err := loadFlows(writeFlows(t, map[string]string{"greeting.yml": testFlowYAML, "greeting.test.yml": greetingScript}), nil)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"greeting"}, flows.names)
+}
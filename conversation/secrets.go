@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// secretMask replaces secret values in transcripts, traces, the operator API
+// and prompts.
+const secretMask = "****"
+
+// secrets returns the memory keys holding sensitive values: the input, the
+// captured groups and the date of secret states, and the secret fields to
+// extract with the input they are extracted from.
+func (s *States) secrets() map[string]bool {
+	keys := make(map[string]bool)
+	for _, state := range s.States {
+		if state.Secret {
+			for _, key := range inputKeys(state) {
+				keys[key] = true
+			}
+		}
+		for _, f := range state.Extract {
+			if f.Secret {
+				// the whole answer holds the field as well
+				keys[f.Name] = true
+				if state.Input != "" {
+					keys[state.Input] = true
+				}
+			}
+		}
+	}
+
+	return keys
+}
+
+// inputKeys are the memory keys the state stores the answer under.
+func inputKeys(state State) []string {
+	var keys []string
+	if state.Input != "" {
+		keys = append(keys, state.Input)
+	}
+	if state.Capture != "" {
+		if re, err := regexp.Compile(state.Capture); err == nil {
+			for _, name := range re.SubexpNames() {
+				if name != "" {
+					keys = append(keys, name)
+				}
+			}
+		}
+	}
+	if state.Date != "" {
+		keys = append(keys, state.Date)
+	}
+
+	return keys
+}
+
+// secretInput tells whether answers to the state hold secrets: the state is
+// secret or extracts secret fields.
+func (s *State) secretInput() bool {
+	if s.Secret {
+		return true
+	}
+	for _, f := range s.Extract {
+		if f.Secret {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maskInput is the answer to the state as transcripts, traces and the
+// moderation endpoint get it: masked as a whole when it may hold secrets, or
+// with the known secret values masked.
+func (e *Engine) maskInput(m memory, state *State, input string) string {
+	if state != nil && state.secretInput() {
+		return secretMask
+	}
+
+	return e.redact(m, input)
+}
+
+// redact masks every secret value of the memory found in the text.
+func (e *Engine) redact(m memory, text string) string {
+	for key := range e.states.secrets() {
+		if value := m[key]; value != "" {
+			text = strings.ReplaceAll(text, value, secretMask)
+		}
+	}
+
+	return text
+}
+
+// redactError masks the secret values in the message of err.
+func (e *Engine) redactError(m memory, err error) error {
+	if msg := e.redact(m, err.Error()); msg != err.Error() {
+		return errors.New(msg)
+	}
+
+	return err
+}
+
+// redactMemory returns a copy of the memory with the secret values masked.
+func (e *Engine) redactMemory(m memory) memory {
+	secrets := e.states.secrets()
+
+	redacted := make(memory, len(m))
+	for k, v := range m {
+		if secrets[k] && v != "" {
+			v = secretMask
+		}
+		redacted[k] = v
+	}
+
+	return redacted
+}
+
+// prompt prepares text for the model: secret values are masked unless the
+// settings allow sending them.
+func (e *Engine) prompt(m memory, settings *LLM, text string) string {
+	if settings != nil && settings.AllowSecrets {
+		return text
+	}
+
+	return e.redact(m, text)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const secretsFlowYAML = `
+states:
+  - id: 0
+    text: "Your PIN?"
+    input: pin
+    secret: true
+    next:
+      right: 1
+  - id: 1
+    text: "Anything else?"
+    input: note
+    next:
+      right: 2
+  - id: 2
+    text: "Thanks, {note}"
+`
+
+func TestEngine_SecretInputMasked(t *testing.T) {
+	engine := newTestEngine(t, secretsFlowYAML)
+	recorder := &TraceRecorder{}
+	engine.Observe(recorder)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	_, err := engine.Answer(session, "1234")
+	assert.NoError(t, err)
+	_, _ = engine.Answer(session, "my pin is 1234")
+
+	// Assertions
+	assert.Equal(t, "1234", session.Memory["pin"])
+	for _, entry := range session.Transcript {
+		assert.NotContains(t, entry.Text, "1234")
+	}
+	for _, event := range recorder.Events {
+		assert.NotContains(t, event.Value, "1234")
+	}
+	assert.Contains(t, recorder.Events, TraceEvent{Type: eventInput, StateID: 0, Value: secretMask})
+	assert.Contains(t, recorder.Events, TraceEvent{Type: eventInput, StateID: 1, Value: "my pin is " + secretMask})
+}
+
+func TestEngine_RedactMemory(t *testing.T) {
+	engine := newTestEngine(t, secretsFlowYAML)
+
+	redacted := engine.redactMemory(memory{"pin": "1234", "note": "hi", "empty": ""})
+
+	// Assertions
+	assert.Equal(t, memory{"pin": secretMask, "note": "hi", "empty": ""}, redacted)
+}
+
+func TestEngine_SecretCaptureAndDate(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    text: "Your card number?"
+    capture: '(?P<card>\d{4}) (?P<expiry>\d{2}/\d{2})'
+    secret: true
+    next:
+      right: 1
+  - id: 1
+    text: "When were you born?"
+    date: birthday
+    secret: true
+    next:
+      right: 2
+  - id: 2
+    text: "Card {card} valid until {expiry}, born {birthday}"
+`)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	_, err := engine.Answer(session, "it is 4242 12/29")
+	assert.NoError(t, err)
+	texts, err := engine.Answer(session, "2001-05-17")
+	assert.NoError(t, err)
+
+	// Assertions
+	assert.Equal(t, map[string]bool{"card": true, "expiry": true, "birthday": true}, engine.states.secrets())
+	assert.Equal(t, "4242", session.Memory["card"])
+	assert.Contains(t, texts[0], "Card 4242 valid until 12/29")
+	for _, entry := range session.Transcript {
+		assert.NotContains(t, entry.Text, "4242")
+		assert.NotContains(t, entry.Text, "12/29")
+		assert.NotContains(t, entry.Text, session.Memory["birthday"])
+	}
+	redacted := engine.redactMemory(session.Memory)
+	assert.Equal(t, secretMask, redacted["card"])
+	assert.Equal(t, secretMask, redacted["expiry"])
+	assert.Equal(t, secretMask, redacted["birthday"])
+}
+
+func TestEngine_SecretExtractField(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    text: "Your name and card?"
+    input: intro
+    extract:
+      - name: name
+      - name: card
+        secret: true
+    next:
+      right: 1
+  - id: 1
+    text: "Thanks {name}"
+`)
+	engine.llm = &chatStub{calls: map[string][]string{saveFieldsFunction: {`{"name": "Anna", "card": "4111222233334444"}`}}}
+	recorder := &TraceRecorder{}
+	engine.Observe(recorder)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	texts, err := engine.Answer(session, "Anna, my card is 4111222233334444")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Thanks Anna"}, texts)
+	assert.Equal(t, "4111222233334444", session.Memory["card"])
+	for _, entry := range session.Transcript {
+		assert.NotContains(t, entry.Text, "4111222233334444")
+	}
+	for _, event := range recorder.Events {
+		assert.NotContains(t, event.Value, "4111222233334444")
+	}
+	assert.Contains(t, recorder.Events, TraceEvent{Type: eventInput, StateID: 0, Value: secretMask})
+	assert.Equal(t, memory{"intro": secretMask, "name": "Anna", "card": secretMask}, engine.redactMemory(session.Memory))
+}
+
+func TestEngine_Prompt(t *testing.T) {
+	engine := newTestEngine(t, secretsFlowYAML)
+	m := memory{"pin": "1234"}
+
+	// Assertions
+	assert.Equal(t, "pin "+secretMask, engine.prompt(m, nil, "pin 1234"))
+	assert.Equal(t, "pin 1234", engine.prompt(m, &LLM{AllowSecrets: true}, "pin 1234"))
+}
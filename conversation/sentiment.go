@@ -0,0 +1,174 @@
+package main
+
+import (
+	"OpenAI-api/api/model"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// sentimentKey, toxicityKey and flaggedKey are the memory keys holding
+	// the classification of the user's last message
+	sentimentKey = "_sentiment"
+	toxicityKey  = "_toxicity"
+	flaggedKey   = "_flagged"
+
+	classifierChat       = "chat"
+	classifierModeration = "moderation"
+
+	classifyMessageFunction = "classify_message"
+
+	defaultSentimentPrompt = "Classify the user's message to a customer service bot."
+)
+
+var classifyMessageParameters = json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "sentiment": {"type": "string", "enum": ["positive", "neutral", "negative", "angry"], "description": "the user's mood"},
+    "toxicity": {"type": "number", "description": "how insulting, hateful or threatening the message is, from 0 to 1"}
+  },
+  "required": ["sentiment", "toxicity"]
+}`)
+
+// Sentiment classifies every answer of the user before the state's
+// conditions are evaluated, so flows can route on it, e.g. hand angry users
+// off with right-if: "equals({_sentiment}, 'angry')". The chat classifier
+// stores {_sentiment} (positive, neutral, negative or angry) and {_toxicity}
+// (0 to 1); the moderations endpoint stores {_toxicity}, its highest category
+// score, and {_flagged}. Answers to secret states are not classified.
+type Sentiment struct {
+	// Classifier is chat, the default, or moderation
+	Classifier string `yaml:"classifier" json:"classifier,omitempty" toml:"classifier,omitempty"`
+	// Prompt describes the bot to the chat classifier
+	Prompt string `yaml:"prompt" json:"prompt,omitempty" toml:"prompt,omitempty"`
+	LLM    *LLM   `yaml:"llm" json:"llm,omitempty" toml:"llm,omitempty"`
+}
+
+// moderator checks texts with an OpenAI compatible moderations endpoint.
+type moderator interface {
+	Moderate(input string) (*moderation, error)
+}
+
+type moderation struct {
+	Flagged bool
+	Scores  map[string]float64
+}
+
+func (c *openAIClient) Moderate(input string) (*moderation, error) {
+	var resp model.ModerationsResponse
+	if err := post(c, "/moderations", &model.ModerationsRequestBody{Input: input}, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Results) == 0 {
+		return nil, fmt.Errorf("moderation returned no results")
+	}
+
+	return &moderation{Flagged: resp.Results[0].Flagged, Scores: resp.Results[0].CategoryScores}, nil
+}
+
+// classifyMessage stores the classification of the answer in memory. Errors
+// are reported to the observers and leave the keys unset, so the flow goes on
+// as if nothing was detected.
+func (e *Engine) classifyMessage(s *Session, state *State, input string) {
+	delete(s.Memory, sentimentKey)
+	delete(s.Memory, toxicityKey)
+	delete(s.Memory, flaggedKey)
+
+	if state.Secret {
+		return
+	}
+
+	var err error
+	if e.states.Sentiment.Classifier == classifierModeration {
+		err = e.moderate(s, e.maskInput(s.Memory, state, input))
+	} else {
+		err = e.classifySentiment(s, e.prompt(s.Memory, e.states.Sentiment.LLM, input))
+	}
+	if err != nil {
+		e.reportError(s, fmt.Errorf("state %d: sentiment: %w", state.ID, err))
+	}
+}
+
+func (e *Engine) classifySentiment(s *Session, input string) error {
+	if e.llm == nil {
+		return errNoLLM
+	}
+
+	sentiment := e.states.Sentiment
+	prompt := sentiment.Prompt
+	if prompt == "" {
+		prompt = defaultSentimentPrompt
+	}
+
+	body := chatRequest(sentiment.LLM,
+		model.Message{Role: "system", Content: prompt},
+		model.Message{Role: "user", Content: input},
+	)
+	body.Functions = []model.Function{{
+		Name:        classifyMessageFunction,
+		Description: "Save the classification of the user's message.",
+		Parameters:  classifyMessageParameters,
+	}}
+	body.FunctionCall = map[string]string{"name": classifyMessageFunction}
+
+	resp, err := e.llm.Chat(body)
+	if err != nil {
+		return err
+	}
+
+	call := resp.Choices[0].Message.FunctionCall
+	if call == nil {
+		return fmt.Errorf("model did not call %s", classifyMessageFunction)
+	}
+
+	var class struct {
+		Sentiment string  `json:"sentiment"`
+		Toxicity  float64 `json:"toxicity"`
+	}
+	if err := json.Unmarshal([]byte(call.Arguments), &class); err != nil {
+		return fmt.Errorf("invalid %s arguments: %w", classifyMessageFunction, err)
+	}
+
+	if class.Sentiment != "" {
+		s.Memory[sentimentKey] = class.Sentiment
+	}
+	s.Memory[toxicityKey] = formatScore(class.Toxicity)
+
+	return nil
+}
+
+func (e *Engine) moderate(s *Session, input string) error {
+	if e.moderator == nil {
+		return errNoLLM
+	}
+
+	m, err := e.moderator.Moderate(input)
+	if err != nil {
+		return err
+	}
+
+	toxicity := 0.0
+	for _, score := range m.Scores {
+		if score > toxicity {
+			toxicity = score
+		}
+	}
+	s.Memory[toxicityKey] = formatScore(toxicity)
+	s.Memory[flaggedKey] = strconv.FormatBool(m.Flagged)
+
+	return nil
+}
+
+// formatScore formats a score between 0 and 1 with two decimals.
+func formatScore(score float64) string {
+	switch {
+	case score < 0:
+		score = 0
+	case score > 1:
+		score = 1
+	}
+
+	return strconv.FormatFloat(score, 'f', 2, 64)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sentimentFlowYAML = `
+sentiment:
+  prompt: "Classify messages to the ACME support bot."
+states:
+  - id: 0
+    text: "What's the problem?"
+    input: problem
+    next:
+      right: 2
+      right-if: "equals({_sentiment}, 'angry')"
+      left: 1
+  - id: 1
+    text: "Let's sort it out ({_sentiment}, {_toxicity})."
+  - id: 2
+    text: "Sorry about that, a colleague takes over."
+`
+
+type moderatorStub struct {
+	result *moderation
+	inputs []string
+}
+
+func (m *moderatorStub) Moderate(input string) (*moderation, error) {
+	m.inputs = append(m.inputs, input)
+	return m.result, nil
+}
+
+func TestEngine_SentimentRouting(t *testing.T) {
+	engine := newTestEngine(t, sentimentFlowYAML)
+	stub := &chatStub{calls: map[string][]string{classifyMessageFunction: {`{"sentiment": "angry", "toxicity": 0.8}`}}}
+	engine.llm = stub
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	texts, err := engine.Answer(session, "This is the third time it broke!!")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Sorry about that, a colleague takes over."}, texts)
+	assert.Equal(t, "angry", session.Memory[sentimentKey])
+	assert.Equal(t, "0.80", session.Memory[toxicityKey])
+
+	assert.Len(t, stub.requests, 1)
+	assert.Equal(t, "Classify messages to the ACME support bot.", stub.requests[0].Messages[0].Content)
+	assert.Equal(t, "This is the third time it broke!!", stub.requests[0].Messages[1].Content)
+	assert.Equal(t, map[string]string{"name": classifyMessageFunction}, stub.requests[0].FunctionCall)
+}
+
+func TestEngine_SentimentCalm(t *testing.T) {
+	engine := newTestEngine(t, sentimentFlowYAML)
+	engine.llm = &chatStub{calls: map[string][]string{classifyMessageFunction: {`{"sentiment": "neutral", "toxicity": 0}`}}}
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	texts, err := engine.Answer(session, "my order is late")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Let's sort it out (neutral, 0.00)."}, texts)
+}
+
+func TestEngine_SentimentModeration(t *testing.T) {
+	engine := newTestEngine(t, `
+sentiment:
+  classifier: moderation
+states:
+  - id: 0
+    text: "What's the problem?"
+    input: problem
+    next:
+      right: 2
+      right-if: "greater({_toxicity}, 0.7)"
+      left: 1
+  - id: 1
+    text: "Let's sort it out."
+  - id: 2
+    text: "Please stay polite ({_flagged})."
+`)
+	stub := &moderatorStub{result: &moderation{Flagged: true, Scores: map[string]float64{"harassment": 0.91, "violence": 0.2}}}
+	engine.moderator = stub
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	texts, err := engine.Answer(session, "you useless bot")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Please stay polite (true)."}, texts)
+	assert.Equal(t, "0.91", session.Memory[toxicityKey])
+	assert.Equal(t, []string{"you useless bot"}, stub.inputs)
+}
+
+func TestEngine_ModerationMasksSecrets(t *testing.T) {
+	engine := newTestEngine(t, `
+sentiment:
+  classifier: moderation
+states:
+  - id: 0
+    text: "Your PIN?"
+    input: pin
+    secret: true
+    next:
+      right: 1
+  - id: 1
+    text: "What's the problem?"
+    input: problem
+    next:
+      right: 2
+  - id: 2
+    text: "Your card?"
+    input: payment
+    extract:
+      - name: card
+        secret: true
+    next:
+      right: 3
+  - id: 3
+`)
+	engine.llm = &chatStub{calls: map[string][]string{saveFieldsFunction: {`{"card": "4111222233334444"}`}}}
+	stub := &moderatorStub{result: &moderation{Scores: map[string]float64{}}}
+	engine.moderator = stub
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	_, _ = engine.Answer(session, "1234")
+	_, _ = engine.Answer(session, "my PIN 1234 is refused")
+	_, err := engine.Answer(session, "it is 4111222233334444")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"my PIN " + secretMask + " is refused", secretMask}, stub.inputs)
+}
+
+func TestEngine_SentimentSkipsSecrets(t *testing.T) {
+	engine := newTestEngine(t, strings.Replace(sentimentFlowYAML, "    input: problem\n", "    input: problem\n    secret: true\n", 1))
+	stub := &chatStub{calls: map[string][]string{classifyMessageFunction: {`{"sentiment": "angry", "toxicity": 1}`}}}
+	engine.llm = stub
+	session := NewSession()
+	session.Memory[sentimentKey] = "angry"
+	_, _ = engine.Start(session)
+
+	_, err := engine.Answer(session, "hunter2")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Empty(t, stub.requests)
+	assert.NotContains(t, session.Memory, sentimentKey)
+	assert.Equal(t, int64(1), session.StateID)
+}
+
+func TestEngine_SentimentWithoutLLM(t *testing.T) {
+	engine := newTestEngine(t, sentimentFlowYAML)
+	recorder := &TraceRecorder{}
+	engine.Observe(recorder)
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	_, err := engine.Answer(session, "hello")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), session.StateID)
+	assert.Contains(t, recorder.Events, TraceEvent{Type: eventError, StateID: 0, Err: "state 0: sentiment: " + errNoLLM.Error()})
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
+	"github.com/spf13/viper"
+)
+
+// liveSession is a session served over HTTP. Its mutex serializes the user's
+// messages and the operator's actions.
+type liveSession struct {
+	id      string
+	flow    *flow
+	mu      sync.Mutex
+	session *Session
+}
+
+// engine returns the engine of the flow the session is in.
+func (ls *liveSession) engine() *Engine {
+	return ls.flow.engine.of(ls.session)
+}
+
+// flowName names the flow the session is in.
+func (ls *liveSession) flowName() string {
+	if ls.session.Flow != "" {
+		return ls.session.Flow
+	}
+
+	return ls.flow.name
+}
+
+type sessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*liveSession
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: make(map[string]*liveSession)}
+}
+
+func (st *sessionStore) add(f *flow, session *Session) *liveSession {
+	ls := &liveSession{id: newSessionID(), flow: f, session: session}
+
+	st.mu.Lock()
+	st.sessions[ls.id] = ls
+	st.mu.Unlock()
+
+	return ls
+}
+
+func (st *sessionStore) remove(id string) {
+	st.mu.Lock()
+	delete(st.sessions, id)
+	st.mu.Unlock()
+}
+
+func (st *sessionStore) get(id string) *liveSession {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	return st.sessions[id]
+}
+
+// each calls fn for every session while holding the session's lock.
+func (st *sessionStore) each(fn func(ls *liveSession)) {
+	st.mu.RLock()
+	all := make([]*liveSession, 0, len(st.sessions))
+	for _, ls := range st.sessions {
+		all = append(all, ls)
+	}
+	st.mu.RUnlock()
+
+	for _, ls := range all {
+		ls.mu.Lock()
+		fn(ls)
+		ls.mu.Unlock()
+	}
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("failed to generate session id: %w", err))
+	}
+
+	return hex.EncodeToString(b)
+}
+
+type server struct {
+	flows       *flowRegistry
+	defaultFlow string // flow of sessions started without one
+	sessions    *sessionStore
+	timers      *timers // timeouts of the sessions' states
+}
+
+func newServer(flows *flowRegistry, defaultFlow string) *server {
+	return &server{
+		flows:       flows,
+		defaultFlow: defaultFlow,
+		sessions:    newSessionStore(),
+		timers:      newTimers(),
+	}
+}
+
+// schedule sets the timer of the session's next timeout, if its state has
+// one. The texts of timeouts reach clients through the session's messages.
+// Call it holding the session's lock.
+func (srv *server) schedule(ls *liveSession) {
+	at, ok := ls.engine().Deadline(ls.session)
+	if !ok {
+		srv.timers.cancel(ls.id)
+		return
+	}
+
+	srv.timers.schedule(ls.id, at, func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+
+		if srv.sessions.get(ls.id) != ls {
+			return
+		}
+
+		// errors are reported to the observers
+		_, _ = ls.engine().Timeout(ls.session)
+		srv.schedule(ls)
+	})
+}
+
+func (srv *server) routes(e *echo.Echo) {
+	// flow registry
+	e.GET("/flows", srv.handleListFlows)
+	e.GET("/flows/:flow", srv.handleGetFlow)
+
+	// users
+	e.POST("/sessions", srv.handleNewSession)
+	e.POST("/flows/:flow/sessions", srv.handleNewSession)
+	e.POST("/sessions/:id/messages", srv.handleMessage)
+	e.GET("/sessions/:id/messages", srv.handleHistory)
+}
+
+type sessionReply struct {
+	ID       string   `json:"id"`
+	Flow     string   `json:"flow"`
+	Messages []string `json:"messages"`
+	Done     bool     `json:"done"`
+	Handoff  bool     `json:"handoff"`
+	Replies  []string `json:"replies,omitempty"`
+	Seq      int      `json:"seq"`
+
+	// Typing lists how many milliseconds to show a typing indicator before
+	// each message, for flows that pace their messages
+	Typing []int64 `json:"typing,omitempty"`
+}
+
+type newSessionRequest struct {
+	Flow string `json:"flow" query:"flow"`
+}
+
+type messageRequest struct {
+	Text string `json:"text"`
+	Seq  *int   `json:"seq"` // when set, the session must not have changed since
+}
+
+func (srv *server) reply(ls *liveSession, texts []string) sessionReply {
+	if texts == nil {
+		texts = []string{}
+	}
+
+	reply := sessionReply{
+		ID:       ls.id,
+		Flow:     ls.flowName(),
+		Messages: texts,
+		Done:     ls.session.Done,
+		Handoff:  ls.session.Handoff != nil,
+		Replies:  ls.engine().Replies(ls.session),
+		Seq:      ls.session.Seq(),
+	}
+
+	for i, text := range texts {
+		if d := ls.engine().Typing(ls.session, text); d > 0 {
+			if reply.Typing == nil {
+				reply.Typing = make([]int64, len(texts))
+			}
+			reply.Typing[i] = d.Milliseconds()
+		}
+	}
+
+	return reply
+}
+
+// flow picks the flow to start a session of: the named one, the default one,
+// or the only one hosted.
+func (srv *server) flow(name string) (*flow, error) {
+	if name == "" {
+		name = srv.defaultFlow
+	}
+	if name == "" && len(srv.flows.names) == 1 {
+		name = srv.flows.names[0]
+	}
+	if name == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "required parameters are not set (required: flow)")
+	}
+
+	f := srv.flows.get(name)
+	if f == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "flow not found")
+	}
+	if f.err != nil {
+		return nil, echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("flow %s is invalid: %v", f.name, f.err))
+	}
+
+	return f, nil
+}
+
+// checkSeq fails with a conflict when the client expects the session at
+// another event than its last one, i.e. somebody else wrote to it meanwhile.
+func checkSeq(s *Session, seq *int) error {
+	if seq != nil && *seq != s.Seq() {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("session is at event %d, not %d", s.Seq(), *seq))
+	}
+
+	return nil
+}
+
+// handleNewSession starts a session of the flow in the path, the request or
+// the server's default flow.
+func (srv *server) handleNewSession(c echo.Context) error {
+	var req newSessionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if name := c.Param("flow"); name != "" {
+		req.Flow = name
+	}
+
+	f, err := srv.flow(req.Flow)
+	if err != nil {
+		return err
+	}
+
+	ls := srv.sessions.add(f, f.engine.NewSession())
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	texts, err := f.engine.Start(ls.session)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	srv.schedule(ls)
+
+	return c.JSON(http.StatusCreated, srv.reply(ls, texts))
+}
+
+func (srv *server) handleMessage(c echo.Context) error {
+	var req messageRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ls := srv.sessions.get(c.Param("id"))
+	if ls == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "session not found")
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if err := checkSeq(ls.session, req.Seq); err != nil {
+		return err
+	}
+
+	texts, err := ls.engine().Answer(ls.session, req.Text)
+	if errors.Is(err, errConversationOver) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	srv.schedule(ls)
+
+	return c.JSON(http.StatusOK, srv.reply(ls, texts))
+}
+
+// handleHistory returns the transcript entries after ?after=n, so clients can
+// poll for operator messages.
+func (srv *server) handleHistory(c echo.Context) error {
+	ls := srv.sessions.get(c.Param("id"))
+	if ls == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "session not found")
+	}
+
+	after := 0
+	if p := c.QueryParam("after"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "after must be a non-negative integer")
+		}
+		after = n
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	entries := []transcriptEntry{}
+	if after < len(ls.session.Transcript) {
+		entries = append(entries, ls.session.Transcript[after:]...)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"messages": entries,
+		"next":     len(ls.session.Transcript),
+	})
+}
+
+func serveCommand(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flowPath := flags.String("flow", "./conversation.yml", "conversation flow file (.yml, .yaml, .json or .toml), or with -flows the name of the default flow")
+	flowsDir := flags.String("flows", "", "directory of the flows to host, flows.dir of the config by default")
+	configPath := flags.String("config", "./config.yaml", "configuration file with the openAI settings")
+	addr := flags.String("addr", ":8081", "address to listen on")
+	adminToken := flags.String("admin-token", "", "token of the admin API, admin.token of the config by default; the API is off without one")
+	operatorToken := flags.String("operator-token", "", "token of the operator API, operator.token of the config by default; the API is off without one")
+	_ = flags.Parse(args)
+
+	if err := loadConfig(*configPath); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	*flowsDir = flowDirectory(*flowsDir)
+
+	var flows *flowRegistry
+	var defaultFlow string
+	if *flowsDir != "" {
+		var err error
+		if flows, err = loadFlows(*flowsDir, setupLLM); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, info := range flows.list() {
+			if !info.Valid {
+				fmt.Printf("flow %s: %s\n", info.Name, info.Error)
+			}
+		}
+
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name == "flow" {
+				defaultFlow = *flowPath
+			}
+		})
+	} else {
+		states, err := loadStates(*flowPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		defaultFlow = flowName(*flowPath)
+		flows = newFlowRegistry()
+		flows.add(defaultFlow, *flowPath, states, nil, setupLLM)
+		flows.checkTransfers()
+		if err := flows.get(defaultFlow).err; err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	e := echo.New()
+	e.Logger.SetLevel(log.INFO)
+	e.Logger.SetOutput(os.Stdout)
+	e.Logger.SetHeader("${time_rfc3339} ${level}")
+	e.Use(middleware.Logger())
+	e.Use(middleware.Recover())
+
+	if *adminToken == "" {
+		*adminToken = viper.GetString("admin.token")
+	}
+	if *operatorToken == "" {
+		*operatorToken = viper.GetString("operator.token")
+	}
+
+	srv := newServer(flows, defaultFlow)
+	srv.routes(e)
+	srv.adminRoutes(e, *adminToken)
+	srv.operatorRoutes(e, *operatorToken)
+
+	e.Logger.Fatal(e.Start(*addr))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T, flow string) (*server, *echo.Echo) {
+	states, err := parseStates([]byte(flow), ".yml")
+	assert.NoError(t, err)
+
+	flows := newFlowRegistry()
+	flows.add("test", "", states, nil, nil)
+	srv := newServer(flows, "")
+	e := echo.New()
+	srv.routes(e)
+
+	return srv, e
+}
+
+// do sends a JSON request to the server and decodes the JSON response into out.
+func do(t *testing.T, e *echo.Echo, method, path, body string, out interface{}) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if out != nil {
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), out))
+	}
+
+	return rec
+}
+
+func TestServer_Conversation(t *testing.T) {
+	_, e := newTestServer(t, testFlowYAML)
+
+	var started sessionReply
+	rec := do(t, e, http.MethodPost, "/sessions", "", &started)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.NotEmpty(t, started.ID)
+	assert.Equal(t, []string{"Hello, I'm a bot.", "What is your name?"}, started.Messages)
+
+	var reply sessionReply
+	rec = do(t, e, http.MethodPost, "/sessions/"+started.ID+"/messages", `{"text": ""}`, &reply)
+
+	// Assertions
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, sessionReply{ID: started.ID, Flow: "test", Messages: []string{"Bye, !"}, Done: true, Seq: 8}, reply)
+
+	rec = do(t, e, http.MethodPost, "/sessions/"+started.ID+"/messages", `{"text": "hello?"}`, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestServer_History(t *testing.T) {
+	_, e := newTestServer(t, testFlowYAML)
+
+	var started sessionReply
+	do(t, e, http.MethodPost, "/sessions", "", &started)
+	do(t, e, http.MethodPost, "/sessions/"+started.ID+"/messages", `{"text": "Anna"}`, nil)
+
+	var history struct {
+		Messages []transcriptEntry `json:"messages"`
+		Next     int               `json:"next"`
+	}
+	rec := do(t, e, http.MethodGet, "/sessions/"+started.ID+"/messages?after=2", "", &history)
+
+	// Assertions
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 4, history.Next)
+	assert.Len(t, history.Messages, 2)
+	assert.Equal(t, roleUser, history.Messages[0].Role)
+	assert.Equal(t, "Anna", history.Messages[0].Text)
+	assert.Equal(t, roleBot, history.Messages[1].Role)
+
+	rec = do(t, e, http.MethodGet, "/sessions/"+started.ID+"/messages?after=x", "", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_SessionNotFound(t *testing.T) {
+	_, e := newTestServer(t, testFlowYAML)
+
+	rec := do(t, e, http.MethodPost, "/sessions/missing/messages", `{"text": "hi"}`, nil)
+
+	// Assertions
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
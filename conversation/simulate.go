@@ -0,0 +1,482 @@
+package main
+
+import (
+	"OpenAI-api/api/model"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// literalRe matches quoted literals in conditions, e.g. 'bye' in contains({prompt}, 'bye')
+var literalRe = regexp.MustCompile(`'([^']*)'|"([^"]*)"`)
+
+type edge struct {
+	From int64
+	To   int64
+	Kind string
+}
+
+func (e edge) String() string {
+	return fmt.Sprintf("%d -%s-> %d", e.From, e.Kind, e.To)
+}
+
+// walkIssue is a problem found while walking the flow, grouped by state and message.
+type walkIssue struct {
+	StateID int64
+	Message string
+	Inputs  []string // inputs of the first walk that ran into the issue
+	Count   int
+}
+
+type simulationReport struct {
+	Walks     int
+	Completed int
+	Start     int64
+
+	States        []int64
+	Edges         []edge
+	VisitedStates map[int64]int
+	TakenEdges    map[edge]int
+
+	// static findings
+	DeadEnds    []edge
+	NoTerminal  []int64
+	Unreachable []int64
+	Unknown     []string // hooks and conditions calling functions that are not registered
+
+	// findings of the walks
+	Loops   []*walkIssue
+	Crashes []*walkIssue
+}
+
+func (r *simulationReport) HasProblems() bool {
+	return len(r.DeadEnds) > 0 || len(r.NoTerminal) > 0 || len(r.Unknown) > 0 || len(r.Loops) > 0 || len(r.Crashes) > 0
+}
+
+type simulator struct {
+	BaseObserver
+
+	report   *simulationReport
+	states   *States
+	engine   *Engine
+	rnd      *rand.Rand
+	maxTurns int
+}
+
+// simulate walks the flow with generated inputs and reports dead ends, states
+// that can't reach a terminal state, endless loops, hook crashes and coverage.
+func simulate(states *States, walks, maxTurns int, seed int64) *simulationReport {
+	sim := &simulator{
+		states:   states,
+		engine:   NewEngine(states),
+		rnd:      rand.New(rand.NewSource(seed)),
+		maxTurns: maxTurns,
+	}
+
+	r := &simulationReport{
+		Walks:         walks,
+		Start:         states.Start,
+		VisitedStates: make(map[int64]int),
+		TakenEdges:    make(map[edge]int),
+	}
+	sim.report = r
+	sim.analyze(r)
+
+	llm := &simulatedLLM{rnd: sim.rnd}
+	sim.engine.llm, sim.engine.embedder, sim.engine.moderator = llm, llm, llm
+	sim.engine.dryRun = true
+	sim.engine.output = io.Discard
+
+	sim.engine.Observe(sim)
+
+	for i := 0; i < walks; i++ {
+		sim.walk(r)
+	}
+
+	return r
+}
+
+func (sim *simulator) StateEntered(s *Session, stateID int64) {
+	sim.report.VisitedStates[stateID]++
+}
+
+func (sim *simulator) TransitionChosen(s *Session, t Transition) {
+	sim.report.TakenEdges[edge{From: t.From, To: t.To, Kind: t.Edge}]++
+}
+
+// analyze finds problems visible from the flow graph alone.
+func (sim *simulator) analyze(r *simulationReport) {
+	reverse := make(map[int64][]int64)
+	forward := make(map[int64][]int64)
+	var terminals []int64
+
+	for _, state := range sim.states.States {
+		r.States = append(r.States, state.ID)
+
+		var jumps []edge
+		hooks := append(append(append(Actions(nil), state.Before...), setActions(state.Set)...), state.After...)
+		for _, a := range hooks {
+			if a.Goto != nil {
+				jumps = append(jumps, edge{From: state.ID, To: *a.Goto, Kind: edgeError})
+			}
+			if a.expr() == "" {
+				continue
+			}
+			if c, err := parseCall(a.expr()); err != nil {
+				r.Unknown = append(r.Unknown, fmt.Sprintf("state %d: %v", state.ID, err))
+			} else if c.Name != "" && sim.engine.functions[c.Name] == nil {
+				r.Unknown = append(r.Unknown, fmt.Sprintf("state %d: unknown function %q", state.ID, c.Name))
+			}
+		}
+
+		if state.Timeout != nil && state.Timeout.Next != nil {
+			jumps = append(jumps, edge{From: state.ID, To: *state.Timeout.Next, Kind: edgeTimeout})
+		}
+
+		// jumps on errors and timeouts are not expected to be taken, they only
+		// keep their targets reachable
+		for _, e := range jumps {
+			if sim.states.GetState(e.To) == nil {
+				r.DeadEnds = append(r.DeadEnds, e)
+				continue
+			}
+			forward[e.From] = append(forward[e.From], e.To)
+			reverse[e.To] = append(reverse[e.To], e.From)
+		}
+
+		// the operator decides where a handed off conversation continues, and
+		// transferred ones continue in another flow
+		if state.Next == nil || state.Handoff != nil || state.Transfer != nil {
+			terminals = append(terminals, state.ID)
+			continue
+		}
+
+		if state.Next.RightIf != "" {
+			if c, err := parseCall(state.Next.RightIf); err != nil {
+				r.Unknown = append(r.Unknown, fmt.Sprintf("state %d: %v", state.ID, err))
+			} else if sim.engine.filters[c.Name] == nil {
+				r.Unknown = append(r.Unknown, fmt.Sprintf("state %d: unknown filter %q, right-if never holds", state.ID, c.Name))
+			}
+		}
+
+		for _, e := range transitions(&state) {
+			r.Edges = append(r.Edges, e)
+			if sim.states.GetState(e.To) == nil {
+				r.DeadEnds = append(r.DeadEnds, e)
+				continue
+			}
+			forward[e.From] = append(forward[e.From], e.To)
+			reverse[e.To] = append(reverse[e.To], e.From)
+		}
+	}
+
+	canFinish := reachable(terminals, reverse)
+	roots := []int64{sim.states.Start}
+	if sim.states.OnError != nil {
+		roots = append(roots, *sim.states.OnError)
+	}
+	fromStart := reachable(roots, forward)
+
+	for _, id := range r.States {
+		if !canFinish[id] {
+			r.NoTerminal = append(r.NoTerminal, id)
+		}
+		if !fromStart[id] {
+			r.Unreachable = append(r.Unreachable, id)
+		}
+	}
+}
+
+// transitions lists the right, left and case edges of the state.
+func transitions(state *State) []edge {
+	if state.Next == nil {
+		return nil
+	}
+
+	out := []edge{{From: state.ID, To: state.Next.RightId, Kind: edgeRight}}
+	if state.Next.RightIf != "" || state.Next.LeftId != 0 {
+		out = append(out, edge{From: state.ID, To: state.Next.LeftId, Kind: edgeLeft})
+	}
+	for _, c := range state.Next.Cases {
+		out = append(out, edge{From: state.ID, To: c.To, Kind: edgeCase})
+	}
+
+	return out
+}
+
+func reachable(from []int64, graph map[int64][]int64) map[int64]bool {
+	seen := make(map[int64]bool)
+	queue := append([]int64(nil), from...)
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		queue = append(queue, graph[id]...)
+	}
+
+	return seen
+}
+
+// walk runs one conversation from the start state with generated inputs.
+func (sim *simulator) walk(r *simulationReport) {
+	session := sim.engine.NewSession()
+	var inputs []string
+	var recent []int64
+
+	defer func() {
+		if p := recover(); p != nil {
+			addIssue(&r.Crashes, session.StateID, fmt.Sprintf("panic: %v", p), inputs)
+		}
+	}()
+
+	_, err := sim.engine.Start(session)
+
+	for turn := 0; err == nil && !session.Done && session.Handoff == nil; turn++ {
+		if turn == sim.maxTurns {
+			addIssue(&r.Loops, session.StateID, fmt.Sprintf("no terminal state after %d answers, cycling through states %v", sim.maxTurns, distinct(recent)), inputs)
+			return
+		}
+
+		input := sim.input(sim.states.GetState(session.StateID))
+		inputs = append(inputs, input)
+		recent = append(recent, session.StateID)
+		if len(recent) > 10 {
+			recent = recent[1:]
+		}
+
+		_, err = sim.engine.Answer(session, input)
+	}
+
+	if err != nil {
+		addIssue(&r.Crashes, session.StateID, err.Error(), inputs)
+		return
+	}
+
+	r.Completed++
+}
+
+// input generates an answer for the state: literals its condition compares
+// with, an empty answer or a random string.
+func (sim *simulator) input(state *State) string {
+	candidates := []string{""}
+	if state.Next != nil {
+		for _, match := range literalRe.FindAllStringSubmatch(state.Next.RightIf, -1) {
+			literal := match[1] + match[2]
+			candidates = append(candidates, literal, "well, "+literal+" then")
+		}
+		for _, c := range state.Next.Cases {
+			candidates = append(candidates, c.examples()...)
+		}
+	}
+
+	if sim.rnd.Intn(2) == 0 {
+		return candidates[sim.rnd.Intn(len(candidates))]
+	}
+
+	return randomString(sim.rnd)
+}
+
+func randomString(rnd *rand.Rand) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.,!?'"
+
+	b := make([]byte, 1+rnd.Intn(16))
+	for i := range b {
+		b[i] = letters[rnd.Intn(len(letters))]
+	}
+
+	return string(b)
+}
+
+// simulatedLLM replaces the chat completions API during simulation. Function
+// calls get random values for a random subset of the requested properties, so
+// follow-up questions for missing fields are exercised as well. Properties
+// with an enum get one of its values.
+type simulatedLLM struct {
+	rnd *rand.Rand
+}
+
+func (l *simulatedLLM) Chat(body *model.ChatRequestBody) (*model.ChatResponse, error) {
+	message := model.Message{Role: "assistant", Content: randomString(l.rnd)}
+
+	if len(body.Functions) > 0 {
+		var parameters struct {
+			Properties map[string]struct {
+				Type string   `json:"type"`
+				Enum []string `json:"enum"`
+			} `json:"properties"`
+		}
+		if err := json.Unmarshal(body.Functions[0].Parameters, &parameters); err != nil {
+			return nil, err
+		}
+
+		names := make([]string, 0, len(parameters.Properties))
+		for name := range parameters.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		arguments := make(map[string]interface{})
+		for _, name := range names {
+			if l.rnd.Intn(3) == 0 {
+				continue
+			}
+			switch p := parameters.Properties[name]; {
+			case len(p.Enum) > 0:
+				arguments[name] = p.Enum[l.rnd.Intn(len(p.Enum))]
+			case p.Type == "number" || p.Type == "integer":
+				arguments[name] = l.rnd.Intn(10000)
+			case p.Type == "boolean":
+				arguments[name] = l.rnd.Intn(2) == 0
+			default:
+				arguments[name] = randomString(l.rnd)
+			}
+		}
+
+		data, err := json.Marshal(arguments)
+		if err != nil {
+			return nil, err
+		}
+		message.FunctionCall = &model.FunctionCall{Name: body.Functions[0].Name, Arguments: string(data)}
+	}
+
+	return &model.ChatResponse{Choices: []model.Choice{{Message: message}}}, nil
+}
+
+func (l *simulatedLLM) Moderate(input string) (*moderation, error) {
+	score := l.rnd.Float64()
+
+	return &moderation{Flagged: score > 0.5, Scores: map[string]float64{"harassment": score}}, nil
+}
+
+func (l *simulatedLLM) Embed(inputs []string) ([][]float64, error) {
+	embeddings := make([][]float64, len(inputs))
+	for i := range inputs {
+		embeddings[i] = make([]float64, 8)
+		for j := range embeddings[i] {
+			embeddings[i][j] = l.rnd.Float64()
+		}
+	}
+
+	return embeddings, nil
+}
+
+func addIssue(issues *[]*walkIssue, stateID int64, message string, inputs []string) {
+	for _, issue := range *issues {
+		if issue.StateID == stateID && issue.Message == message {
+			issue.Count++
+			return
+		}
+	}
+
+	*issues = append(*issues, &walkIssue{
+		StateID: stateID,
+		Message: message,
+		Inputs:  append([]string(nil), inputs...),
+		Count:   1,
+	})
+}
+
+func distinct(ids []int64) []int64 {
+	seen := make(map[int64]bool)
+	var out []int64
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+
+	return out
+}
+
+func (r *simulationReport) print(w io.Writer) {
+	fmt.Fprintf(w, "walks: %d, reached a terminal or handoff state: %d\n", r.Walks, r.Completed)
+
+	fmt.Fprintf(w, "\nstate coverage: %d/%d\n", len(r.VisitedStates), len(r.States))
+	for _, id := range r.States {
+		fmt.Fprintf(w, "  state %d: %d visits\n", id, r.VisitedStates[id])
+	}
+
+	fmt.Fprintf(w, "\nedge coverage: %d/%d\n", len(r.TakenEdges), len(r.Edges))
+	for _, e := range r.Edges {
+		fmt.Fprintf(w, "  %s: %d times\n", e, r.TakenEdges[e])
+	}
+
+	if len(r.DeadEnds) > 0 {
+		fmt.Fprintln(w, "\ndead ends (transitions to missing states):")
+		for _, e := range r.DeadEnds {
+			fmt.Fprintf(w, "  %s\n", e)
+		}
+	}
+
+	if len(r.NoTerminal) > 0 {
+		fmt.Fprintf(w, "\nstates with no way to a terminal state: %v\n", r.NoTerminal)
+	}
+
+	if len(r.Unreachable) > 0 {
+		fmt.Fprintf(w, "\nstates unreachable from state %d: %v\n", r.Start, r.Unreachable)
+	}
+
+	if len(r.Unknown) > 0 {
+		fmt.Fprintln(w, "\nunknown functions:")
+		for _, u := range r.Unknown {
+			fmt.Fprintf(w, "  %s\n", u)
+		}
+	}
+
+	printIssues(w, "endless loops", r.Loops)
+	printIssues(w, "crashes", r.Crashes)
+}
+
+func printIssues(w io.Writer, title string, issues []*walkIssue) {
+	if len(issues) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\n%s:\n", title)
+	for _, issue := range issues {
+		fmt.Fprintf(w, "  state %d: %s (%d walks)\n", issue.StateID, issue.Message, issue.Count)
+		fmt.Fprintf(w, "    inputs: %q\n", issue.Inputs)
+	}
+}
+
+func simulateCommand(args []string) {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	flowPath := flags.String("flow", "./conversation.yml", "conversation flow file (.yml, .yaml, .json or .toml), or the name of a flow in -flows")
+	flowsDir := flags.String("flows", "", "directory to look up flow names in, flows.dir of the config by default")
+	walks := flags.Int("walks", 1000, "number of conversations to simulate")
+	maxTurns := flags.Int("max-turns", 50, "answers after which a conversation is considered an endless loop")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "random seed, set it to reproduce a run")
+	_ = flags.Parse(args)
+
+	path, err := resolveFlow(*flowPath, flowDirectory(*flowsDir))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	states, err := loadStates(path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Println("seed:", *seed)
+	r := simulate(states, *walks, *maxTurns, *seed)
+	r.print(os.Stdout)
+
+	if r.HasProblems() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulate_HealthyFlow(t *testing.T) {
+	states, err := parseStates([]byte(testFlowYAML), ".yml")
+	assert.NoError(t, err)
+
+	r := simulate(states, 200, 50, 1)
+
+	// Assertions
+	assert.False(t, r.HasProblems())
+	assert.Equal(t, 200, r.Completed)
+	assert.Len(t, r.VisitedStates, 3)
+	assert.Len(t, r.TakenEdges, 3)
+	assert.Equal(t, len(r.Edges), len(r.TakenEdges))
+}
+
+func TestSimulate_StaticFindings(t *testing.T) {
+	flow := `
+states:
+  - id: 0
+    before: "shout({name})"
+    text: "Hi"
+    next:
+      right: 1
+  - id: 1
+    input: "answer"
+    next:
+      right: 5
+      right-if: "isYes({answer})"
+      left: 1
+  - id: 2
+    text: "Never shown"
+`
+	states, err := parseStates([]byte(flow), ".yml")
+	assert.NoError(t, err)
+
+	r := simulate(states, 10, 5, 1)
+
+	// Assertions
+	assert.True(t, r.HasProblems())
+	assert.Equal(t, []edge{{From: 1, To: 5, Kind: edgeRight}}, r.DeadEnds)
+	assert.Equal(t, []int64{0, 1}, r.NoTerminal)
+	assert.Equal(t, []int64{2}, r.Unreachable)
+	assert.Equal(t, []string{
+		`state 0: unknown function "shout"`,
+		`state 1: unknown filter "isYes", right-if never holds`,
+	}, r.Unknown)
+	assert.Len(t, r.Loops, 1)
+	assert.Equal(t, int64(1), r.Loops[0].StateID)
+	assert.Equal(t, 10, r.Loops[0].Count)
+	assert.Equal(t, "no terminal state after 5 answers, cycling through states [1]", r.Loops[0].Message)
+}
+
+func TestSimulate_Crashes(t *testing.T) {
+	flow := `
+states:
+  - id: 0
+    text: "Say something"
+    input: "prompt"
+    after: "explode({prompt})"
+    next:
+      right: 1
+  - id: 1
+    text: "Bye"
+`
+	states, err := parseStates([]byte(flow), ".yml")
+	assert.NoError(t, err)
+
+	ff["explode"] = func(s string) error {
+		if s == "" {
+			panic("empty input")
+		}
+		return errors.New("boom")
+	}
+	defer delete(ff, "explode")
+
+	r := simulate(states, 100, 5, 1)
+
+	// Assertions
+	assert.Equal(t, 0, r.Completed)
+	assert.Len(t, r.Crashes, 2)
+	messages := []string{r.Crashes[0].Message, r.Crashes[1].Message}
+	assert.ElementsMatch(t, []string{"panic: empty input", "state 0: after: boom"}, messages)
+	assert.Equal(t, 100, r.Crashes[0].Count+r.Crashes[1].Count)
+}
+
+func TestSimulationReport_Print(t *testing.T) {
+	states, err := parseStates([]byte(testFlowYAML), ".yml")
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	simulate(states, 10, 50, 1).print(&out)
+
+	// Assertions
+	assert.Contains(t, out.String(), "walks: 10, reached a terminal or handoff state: 10")
+	assert.Contains(t, out.String(), "state coverage: 3/3")
+	assert.Contains(t, out.String(), "1 -left-> 1:")
+}
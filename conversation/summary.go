@@ -0,0 +1,145 @@
+package main
+
+import (
+	"OpenAI-api/api/model"
+	"fmt"
+	"strings"
+)
+
+const (
+	// recapKey is the memory key holding the rolling summary of the earlier
+	// conversation
+	recapKey = "_recap"
+
+	defaultSummaryBudget = 2000
+	defaultSummaryKeep   = 6
+
+	// charsPerToken estimates the tokens of a text from its length
+	charsPerToken = 4
+
+	defaultSummaryPrompt = "Summarize the conversation between a user and an assistant for the assistant continuing it. Keep names, numbers, decisions, open questions and promises made. Reply with the summary only."
+)
+
+// Summarize keeps long conversations within the model's context: once the
+// transcript not summarized yet exceeds the budget, all but its last entries
+// are folded into a rolling summary kept in {_recap}. LLM calls get the
+// summary and the entries after it instead of the whole transcript.
+type Summarize struct {
+	// Budget is the estimated number of tokens of the transcript sent along
+	// before it is summarized, 2000 by default
+	Budget int `yaml:"budget" json:"budget,omitempty" toml:"budget,omitempty"`
+	// Keep is the number of recent entries kept out of the summary, 6 by default
+	Keep   int    `yaml:"keep" json:"keep,omitempty" toml:"keep,omitempty"`
+	Prompt string `yaml:"prompt" json:"prompt,omitempty" toml:"prompt,omitempty"`
+	LLM    *LLM   `yaml:"llm" json:"llm,omitempty" toml:"llm,omitempty"`
+}
+
+func (sm *Summarize) budget() int {
+	if sm.Budget <= 0 {
+		return defaultSummaryBudget
+	}
+
+	return sm.Budget
+}
+
+func (sm *Summarize) keep() int {
+	if sm.Keep <= 0 {
+		return defaultSummaryKeep
+	}
+
+	return sm.Keep
+}
+
+// history returns the conversation before the current input as chat
+// messages: the summary of the earlier conversation and the recent entries.
+// The entries are summarized first when they exceed the budget. Flows that
+// don't summarize send the last n entries.
+func (e *Engine) history(s *Session, n int) ([]model.Message, error) {
+	// the input being answered is the last entry
+	entries := s.Transcript
+	if len(entries) > 0 {
+		entries = entries[:len(entries)-1]
+	}
+
+	sm := e.states.Summarize
+	if sm == nil {
+		if len(entries) > n {
+			entries = entries[len(entries)-n:]
+		}
+		return chatMessages(entries), nil
+	}
+
+	if s.Summarized > len(entries) {
+		s.Summarized = len(entries)
+	}
+	if recent := entries[s.Summarized:]; estimateTokens(recent) > sm.budget() && len(recent) > sm.keep() {
+		if err := e.summarize(s, recent[:len(recent)-sm.keep()]); err != nil {
+			return nil, fmt.Errorf("summarize: %w", err)
+		}
+	}
+
+	var messages []model.Message
+	if recap := s.Memory[recapKey]; recap != "" {
+		messages = append(messages, model.Message{Role: "system", Content: "Summary of the earlier conversation:\n" + recap})
+	}
+
+	return append(messages, chatMessages(entries[s.Summarized:])...), nil
+}
+
+// summarize folds the entries into the session's summary.
+func (e *Engine) summarize(s *Session, entries []transcriptEntry) error {
+	if e.llm == nil {
+		return errNoLLM
+	}
+
+	sm := e.states.Summarize
+	prompt := sm.Prompt
+	if prompt == "" {
+		prompt = defaultSummaryPrompt
+	}
+
+	var dialog strings.Builder
+	if recap := s.Memory[recapKey]; recap != "" {
+		fmt.Fprintf(&dialog, "Summary so far:\n%s\n\nConversation since:\n", recap)
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(&dialog, "%s: %s\n", entry.Role, entry.Text)
+	}
+
+	resp, err := e.llm.Chat(chatRequest(sm.LLM,
+		model.Message{Role: "system", Content: prompt},
+		model.Message{Role: "user", Content: dialog.String()},
+	))
+	if err != nil {
+		return err
+	}
+
+	s.Memory[recapKey] = strings.TrimSpace(resp.Choices[0].Message.Content)
+	s.Summarized += len(entries)
+
+	return nil
+}
+
+// chatMessages turns transcript entries into chat messages; everything the
+// user didn't say was said by the assistant.
+func chatMessages(entries []transcriptEntry) []model.Message {
+	messages := make([]model.Message, 0, len(entries))
+	for _, entry := range entries {
+		role := "assistant"
+		if entry.Role == roleUser {
+			role = "user"
+		}
+		messages = append(messages, model.Message{Role: role, Content: entry.Text})
+	}
+
+	return messages
+}
+
+func estimateTokens(entries []transcriptEntry) int {
+	n := 0
+	for _, entry := range entries {
+		n += len(entry.Text)/charsPerToken + 1
+	}
+
+	return n
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"OpenAI-api/api/model"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngine_Summarize(t *testing.T) {
+	engine := newTestEngine(t, strings.Replace(fallbackFlowYAML, "fallback:", `summarize:
+  budget: 10
+  keep: 2
+fallback:`, 1))
+	stub := &chatStub{calls: onTopic(), replies: map[string]string{defaultSummaryPrompt: "The user asked about shipping times."}, reply: "Orders ship in 2 days."}
+	engine.llm = stub
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	_, err := engine.Answer(session, "how long does shipping take?")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Len(t, stub.requests, 2)
+	assert.NotContains(t, session.Memory, recapKey)
+
+	_, err = engine.Answer(session, "and returns?")
+
+	assert.NoError(t, err)
+	assert.Len(t, stub.requests, 5)
+	assert.Equal(t, "The user asked about shipping times.", session.Memory[recapKey])
+	assert.Equal(t, 3, session.Summarized)
+
+	// the first three entries are summarized, the answer gets the summary and the rest
+	summarized := stub.requests[3].Messages[1].Content
+	assert.Equal(t, "bot: Hi!\nbot: Do you want to track an order? (yes/no)\nuser: how long does shipping take?\n", summarized)
+	assert.Equal(t, []model.Message{
+		{Role: "system", Content: "Summary of the earlier conversation:\nThe user asked about shipping times."},
+		{Role: "assistant", Content: "Orders ship in 2 days."},
+		{Role: "assistant", Content: "Do you want to track an order? (yes/no)"},
+		{Role: "user", Content: "and returns?"},
+	}, stub.requests[4].Messages[1:])
+
+	// undoing the turn undoes the summary
+	assert.NoError(t, engine.Undo(session, 1))
+	assert.NotContains(t, session.Memory, recapKey)
+	assert.Equal(t, 0, session.Summarized)
+}
+
+func TestEngine_SummarizeRolls(t *testing.T) {
+	engine := newTestEngine(t, strings.Replace(fallbackFlowYAML, "fallback:", `summarize:
+  budget: 10
+  keep: 2
+fallback:`, 1))
+	stub := &chatStub{calls: onTopic(), replies: map[string]string{defaultSummaryPrompt: "Earlier questions."}, reply: "Orders ship in 2 days."}
+	engine.llm = stub
+	session := NewSession()
+	_, _ = engine.Start(session)
+
+	for _, input := range []string{"shipping?", "returns?", "refunds?"} {
+		_, err := engine.Answer(session, input)
+		assert.NoError(t, err)
+	}
+
+	// Assertions
+	last := stub.requests[len(stub.requests)-2]
+	assert.Equal(t, defaultSummaryPrompt, last.Messages[0].Content)
+	assert.True(t, strings.HasPrefix(last.Messages[1].Content, "Summary so far:\nEarlier questions.\n\nConversation since:\n"))
+	assert.Equal(t, 6, session.Summarized)
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultTelegramURL = "https://api.telegram.org"
+
+	// telegramPollTimeout is how long getUpdates waits for updates, in seconds
+	telegramPollTimeout = 30
+	telegramRetryDelay  = 3 * time.Second
+
+	// maxCallbackData is the size limit of an inline keyboard button's data
+	maxCallbackData = 64
+
+	telegramStart = "/start"
+)
+
+// telegramBot connects a flow to a Telegram Bot API compatible endpoint. Each
+// chat is a session; quick replies become inline keyboard buttons.
+type telegramBot struct {
+	engine   *Engine
+	client   *http.Client
+	endpoint string // base URL followed by /bot<token>
+	timeout  int
+
+	offset int64
+
+	mu      sync.Mutex // guards chats
+	chats   map[int64]*telegramSession
+	timers  *timers
+	sending sync.WaitGroup
+}
+
+// telegramSession is the session of a chat. Its lock serializes the chat's
+// updates and timeouts; the messages they produce are sent in order from the
+// outbox, without holding it, so slow sends hold up no other chat.
+type telegramSession struct {
+	mu      sync.Mutex
+	session *Session
+
+	outMu    sync.Mutex // guards outbox and draining
+	outbox   []telegramOutgoing
+	draining bool
+}
+
+// telegramOutgoing is a message to send, after showing the typing indicator
+// for the delay when it is set.
+type telegramOutgoing struct {
+	msg    telegramSend
+	typing time.Duration
+}
+
+func newTelegramBot(engine *Engine, baseURL, token string) *telegramBot {
+	return &telegramBot{
+		engine:   engine,
+		client:   &http.Client{Timeout: (telegramPollTimeout + 10) * time.Second},
+		endpoint: strings.TrimRight(baseURL, "/") + "/bot" + token,
+		timeout:  telegramPollTimeout,
+		chats:    make(map[int64]*telegramSession),
+		timers:   newTimers(),
+	}
+}
+
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+type telegramUpdate struct {
+	UpdateID      int64             `json:"update_id"`
+	Message       *telegramMessage  `json:"message"`
+	CallbackQuery *telegramCallback `json:"callback_query"`
+}
+
+type telegramMessage struct {
+	Chat telegramChat `json:"chat"`
+	Text string       `json:"text"`
+}
+
+type telegramChat struct {
+	ID int64 `json:"id"`
+}
+
+type telegramCallback struct {
+	ID      string           `json:"id"`
+	Data    string           `json:"data"`
+	Message *telegramMessage `json:"message"`
+}
+
+type telegramButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type telegramKeyboard struct {
+	InlineKeyboard [][]telegramButton `json:"inline_keyboard"`
+}
+
+type telegramSend struct {
+	ChatID      int64             `json:"chat_id"`
+	Text        string            `json:"text"`
+	ReplyMarkup *telegramKeyboard `json:"reply_markup,omitempty"`
+}
+
+// callError drops the URL from errors of requests, as it holds the token.
+func callError(method string, err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", method, urlErr.Err)
+	}
+
+	return err
+}
+
+// call posts a Bot API method and decodes its result into out.
+func (b *telegramBot) call(ctx context.Context, method string, params, out interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint+"/"+method, bytes.NewReader(data))
+	if err != nil {
+		return callError(method, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return callError(method, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	var result telegramResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	}
+	if !result.OK {
+		return fmt.Errorf("%s: %s", method, result.Description)
+	}
+
+	if out != nil {
+		return json.Unmarshal(result.Result, out)
+	}
+
+	return nil
+}
+
+// run polls for updates until the context is cancelled, retrying after
+// failed polls.
+func (b *telegramBot) run(ctx context.Context) {
+	defer b.sending.Wait()
+	defer b.timers.stop()
+
+	for {
+		err := b.poll(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Println("telegram:", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(telegramRetryDelay):
+			}
+		}
+	}
+}
+
+// poll fetches the pending updates with long polling and handles them.
+func (b *telegramBot) poll(ctx context.Context) error {
+	var updates []telegramUpdate
+	err := b.call(ctx, "getUpdates", map[string]interface{}{
+		"offset":          b.offset,
+		"timeout":         b.timeout,
+		"allowed_updates": []string{"message", "callback_query"},
+	}, &updates)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range updates {
+		b.offset = u.UpdateID + 1
+		if err := b.handle(ctx, u); err != nil {
+			log.Println("telegram:", err)
+		}
+	}
+
+	return nil
+}
+
+// handle answers a text message or a pressed button.
+func (b *telegramBot) handle(ctx context.Context, u telegramUpdate) error {
+	var chatID int64
+	var text string
+
+	switch {
+	case u.Message != nil:
+		chatID, text = u.Message.Chat.ID, u.Message.Text
+	case u.CallbackQuery != nil && u.CallbackQuery.Message != nil:
+		chatID, text = u.CallbackQuery.Message.Chat.ID, u.CallbackQuery.Data
+		if err := b.call(ctx, "answerCallbackQuery", map[string]string{"callback_query_id": u.CallbackQuery.ID}, nil); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	chat := b.chat(chatID)
+	chat.mu.Lock()
+
+	texts, err := b.converse(chat, text)
+	if err != nil {
+		texts = append(texts, "Sorry, something went wrong.")
+		log.Printf("telegram: chat %d: %v", chatID, b.engine.redactError(chat.session.Memory, err))
+	}
+	b.schedule(ctx, chatID, chat, chat.session)
+	out := b.outgoing(chatID, chat.session, texts)
+
+	chat.mu.Unlock()
+	b.deliver(ctx, chat, out)
+
+	return nil
+}
+
+// chat returns the chat's session, adding an empty one the first time.
+func (b *telegramBot) chat(chatID int64) *telegramSession {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	chat := b.chats[chatID]
+	if chat == nil {
+		chat = &telegramSession{}
+		b.chats[chatID] = chat
+	}
+
+	return chat
+}
+
+// schedule sets the timer of the chat's next timeout, if its state has one.
+// Call it holding the chat's lock.
+func (b *telegramBot) schedule(ctx context.Context, chatID int64, chat *telegramSession, session *Session) {
+	key := strconv.FormatInt(chatID, 10)
+	at, ok := b.engine.Deadline(session)
+	if !ok {
+		b.timers.cancel(key)
+		return
+	}
+
+	b.timers.schedule(key, at, func() {
+		chat.mu.Lock()
+		if chat.session != session || ctx.Err() != nil {
+			chat.mu.Unlock()
+			return
+		}
+
+		texts, err := b.engine.Timeout(session)
+		if err != nil {
+			texts = append(texts, "Sorry, something went wrong.")
+			log.Printf("telegram: chat %d: %v", chatID, b.engine.redactError(session.Memory, err))
+		}
+		b.schedule(ctx, chatID, chat, session)
+		out := b.outgoing(chatID, session, texts)
+
+		chat.mu.Unlock()
+		b.deliver(ctx, chat, out)
+	})
+}
+
+// converse passes the text to the chat's session. /start, or any message
+// after the conversation ended, starts a new one. Call it holding the chat's
+// lock.
+func (b *telegramBot) converse(chat *telegramSession, text string) ([]string, error) {
+	if chat.session == nil || chat.session.Done || strings.TrimSpace(text) == telegramStart {
+		chat.session = b.engine.NewSession()
+
+		return b.engine.Start(chat.session)
+	}
+
+	return b.engine.Answer(chat.session, text)
+}
+
+// outgoing makes messages of the texts, with the quick replies as buttons
+// under the last one. Flows pacing their messages show the typing indicator
+// first. Call it holding the chat's lock.
+func (b *telegramBot) outgoing(chatID int64, session *Session, texts []string) []telegramOutgoing {
+	out := make([]telegramOutgoing, 0, len(texts))
+	for i, text := range texts {
+		msg := telegramSend{ChatID: chatID, Text: text}
+		if i == len(texts)-1 {
+			msg.ReplyMarkup = keyboard(b.engine.Replies(session))
+		}
+		out = append(out, telegramOutgoing{msg: msg, typing: b.engine.Typing(session, text)})
+	}
+
+	return out
+}
+
+// deliver queues the messages of the chat, sending them in order in the
+// background.
+func (b *telegramBot) deliver(ctx context.Context, chat *telegramSession, out []telegramOutgoing) {
+	chat.outMu.Lock()
+	defer chat.outMu.Unlock()
+
+	chat.outbox = append(chat.outbox, out...)
+	if chat.draining || len(chat.outbox) == 0 {
+		return
+	}
+	chat.draining = true
+
+	b.sending.Add(1)
+	go func() {
+		defer b.sending.Done()
+
+		for {
+			chat.outMu.Lock()
+			if len(chat.outbox) == 0 || ctx.Err() != nil {
+				chat.outbox, chat.draining = nil, false
+				chat.outMu.Unlock()
+				return
+			}
+			next := chat.outbox[0]
+			chat.outbox = chat.outbox[1:]
+			chat.outMu.Unlock()
+
+			if err := b.send(ctx, next); err != nil {
+				log.Println("telegram:", err)
+			}
+		}
+	}()
+}
+
+// send sends the message, showing the typing indicator for its delay first.
+func (b *telegramBot) send(ctx context.Context, out telegramOutgoing) error {
+	if out.typing > 0 {
+		if err := b.call(ctx, "sendChatAction", map[string]interface{}{"chat_id": out.msg.ChatID, "action": "typing"}, nil); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(out.typing):
+		}
+	}
+
+	return b.call(ctx, "sendMessage", out.msg, nil)
+}
+
+// checkNoHandoff fails for flows handing off to operators, in the flow or the
+// flows it transfers to: the operator API is part of conversation serve, so
+// nobody would ever answer the chat.
+func checkNoHandoff(engine *Engine) error {
+	seen := map[*Engine]bool{}
+	for todo := []*Engine{engine}; len(todo) > 0; todo = todo[1:] {
+		e := todo[0]
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+
+		for _, state := range e.states.States {
+			if state.Handoff != nil {
+				name := e.name
+				if name == "" {
+					name = "flow"
+				}
+				return fmt.Errorf("%s: state %d hands off to operators, which only conversation serve offers", name, state.ID)
+			}
+			if state.Transfer != nil && e.lookup != nil {
+				if other := e.lookup(state.Transfer.Flow); other != nil {
+					todo = append(todo, other)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// keyboard lays out the quick replies one per row. Replies too long for the
+// button data are left out.
+func keyboard(replies []string) *telegramKeyboard {
+	var rows [][]telegramButton
+	for _, reply := range replies {
+		if len(reply) <= maxCallbackData {
+			rows = append(rows, []telegramButton{{Text: reply, CallbackData: reply}})
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return &telegramKeyboard{InlineKeyboard: rows}
+}
+
+func telegramCommand(args []string) {
+	flags := flag.NewFlagSet("telegram", flag.ExitOnError)
+	flowPath := flags.String("flow", "./conversation.yml", "conversation flow file (.yml, .yaml, .json or .toml), or the name of a flow in -flows")
+	flowsDir := flags.String("flows", "", "directory to look up flow names in, flows.dir of the config by default")
+	configPath := flags.String("config", "./config.yaml", "configuration file with the openAI and telegram settings")
+	token := flags.String("token", "", "bot token, telegram.token of the config by default")
+	baseURL := flags.String("url", "", "Bot API base URL, telegram.url of the config or "+defaultTelegramURL+" by default")
+	_ = flags.Parse(args)
+
+	if err := loadConfig(*configPath); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *token == "" {
+		*token = viper.GetString("telegram.token")
+	}
+	if *baseURL == "" {
+		*baseURL = viper.GetString("telegram.url")
+	}
+	if *baseURL == "" {
+		*baseURL = defaultTelegramURL
+	}
+	if *token == "" {
+		fmt.Println("no bot token, set -token or telegram.token")
+		os.Exit(1)
+	}
+
+	engine, _, err := loadEngine(*flowPath, flowDirectory(*flowsDir), setupLLM)
+	if err == nil {
+		err = checkNoHandoff(engine)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	newTelegramBot(engine, *baseURL, *token).run(ctx)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const telegramFlowYAML = `
+states:
+  - id: 0
+    text: "Track an order?"
+    input: choice
+    replies: ["yes", "no"]
+    next:
+      right: 1
+      right-if: "equals({choice}, 'yes')"
+      left: 2
+  - id: 1
+    text: "Tracking"
+  - id: 2
+    text: "Bye"
+`
+
+// telegramStub is a Bot API stub serving queued updates and recording the
+// calls made with the test token. Messages to the held chat wait for hold to
+// be closed.
+type telegramStub struct {
+	mu      sync.Mutex
+	updates []telegramUpdate
+	calls   map[string][]json.RawMessage
+
+	held int64
+	hold chan struct{}
+}
+
+func (st *telegramStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/bottest-token/")
+	var params json.RawMessage
+	_ = json.NewDecoder(r.Body).Decode(&params)
+
+	if st.hold != nil && method == "sendMessage" {
+		var msg telegramSend
+		if json.Unmarshal(params, &msg) == nil && msg.ChatID == st.held {
+			<-st.hold
+		}
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if method == r.URL.Path {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"ok": false, "description": "Unauthorized"}`))
+		return
+	}
+
+	st.calls[method] = append(st.calls[method], params)
+
+	result := interface{}(true)
+	if method == "getUpdates" {
+		result, st.updates = st.updates, nil
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// sent lists the messages sent to the chat.
+func (st *telegramStub) sent(t *testing.T, chatID int64) []telegramSend {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var sent []telegramSend
+	for _, params := range st.calls["sendMessage"] {
+		var msg telegramSend
+		assert.NoError(t, json.Unmarshal(params, &msg))
+		if msg.ChatID == chatID {
+			sent = append(sent, msg)
+		}
+	}
+
+	return sent
+}
+
+func newTestTelegramBot(t *testing.T, stub *telegramStub) *telegramBot {
+	stub.calls = make(map[string][]json.RawMessage)
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+
+	return newTelegramBot(newTestEngine(t, telegramFlowYAML), srv.URL+"/", "test-token")
+}
+
+func TestTelegramBot_Conversation(t *testing.T) {
+	stub := &telegramStub{updates: []telegramUpdate{
+		{UpdateID: 7, Message: &telegramMessage{Chat: telegramChat{ID: 1}, Text: "/start"}},
+		{UpdateID: 8, Message: &telegramMessage{Chat: telegramChat{ID: 2}, Text: "hi"}},
+		{UpdateID: 9, CallbackQuery: &telegramCallback{ID: "cb", Data: "yes", Message: &telegramMessage{Chat: telegramChat{ID: 1}}}},
+		{UpdateID: 10, Message: &telegramMessage{Chat: telegramChat{ID: 2}, Text: "no"}},
+	}}
+	bot := newTestTelegramBot(t, stub)
+
+	err := bot.poll(context.Background())
+	bot.sending.Wait()
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), bot.offset)
+	buttons := &telegramKeyboard{InlineKeyboard: [][]telegramButton{{{Text: "yes", CallbackData: "yes"}}, {{Text: "no", CallbackData: "no"}}}}
+	assert.Equal(t, []telegramSend{{ChatID: 1, Text: "Track an order?", ReplyMarkup: buttons}, {ChatID: 1, Text: "Tracking"}}, stub.sent(t, 1))
+	assert.Equal(t, []telegramSend{{ChatID: 2, Text: "Track an order?", ReplyMarkup: buttons}, {ChatID: 2, Text: "Bye"}}, stub.sent(t, 2))
+	assert.JSONEq(t, `{"callback_query_id": "cb"}`, string(stub.calls["answerCallbackQuery"][0]))
+	assert.JSONEq(t, `{"offset": 0, "timeout": 30, "allowed_updates": ["message", "callback_query"]}`, string(stub.calls["getUpdates"][0]))
+	assert.True(t, bot.chats[1].session.Done)
+
+	// a message after the end starts over
+	stub.updates = []telegramUpdate{{UpdateID: 11, Message: &telegramMessage{Chat: telegramChat{ID: 1}, Text: "again"}}}
+	assert.NoError(t, bot.poll(context.Background()))
+	bot.sending.Wait()
+	assert.Equal(t, "Track an order?", stub.sent(t, 1)[2].Text)
+	assert.False(t, bot.chats[1].session.Done)
+}
+
+func TestTelegramBot_SlowChat(t *testing.T) {
+	stub := &telegramStub{held: 1, hold: make(chan struct{})}
+	bot := newTestTelegramBot(t, stub)
+
+	stub.updates = []telegramUpdate{{UpdateID: 1, Message: &telegramMessage{Chat: telegramChat{ID: 1}, Text: "/start"}}}
+	assert.NoError(t, bot.poll(context.Background()))
+	stub.mu.Lock()
+	stub.updates = []telegramUpdate{{UpdateID: 2, Message: &telegramMessage{Chat: telegramChat{ID: 2}, Text: "/start"}}}
+	stub.mu.Unlock()
+	assert.NoError(t, bot.poll(context.Background()))
+
+	// Assertions
+	assert.Eventually(t, func() bool { return len(stub.sent(t, 2)) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Empty(t, stub.sent(t, 1))
+
+	close(stub.hold)
+	bot.sending.Wait()
+	assert.Len(t, stub.sent(t, 1), 1)
+}
+
+func TestCheckNoHandoff(t *testing.T) {
+	// Assertions
+	assert.NoError(t, checkNoHandoff(newTestEngine(t, telegramFlowYAML)))
+	assert.EqualError(t, checkNoHandoff(newTestEngine(t, handoffFlowYAML)), "flow: state 1 hands off to operators, which only conversation serve offers")
+}
+
+func TestTelegramBot_APIError(t *testing.T) {
+	stub := &telegramStub{}
+	bot := newTestTelegramBot(t, stub)
+	bot.endpoint = strings.Replace(bot.endpoint, "test-token", "wrong", 1)
+
+	err := bot.poll(context.Background())
+
+	// Assertions
+	assert.EqualError(t, err, "getUpdates: Unauthorized")
+}
+
+func TestTelegramBot_NetworkErrorHidesToken(t *testing.T) {
+	// a server that is closed refuses connections
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	bot := newTelegramBot(newTestEngine(t, telegramFlowYAML), srv.URL, "123456:SECRET")
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	bot.run(ctx)
+
+	// Assertions
+	assert.Contains(t, logged.String(), "telegram: getUpdates: ")
+	assert.NotContains(t, logged.String(), "SECRET")
+}
+
+func TestKeyboard(t *testing.T) {
+	// Assertions
+	assert.Nil(t, keyboard(nil))
+	assert.Nil(t, keyboard([]string{strings.Repeat("x", maxCallbackData+1)}))
+	assert.Len(t, keyboard([]string{"a", "b"}).InlineKeyboard, 2)
+}
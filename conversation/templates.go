@@ -0,0 +1,77 @@
+package main
+
+import "fmt"
+
+// expandTemplates merges the flow's defaults and the state's template into
+// every state of a decoded document, ahead of its validation. States override
+// templates, which override the defaults: objects like next and llm are
+// merged key by key, other values are replaced, and null drops an inherited
+// value, e.g. next: null for a terminal state.
+func expandTemplates(doc interface{}) error {
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	defaults, _ := root["defaults"].(map[string]interface{})
+	templates, _ := root["templates"].(map[string]interface{})
+
+	states, _ := root["states"].([]interface{})
+
+	var errs validationErrors
+	for i, item := range states {
+		state, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		expanded := merge(nil, defaults)
+		name, named := state["template"].(string)
+		if named {
+			template, ok := templates[name].(map[string]interface{})
+			if !ok {
+				errs = append(errs, validationError{fmt.Sprintf("states[%d].template", i), fmt.Sprintf("unknown template %q", name)})
+				continue
+			}
+			expanded = merge(expanded, template)
+		}
+		expanded = merge(expanded, state)
+		if named {
+			delete(expanded, "template")
+		}
+
+		states[i] = expanded
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// merge returns a copy of base with the values of override merged in.
+func merge(base, override map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+
+	for k, v := range override {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+
+		baseMap, ok1 := merged[k].(map[string]interface{})
+		overrideMap, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 {
+			merged[k] = merge(baseMap, overrideMap)
+			continue
+		}
+
+		merged[k] = v
+	}
+
+	return merged
+}
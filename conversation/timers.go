@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	edgeTimeout = "timeout"
+
+	defaultTypingSpeed = 40 // characters per second
+	defaultTypingMax   = 3 * time.Second
+)
+
+// Timeout acts when the user doesn't answer a state in time: it sends the
+// reminder, and once the reminders are used up moves on to the next state.
+// Every reminder restarts the clock.
+type Timeout struct {
+	After string `yaml:"after" json:"after" toml:"after"` // e.g. 30s or 5m
+	Text  string `yaml:"text" json:"text,omitempty" toml:"text,omitempty"`
+	// Repeat is the number of reminders sent, 1 by default
+	Repeat int    `yaml:"repeat" json:"repeat,omitempty" toml:"repeat,omitempty"`
+	Next   *int64 `yaml:"next" json:"next,omitempty" toml:"next,omitempty"`
+}
+
+func (t *Timeout) after() time.Duration {
+	d, _ := time.ParseDuration(t.After) // checked when the flow was loaded
+	return d
+}
+
+func (t *Timeout) reminders() int {
+	switch {
+	case t.Text == "":
+		return 0
+	case t.Repeat <= 0:
+		return 1
+	}
+
+	return t.Repeat
+}
+
+// Typing paces the bot's messages like somebody typing them. Channels that
+// can show a typing indicator show it for the message's delay before sending
+// the message.
+type Typing struct {
+	// Speed is the number of characters typed per second, 40 by default
+	Speed int `yaml:"speed" json:"speed,omitempty" toml:"speed,omitempty"`
+	// Max is the longest delay of a message, 3s by default
+	Max string `yaml:"max" json:"max,omitempty" toml:"max,omitempty"`
+}
+
+// Deadline returns when the state the session waits in times out, counting
+// from the session's last event.
+func (e *Engine) Deadline(s *Session) (time.Time, bool) {
+	if other := e.of(s); other != e {
+		return other.Deadline(s)
+	}
+	if s.Done || s.Paused || s.Handoff != nil || len(s.Events) == 0 {
+		return time.Time{}, false
+	}
+
+	state := e.states.GetState(s.StateID)
+	if state == nil || state.Timeout == nil {
+		return time.Time{}, false
+	}
+	if s.Reminded >= state.Timeout.reminders() && state.Timeout.Next == nil {
+		return time.Time{}, false
+	}
+
+	return s.Events[len(s.Events)-1].Time.Add(state.Timeout.after()), true
+}
+
+// Timeout sends the reminder of the state the session waits in, or moves on
+// to the state's timeout state, when its deadline has passed. Before that it
+// does nothing, so outdated timers are harmless.
+func (e *Engine) Timeout(s *Session) ([]string, error) {
+	if other := e.of(s); other != e {
+		return other.Timeout(s)
+	}
+
+	deadline, ok := e.Deadline(s)
+	if !ok || time.Now().Before(deadline) {
+		return nil, nil
+	}
+
+	state := e.states.GetState(s.StateID)
+	t := state.Timeout
+	if s.Reminded < t.reminders() {
+		s.Reminded++
+		return e.finish(s, []string{render(t.Text, s.Memory)}, nil)
+	}
+
+	e.follow(s, Transition{From: state.ID, To: *t.Next, Edge: edgeTimeout, Result: true})
+	s.Pending, s.Candidates = nil, nil
+
+	texts, err := e.run(s)
+
+	return e.finish(s, texts, err)
+}
+
+// Typing returns how long typing the text takes, nothing for flows that
+// don't pace their messages.
+func (e *Engine) Typing(s *Session, text string) time.Duration {
+	if other := e.of(s); other != e {
+		return other.Typing(s, text)
+	}
+
+	t := e.states.Typing
+	if t == nil {
+		return 0
+	}
+
+	speed, max := t.Speed, defaultTypingMax
+	if speed <= 0 {
+		speed = defaultTypingSpeed
+	}
+	if t.Max != "" {
+		max, _ = time.ParseDuration(t.Max) // checked when the flow was loaded
+	}
+
+	d := time.Duration(len([]rune(text))) * time.Second / time.Duration(speed)
+	if d > max {
+		return max
+	}
+
+	return d
+}
+
+// checkTimers reports the durations of the flow that don't parse.
+func (s *States) checkTimers() validationErrors {
+	var errs validationErrors
+	if s.Typing != nil && s.Typing.Max != "" {
+		if _, err := time.ParseDuration(s.Typing.Max); err != nil {
+			errs = append(errs, validationError{"typing.max", err.Error()})
+		}
+	}
+
+	for i, state := range s.States {
+		if state.Timeout == nil {
+			continue
+		}
+		if d, err := time.ParseDuration(state.Timeout.After); err != nil {
+			errs = append(errs, validationError{fmt.Sprintf("states[%d].timeout.after", i), err.Error()})
+		} else if d <= 0 {
+			errs = append(errs, validationError{fmt.Sprintf("states[%d].timeout.after", i), "must be positive"})
+		}
+	}
+
+	return errs
+}
+
+// timers schedules one function per key, like the timeout of each session a
+// channel serves. Scheduling a key again replaces its timer.
+type timers struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newTimers() *timers {
+	return &timers{timers: make(map[string]*time.Timer)}
+}
+
+func (t *timers) schedule(key string, at time.Time, fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if timer := t.timers[key]; timer != nil {
+		timer.Stop()
+	}
+	t.timers[key] = time.AfterFunc(time.Until(at), fn)
+}
+
+func (t *timers) cancel(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if timer := t.timers[key]; timer != nil {
+		timer.Stop()
+		delete(t.timers, key)
+	}
+}
+
+// stop cancels every timer.
+func (t *timers) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, timer := range t.timers {
+		timer.Stop()
+		delete(t.timers, key)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const timeoutFlowYAML = `
+states:
+  - id: 0
+    text: "Your order number?"
+    input: order
+    timeout:
+      after: 1m
+      text: "Are you still there?"
+      next: 2
+    next:
+      right: 1
+  - id: 1
+    text: "Looking up {order}."
+  - id: 2
+    text: "Closing the conversation."
+`
+
+// age moves the session's events back in time, as if the user didn't answer
+// for that long.
+func age(s *Session, d time.Duration) {
+	for i := range s.Events {
+		s.Events[i].Time = s.Events[i].Time.Add(-d)
+	}
+}
+
+func TestEngine_Timeout(t *testing.T) {
+	engine := newTestEngine(t, timeoutFlowYAML)
+	session := engine.NewSession()
+	_, _ = engine.Start(session)
+
+	deadline, ok := engine.Deadline(session)
+	texts, err := engine.Timeout(session)
+
+	// Assertions
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+	assert.NoError(t, err)
+	assert.Empty(t, texts, "not due yet")
+
+	age(session, time.Minute)
+	texts, err = engine.Timeout(session)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Are you still there?"}, texts)
+	assert.Equal(t, 1, session.Reminded)
+
+	age(session, time.Minute)
+	texts, err = engine.Timeout(session)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Closing the conversation."}, texts)
+	assert.True(t, session.Done)
+	assert.Contains(t, session.Events, SessionEvent{Seq: 4, Type: sessionTransition, Time: session.Events[3].Time, From: 0, To: 2, Edge: edgeTimeout})
+
+	_, ok = engine.Deadline(session)
+	assert.False(t, ok)
+}
+
+func TestEngine_TimeoutAnswerResetsReminders(t *testing.T) {
+	engine := newTestEngine(t, `
+states:
+  - id: 0
+    text: "Your order number?"
+    input: order
+    timeout:
+      after: 1m
+      text: "Still there?"
+    next:
+      right: 1
+      right-if: "isNumber({order})"
+      left: 0
+  - id: 1
+    text: "Looking up {order}."
+`)
+	session := engine.NewSession()
+	_, _ = engine.Start(session)
+	age(session, time.Minute)
+	_, _ = engine.Timeout(session)
+
+	_, remind := engine.Deadline(session)
+
+	// Assertions
+	assert.False(t, remind, "the only reminder was sent")
+
+	_, _ = engine.Answer(session, "what?")
+	_, remind = engine.Deadline(session)
+	assert.True(t, remind)
+	assert.Equal(t, 0, session.Reminded)
+}
+
+func TestEngine_Typing(t *testing.T) {
+	engine := newTestEngine(t, `
+typing:
+  speed: 10
+  max: 1s
+states:
+  - id: 0
+    text: "Hello"
+`)
+	session := engine.NewSession()
+
+	// Assertions
+	assert.Equal(t, 500*time.Millisecond, engine.Typing(session, "Hello"))
+	assert.Equal(t, time.Second, engine.Typing(session, "A much longer message"))
+	assert.Equal(t, time.Duration(0), newTestEngine(t, timeoutFlowYAML).Typing(session, "Hello"))
+}
+
+func TestParseStates_InvalidDurations(t *testing.T) {
+	_, err := parseStates([]byte(`
+typing:
+  max: soon
+states:
+  - id: 0
+    input: x
+    timeout:
+      after: "-5s"
+`), ".yml")
+
+	// Assertions
+	assert.EqualError(t, err, "invalid conversation:\n  typing.max: time: invalid duration \"soon\"\n  states[0].timeout.after: must be positive")
+}
+
+func TestServer_Timeout(t *testing.T) {
+	srv, e := newTestServer(t, `
+typing:
+  speed: 100
+states:
+  - id: 0
+    text: "Your order number?"
+    input: order
+    timeout:
+      after: 20ms
+      text: "Are you still there?"
+      repeat: 2
+    next:
+      right: 1
+  - id: 1
+    text: "Looking up {order}."
+`)
+	defer srv.timers.stop()
+
+	var started sessionReply
+	rec := do(t, e, http.MethodPost, "/sessions", "", &started)
+
+	// Assertions
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, []int64{180}, started.Typing)
+
+	assert.Eventually(t, func() bool {
+		var history struct {
+			Next int `json:"next"`
+		}
+		do(t, e, http.MethodGet, "/sessions/"+started.ID+"/messages", "", &history)
+		return history.Next == 3
+	}, time.Second, 10*time.Millisecond)
+
+	ls := srv.sessions.get(started.ID)
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	assert.Equal(t, "Are you still there?", ls.session.Transcript[2].Text)
+	assert.Equal(t, 2, ls.session.Reminded)
+}
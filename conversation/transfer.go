@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// summaryKey is the memory key holding the summary a transfer passes on
+	summaryKey = "_summary"
+
+	edgeTransfer = "transfer"
+
+	// maxTransfers limits the transfers of a turn, so flows transferring to
+	// each other without waiting for input can't recurse endlessly
+	maxTransfers = 10
+)
+
+// Transfer hands the conversation over to another flow instead of ending it.
+// The other flow starts with the carried memory values and the summary.
+type Transfer struct {
+	Flow    string   `yaml:"flow" json:"flow" toml:"flow"`
+	Carry   []string `yaml:"carry" json:"carry,omitempty" toml:"carry,omitempty"`       // memory keys passed on
+	Summary string   `yaml:"summary" json:"summary,omitempty" toml:"summary,omitempty"` // {_summary} of the other flow
+}
+
+// of returns the engine of the flow the session is in, which differs from e
+// once the session was transferred to another flow.
+func (e *Engine) of(s *Session) *Engine {
+	if s.Flow == "" || s.Flow == e.name || e.lookup == nil {
+		return e
+	}
+	if other := e.lookup(s.Flow); other != nil {
+		return other
+	}
+
+	return e
+}
+
+// transfer moves the session to the start of the other flow and runs it.
+// Errors of the other flow are reported and end the turn there. In dry runs
+// the conversation ends instead.
+func (e *Engine) transfer(s *Session, state *State, texts *[]string) error {
+	t := state.Transfer
+
+	carried := make(memory, len(t.Carry)+1)
+	for _, key := range t.Carry {
+		if v, ok := s.Memory[key]; ok {
+			carried[key] = v
+		}
+	}
+	if t.Summary != "" {
+		carried[summaryKey] = render(t.Summary, s.Memory)
+	}
+
+	if e.dryRun {
+		s.Done = true
+		return nil
+	}
+
+	if s.transfers >= maxTransfers {
+		return fmt.Errorf("state %d: transfer: more than %d transfers without user input", state.ID, maxTransfers)
+	}
+
+	var target *Engine
+	if e.lookup != nil {
+		target = e.lookup(t.Flow)
+	}
+	if target == nil {
+		return fmt.Errorf("state %d: transfer: no flow %q", state.ID, t.Flow)
+	}
+
+	e.notify(func(o Observer) {
+		o.StateExited(s, state.ID)
+		o.TransitionChosen(s, Transition{From: state.ID, To: target.states.Start, Edge: edgeTransfer, Condition: t.Flow, Result: true})
+	})
+
+	s.sync()
+	s.Flow, s.StateID, s.Memory = t.Flow, target.states.Start, carried
+	s.Pending, s.Candidates = nil, nil
+	if _, ok := carried[recapKey]; !ok {
+		s.Summarized = 0 // the other flow summarizes the whole conversation
+	}
+
+	s.transfers++
+	defer func() { s.transfers-- }()
+
+	more, err := target.run(s)
+	*texts = append(*texts, more...)
+	if err != nil {
+		target.reportError(s, err)
+	}
+
+	return nil
+}
+
+// checkTransfers makes the flows transferring in a cycle, to unknown or
+// invalid flows, or not carrying what the other flow expects, invalid. It
+// repeats until no more flows turn invalid, as that can break the flows
+// transferring to them.
+func (r *flowRegistry) checkTransfers() {
+	r.checkTransferCycles()
+
+	for {
+		invalid := make(map[string]error)
+		for _, name := range r.names {
+			f := r.flows[name]
+			if f.err != nil {
+				continue
+			}
+
+			for _, state := range f.states.States {
+				if state.Transfer == nil {
+					continue
+				}
+				if err := r.checkTransfer(state.Transfer); err != nil {
+					invalid[name] = fmt.Errorf("state %d: transfer: %w", state.ID, err)
+					break
+				}
+			}
+		}
+
+		if len(invalid) == 0 {
+			return
+		}
+		for name, err := range invalid {
+			f := r.flows[name]
+			f.err, f.engine = err, nil
+		}
+	}
+}
+
+func (r *flowRegistry) checkTransfer(t *Transfer) error {
+	target := r.get(t.Flow)
+	if target == nil {
+		return fmt.Errorf("no flow %q", t.Flow)
+	}
+	if target.err != nil {
+		return fmt.Errorf("flow %s is invalid", t.Flow)
+	}
+
+	carried := make(map[string]bool, len(t.Carry)+1)
+	for _, key := range t.Carry {
+		carried[key] = true
+	}
+	if t.Summary != "" {
+		carried[summaryKey] = true
+	}
+
+	var missing []string
+	for _, key := range target.states.Expects {
+		if !carried[key] {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("flow %s expects %v, not carried", t.Flow, missing)
+	}
+
+	return nil
+}
+
+// checkTransferCycles makes the flows transferring back to themselves,
+// directly or through other flows, invalid: each transfer runs the other flow
+// within the turn.
+func (r *flowRegistry) checkTransferCycles() {
+	targets := make(map[string][]string)
+	for _, name := range r.names {
+		if f := r.flows[name]; f.err == nil {
+			for _, state := range f.states.States {
+				if state.Transfer != nil {
+					targets[name] = append(targets[name], state.Transfer.Flow)
+				}
+			}
+		}
+	}
+
+	invalid := make(map[string]error)
+	for _, name := range r.names {
+		if cycle := transferCycle(targets, []string{name}, make(map[string]bool)); cycle != nil {
+			invalid[name] = fmt.Errorf("transfers in a cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	for name, err := range invalid {
+		f := r.flows[name]
+		f.err, f.engine = err, nil
+	}
+}
+
+// transferCycle returns the path of transfers leading from the last flow of
+// path back to its first, if any.
+func transferCycle(targets map[string][]string, path []string, seen map[string]bool) []string {
+	from := path[len(path)-1]
+	seen[from] = true
+
+	for _, to := range targets[from] {
+		if to == path[0] {
+			return append(path, to)
+		}
+		if seen[to] {
+			continue
+		}
+		if cycle := transferCycle(targets, append(path, to), seen); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
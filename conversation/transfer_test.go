@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var transferFlows = map[string]string{
+	"onboarding.yml": `
+states:
+  - id: 0
+    text: "Your name?"
+    input: name
+    next:
+      right: 1
+  - id: 1
+    text: "Your plan?"
+    input: plan
+    next:
+      right: 2
+  - id: 2
+    text: "Welcome aboard, {name}!"
+    transfer:
+      flow: support
+      carry: [name]
+      summary: "{name} signed up for the {plan} plan"
+`,
+	"support.yml": `
+expects: [name, _summary]
+states:
+  - id: 0
+    text: "Hi {name}, support here. I know: {_summary}. Any questions?"
+    input: question
+    next:
+      right: 1
+  - id: 1
+    text: "We'll get back to you about {question}."
+`,
+}
+
+func newTestRegistry(t *testing.T, files map[string]string) *flowRegistry {
+	flows, err := loadFlows(writeFlows(t, files), nil)
+	assert.NoError(t, err)
+
+	return flows
+}
+
+func TestEngine_Transfer(t *testing.T) {
+	engine := newTestRegistry(t, transferFlows).engine("onboarding")
+	session := engine.NewSession()
+	_, _ = engine.Start(session)
+	_, _ = engine.Answer(session, "Anna")
+
+	texts, err := engine.Answer(session, "pro")
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Welcome aboard, Anna!", "Hi Anna, support here. I know: Anna signed up for the pro plan. Any questions?"}, texts)
+	assert.Equal(t, "support", session.Flow)
+	assert.Equal(t, memory{"name": "Anna", summaryKey: "Anna signed up for the pro plan"}, session.Memory)
+	assert.False(t, session.Done)
+
+	// the onboarding engine hands the answers to the support flow
+	texts, err = engine.Answer(session, "invoices")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"We'll get back to you about invoices."}, texts)
+	assert.True(t, session.Done)
+
+	replayed := Replay(session.Events)
+	assert.Equal(t, "support", replayed.Flow)
+	assert.Equal(t, session.Memory, replayed.Memory)
+}
+
+func TestFlowRegistry_CheckTransfers(t *testing.T) {
+	files := map[string]string{
+		"a.yml": `states: [{id: 0, text: "A", transfer: {flow: b, carry: [name, email]}}]`,
+		"b.yml": `{expects: [name, email], states: [{id: 0, text: "B", transfer: {flow: c}}]}`,
+		"c.yml": `{states: [{id: 0, text: "C", transfer: {flow: nope}}]}`,
+		"d.yml": `{states: [{id: 0, text: "D"}]}`,
+	}
+
+	flows := newTestRegistry(t, files)
+
+	// Assertions
+	assert.EqualError(t, flows.get("c").err, `state 0: transfer: no flow "nope"`)
+	assert.EqualError(t, flows.get("b").err, "state 0: transfer: flow c is invalid")
+	assert.EqualError(t, flows.get("a").err, "state 0: transfer: flow b is invalid")
+	assert.NoError(t, flows.get("d").err)
+
+	files["a.yml"] = `states: [{id: 0, text: "A", transfer: {flow: b, carry: [name]}}]`
+	files["b.yml"] = `{expects: [name, email], states: [{id: 0, text: "B"}]}`
+	flows = newTestRegistry(t, files)
+	assert.EqualError(t, flows.get("a").err, "state 0: transfer: flow b expects [email], not carried")
+	assert.NoError(t, flows.get("b").err)
+}
+
+func TestFlowRegistry_TransferCycles(t *testing.T) {
+	files := map[string]string{
+		"a.yml": `states: [{id: 0, text: "A", transfer: {flow: b}}]`,
+		"b.yml": `states: [{id: 0, text: "B", transfer: {flow: a}}]`,
+		"c.yml": `states: [{id: 0, text: "C", transfer: {flow: a}}]`,
+		"d.yml": `states: [{id: 0, text: "D", transfer: {flow: d}}]`,
+	}
+
+	flows := newTestRegistry(t, files)
+
+	// Assertions
+	assert.EqualError(t, flows.get("a").err, "transfers in a cycle: a -> b -> a")
+	assert.EqualError(t, flows.get("b").err, "transfers in a cycle: b -> a -> b")
+	assert.EqualError(t, flows.get("c").err, "state 0: transfer: flow a is invalid")
+	assert.EqualError(t, flows.get("d").err, "transfers in a cycle: d -> d")
+}
+
+func TestEngine_TransferLimit(t *testing.T) {
+	// flows added without checking their transfers, like reloaded ones
+	flows := newFlowRegistry()
+	for name, other := range map[string]string{"a": "b", "b": "a"} {
+		states, err := parseStates([]byte(`states: [{id: 0, text: "`+name+`", transfer: {flow: `+other+`}}]`), ".yml")
+		assert.NoError(t, err)
+		flows.add(name, "", states, nil, nil)
+	}
+	engine := flows.engine("a")
+	recorder := &TraceRecorder{}
+	engine.Observe(recorder)
+	session := engine.NewSession()
+
+	texts, err := engine.Start(session)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Len(t, texts, maxTransfers+1)
+	assert.Equal(t, 0, session.transfers)
+	assert.Equal(t, TraceEvent{Type: eventError, StateID: 0, Err: "state 0: transfer: more than 10 transfers without user input"}, recorder.Events[len(recorder.Events)-1])
+}
+
+func TestEngine_TransferWithoutRegistry(t *testing.T) {
+	engine := newTestEngine(t, `states: [{id: 0, text: "Bye", transfer: {flow: support}}]`)
+	session := NewSession()
+
+	_, err := engine.Start(session)
+
+	// Assertions
+	assert.EqualError(t, err, `state 0: transfer: no flow "support"`)
+}
+
+func TestSimulate_TransferFlow(t *testing.T) {
+	states, err := parseStates([]byte(transferFlows["onboarding.yml"]), ".yml")
+	assert.NoError(t, err)
+
+	r := simulate(states, 20, 10, 1)
+
+	// Assertions
+	assert.False(t, r.HasProblems())
+	assert.Equal(t, 20, r.Completed)
+}
+
+func TestServer_Transfer(t *testing.T) {
+	e := echo.New()
+	newServer(newTestRegistry(t, transferFlows), "onboarding").routes(e)
+
+	var started, reply sessionReply
+	do(t, e, http.MethodPost, "/sessions", "", &started)
+	do(t, e, http.MethodPost, "/sessions/"+started.ID+"/messages", `{"text": "Anna"}`, nil)
+	do(t, e, http.MethodPost, "/sessions/"+started.ID+"/messages", `{"text": "pro"}`, &reply)
+
+	// Assertions
+	assert.Equal(t, "onboarding", started.Flow)
+	assert.Equal(t, "support", reply.Flow)
+	assert.False(t, reply.Done)
+}